@@ -0,0 +1,365 @@
+package align
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Gaps smaller than this many cells are aligned with a full edit-distance
+// table; larger ones are first split at words that are unique on both sides.
+const maxTableSize = 500 * 500
+
+var sentenceEnd = regexp.MustCompile(`[.!?…。！？]["'”’)\]]*$`)
+
+type scriptWord struct {
+	text  string // as written in the script
+	token string // normalized, for comparison
+}
+
+type timing struct {
+	start, end, score float64
+	matched           bool
+}
+
+// Align computes the timing of a known script by aligning it against the words
+// recognized by the ASR service. It returns a result with the exact wording of
+// the script, split into segments at line breaks and sentence ends, and timed
+// with the words of the ASR result. Script words the ASR did not recognize get
+// interpolated timings and a zero score, within the window of the media that was
+// transcribed. A window End of 0 is the end of the media.
+func Align(script string, asr *models.WhisperResult, window models.TimeRange) *models.WhisperResult {
+	sentences := splitSentences(script)
+
+	var words []scriptWord
+	for _, sentence := range sentences {
+		for _, w := range sentence {
+			words = append(words, scriptWord{text: w, token: normalize(w)})
+		}
+	}
+
+	var asrWords []models.Word
+	for _, seg := range asr.Segments {
+		asrWords = append(asrWords, seg.Words...)
+	}
+	asrTokens := make([]string, len(asrWords))
+	for i, w := range asrWords {
+		asrTokens[i] = normalize(w.Word)
+	}
+	scriptTokens := make([]string, len(words))
+	for i, w := range words {
+		scriptTokens[i] = w.token
+	}
+
+	pairs := make(map[int]int)
+	alignTokens(scriptTokens, asrTokens, 0, 0, pairs)
+
+	timings := make([]timing, len(words))
+	for i := range words {
+		if j, ok := pairs[i]; ok {
+			w := asrWords[j]
+			score := w.Score
+			if scriptTokens[i] != asrTokens[j] {
+				// Substitution: the timing is right, but the recognition was not
+				score /= 2
+			}
+			timings[i] = timing{start: w.Start, end: w.End, score: score, matched: true}
+		}
+	}
+	start, end := window.Start, window.End
+	if end <= 0 {
+		end = asr.Duration
+	}
+	if len(asrWords) > 0 && end <= start {
+		// The duration of the media is unknown
+		end = asrWords[len(asrWords)-1].End
+	}
+	interpolate(timings, words, start, end)
+
+	res := &models.WhisperResult{
+		Language: asr.Language,
+		Duration: asr.Duration,
+	}
+	i := 0
+	for _, sentence := range sentences {
+		seg := models.Segment{
+			ID:    models.NewSegmentID(),
+			Text:  strings.Join(sentence, " "),
+			Words: make([]models.Word, 0, len(sentence)),
+		}
+		var score float64
+		for k, w := range sentence {
+			t := timings[i]
+			text := w
+			if k > 0 {
+				text = " " + w
+			}
+			seg.Words = append(seg.Words, models.Word{Word: text, Start: t.start, End: t.end, Score: t.score})
+			score += t.score
+			i++
+		}
+		seg.Start = seg.Words[0].Start
+		seg.End = seg.Words[len(seg.Words)-1].End
+		seg.Score = score / float64(len(sentence))
+		res.Segments = append(res.Segments, seg)
+	}
+	res.Text = models.TextFromSegments(res.Segments)
+	return res
+}
+
+// splitSentences splits the script into lines, and lines into sentences. Each
+// sentence is returned as its words.
+func splitSentences(script string) [][]string {
+	var sentences [][]string
+	for _, line := range strings.Split(script, "\n") {
+		var current []string
+		for _, w := range strings.Fields(line) {
+			current = append(current, w)
+			if sentenceEnd.MatchString(w) {
+				sentences = append(sentences, current)
+				current = nil
+			}
+		}
+		if len(current) > 0 {
+			sentences = append(sentences, current)
+		}
+	}
+	return sentences
+}
+
+func normalize(word string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, word)
+}
+
+// alignTokens pairs indexes of a with indexes of b, storing a[i] -> b[j] (with
+// offsets applied) in pairs. Equal tokens and substitutions are paired, while
+// insertions and deletions are left out.
+func alignTokens(a, b []string, aOff, bOff int, pairs map[int]int) {
+	if len(a) == 0 || len(b) == 0 {
+		return
+	}
+	if len(a)*len(b) <= maxTableSize {
+		alignTable(a, b, aOff, bOff, false, pairs)
+		return
+	}
+
+	anchors := uniqueAnchors(a, b)
+	if len(anchors) == 0 {
+		alignWindow(a, b, aOff, bOff, pairs)
+		return
+	}
+
+	prevA, prevB := 0, 0
+	for _, anchor := range anchors {
+		alignTokens(a[prevA:anchor[0]], b[prevB:anchor[1]], aOff+prevA, bOff+prevB, pairs)
+		pairs[aOff+anchor[0]] = bOff + anchor[1]
+		prevA, prevB = anchor[0]+1, anchor[1]+1
+	}
+	alignTokens(a[prevA:], b[prevB:], aOff+prevA, bOff+prevB, pairs)
+}
+
+// alignWindow is used when there are no anchors to split at. It aligns a window
+// at the start of both sequences with a table, keeps the pairs of the first
+// half of it, and continues after the last kept pair.
+func alignWindow(a, b []string, aOff, bOff int, pairs map[int]int) {
+	const window = 500
+	n := window
+	if n > len(a) {
+		n = len(a)
+	}
+	m := n*len(b)/len(a) + window/5
+	if m > len(b) {
+		m = len(b)
+	}
+	local := make(map[int]int)
+	alignTable(a[:n], b[:m], 0, 0, n < len(a), local)
+
+	if n == len(a) || m == len(b) {
+		for i, j := range local {
+			pairs[aOff+i] = bOff + j
+		}
+		return
+	}
+
+	// Pairs are monotonic, so the last kept pair is the one with the highest i.
+	nextA, nextB := n/2, n/2*len(b)/len(a)
+	last := -1
+	for i, j := range local {
+		if i < n/2 {
+			pairs[aOff+i] = bOff + j
+			if i > last {
+				last = i
+			}
+		}
+	}
+	if last >= 0 {
+		nextA, nextB = last+1, local[last]+1
+	}
+	alignTokens(a[nextA:], b[nextB:], aOff+nextA, bOff+nextB, pairs)
+}
+
+// uniqueAnchors returns the longest increasing sequence of (i, j) pairs where
+// a[i] == b[j] and the token appears only once in both a and b.
+func uniqueAnchors(a, b []string) [][2]int {
+	countA := make(map[string]int)
+	countB := make(map[string]int)
+	indexB := make(map[string]int)
+	for _, t := range a {
+		countA[t]++
+	}
+	for j, t := range b {
+		countB[t]++
+		indexB[t] = j
+	}
+	var candidates [][2]int
+	for i, t := range a {
+		if t != "" && countA[t] == 1 && countB[t] == 1 {
+			candidates = append(candidates, [2]int{i, indexB[t]})
+		}
+	}
+
+	// Longest increasing subsequence on j (candidates are sorted by i).
+	tails := []int{}
+	prev := make([]int, len(candidates))
+	for k, c := range candidates {
+		pos := sort.Search(len(tails), func(x int) bool { return candidates[tails[x]][1] >= c[1] })
+		if pos > 0 {
+			prev[k] = tails[pos-1]
+		} else {
+			prev[k] = -1
+		}
+		if pos == len(tails) {
+			tails = append(tails, k)
+		} else {
+			tails[pos] = k
+		}
+	}
+	anchors := make([][2]int, len(tails))
+	k := -1
+	if len(tails) > 0 {
+		k = tails[len(tails)-1]
+	}
+	for x := len(tails) - 1; x >= 0; x-- {
+		anchors[x] = candidates[k]
+		k = prev[k]
+	}
+	return anchors
+}
+
+// alignTable aligns a and b with an edit-distance table and a traceback. If
+// openEnd is set, b may continue past the end of a: the words of b after the
+// best match for the end of a are left out for free, instead of being paired.
+func alignTable(a, b []string, aOff, bOff int, openEnd bool, pairs map[int]int) {
+	n, m := len(a), len(b)
+	cost := make([][]int32, n+1)
+	for i := range cost {
+		cost[i] = make([]int32, m+1)
+		cost[i][0] = int32(i)
+	}
+	for j := 0; j <= m; j++ {
+		cost[0][j] = int32(j)
+	}
+	for i := 1; i <= n; i++ {
+		for j := 1; j <= m; j++ {
+			sub := cost[i-1][j-1]
+			if a[i-1] != b[j-1] {
+				sub++
+			}
+			c := sub
+			if del := cost[i-1][j] + 1; del < c {
+				c = del
+			}
+			if ins := cost[i][j-1] + 1; ins < c {
+				c = ins
+			}
+			cost[i][j] = c
+		}
+	}
+
+	i, j := n, m
+	if openEnd {
+		for k := m - 1; k >= 0; k-- {
+			if cost[n][k] <= cost[n][j] {
+				j = k
+			}
+		}
+	}
+	for i > 0 && j > 0 {
+		sub := cost[i-1][j-1]
+		if a[i-1] != b[j-1] {
+			sub++
+		}
+		switch {
+		case cost[i][j] == sub:
+			pairs[aOff+i-1] = bOff + j - 1
+			i--
+			j--
+		case cost[i][j] == cost[i-1][j]+1:
+			i--
+		default:
+			j--
+		}
+	}
+}
+
+// interpolate gives a timing to the unmatched words, spreading each run of them
+// over the time between its matched neighbours, proportionally to their length.
+// Runs at the start and end of the script are spread from the start and up to
+// the end of the window.
+func interpolate(timings []timing, words []scriptWord, windowStart, windowEnd float64) {
+	for i := 0; i < len(timings); {
+		if timings[i].matched {
+			i++
+			continue
+		}
+		j := i
+		for j < len(timings) && !timings[j].matched {
+			j++
+		}
+		start := windowStart
+		if i > 0 {
+			start = timings[i-1].end
+		}
+		end := windowEnd
+		if j < len(timings) {
+			end = timings[j].start
+		}
+		start = clamp(start, windowStart, windowEnd)
+		end = clamp(end, windowStart, windowEnd)
+		if end < start {
+			end = start
+		}
+		var total int
+		for k := i; k < j; k++ {
+			total += len(words[k].text) + 1
+		}
+		t := start
+		for k := i; k < j; k++ {
+			d := (end - start) * float64(len(words[k].text)+1) / float64(total)
+			timings[k] = timing{start: t, end: t + d}
+			t += d
+		}
+		i = j
+	}
+}
+
+func clamp(v, min, max float64) float64 {
+	if max < min {
+		max = min
+	}
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,194 @@
+package align
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+// asrResult returns an ASR result with one segment, whose words last a second
+// each from the given start.
+func asrResult(start, duration float64, text string) *models.WhisperResult {
+	seg := models.Segment{ID: "a", Text: " " + text}
+	for i, w := range strings.Fields(text) {
+		t := start + float64(i)
+		seg.Words = append(seg.Words, models.Word{Word: " " + w, Start: t, End: t + 1, Score: 1})
+	}
+	seg.Start = seg.Words[0].Start
+	seg.End = seg.Words[len(seg.Words)-1].End
+	return &models.WhisperResult{Segments: []models.Segment{seg}, Duration: duration}
+}
+
+func TestUniqueAnchors(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want [][2]int
+	}{
+		{"a b c", "a b c", [][2]int{{0, 0}, {1, 1}, {2, 2}}},
+		{"a b c", "c b a", [][2]int{{2, 0}}},
+		{"a x b x c", "a b y c", [][2]int{{0, 0}, {2, 1}, {4, 3}}},
+		// Repeated tokens are not anchors
+		{"a a b", "a b b", nil},
+		{"a b", "c d", nil},
+	}
+	for _, tt := range tests {
+		got := uniqueAnchors(strings.Fields(tt.a), strings.Fields(tt.b))
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+			t.Errorf("uniqueAnchors(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestAlignTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+		want map[int]int
+	}{
+		{"equal", []string{"a", "b"}, []string{"a", "b"}, map[int]int{0: 0, 1: 1}},
+		{"substitution", []string{"a", "x", "c"}, []string{"a", "b", "c"}, map[int]int{0: 0, 1: 1, 2: 2}},
+		{"insertion", []string{"a", "b", "c"}, []string{"a", "c"}, map[int]int{0: 0, 2: 1}},
+		{"deletion", []string{"a", "c"}, []string{"a", "b", "c"}, map[int]int{0: 0, 1: 2}},
+		{"empty", []string{"a"}, nil, map[int]int{}},
+	}
+	for _, tt := range tests {
+		pairs := make(map[int]int)
+		alignTokens(tt.a, tt.b, 0, 0, pairs)
+		if fmt.Sprint(pairs) != fmt.Sprint(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, pairs, tt.want)
+		}
+	}
+}
+
+// Sequences too large for a single table, and without unique tokens, are
+// aligned by windows.
+func TestAlignTokensWindow(t *testing.T) {
+	var a, b []string
+	for i := 0; i < 1200; i++ {
+		a = append(a, []string{"la", "di", "da"}[i%3])
+		b = append(b, []string{"la", "di", "da"}[i%3])
+	}
+	// The ASR missed a word
+	b = append(b[:700:700], b[701:]...)
+	if len(uniqueAnchors(a, b)) > 0 {
+		t.Fatal("the sequences have anchors")
+	}
+	pairs := make(map[int]int)
+	alignTokens(a, b, 0, 0, pairs)
+	if len(pairs) < len(b)-10 {
+		t.Errorf("only %d pairs out of %d words", len(pairs), len(b))
+	}
+	last := -1
+	for i := range a {
+		j, ok := pairs[i]
+		if !ok {
+			continue
+		}
+		if j <= last || a[i] != b[j] {
+			t.Errorf("pair %d -> %d is out of order or does not match", i, j)
+		}
+		last = j
+	}
+}
+
+func TestAlign(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		asr    *models.WhisperResult
+		window models.TimeRange
+		// want is the start, end and score of each script word
+		want [][3]float64
+	}{
+		{
+			name:   "matched",
+			script: "Hello world.",
+			asr:    asrResult(2, 10, "hello world"),
+			want:   [][3]float64{{2, 3, 1}, {3, 4, 1}},
+		},
+		{
+			name:   "substitution",
+			script: "Hello word.",
+			asr:    asrResult(2, 10, "hello world"),
+			want:   [][3]float64{{2, 3, 1}, {3, 4, 0.5}},
+		},
+		{
+			name:   "interpolated between",
+			script: "one two three four",
+			asr: func() *models.WhisperResult {
+				r := asrResult(0, 10, "one four")
+				r.Segments[0].Words[1].Start, r.Segments[0].Words[1].End = 3, 4
+				return r
+			}(),
+			want: [][3]float64{{0, 1, 1}, {1, 1.8, 0}, {1.8, 3, 0}, {3, 4, 1}},
+		},
+		{
+			name:   "interpolated at the ends",
+			script: "aa one bb",
+			asr:    asrResult(2, 10, "one"),
+			want:   [][3]float64{{0, 2, 0}, {2, 3, 1}, {3, 10, 0}},
+		},
+		{
+			name:   "interpolated in a range",
+			script: "aa one bb",
+			asr:    asrResult(52, 600, "one"),
+			window: models.TimeRange{Start: 50, End: 55},
+			want:   [][3]float64{{50, 52, 0}, {52, 53, 1}, {53, 55, 0}},
+		},
+		{
+			name:   "interpolated in a range to the end",
+			script: "aa one bb",
+			asr:    asrResult(52, 60, "one"),
+			window: models.TimeRange{Start: 50},
+			want:   [][3]float64{{50, 52, 0}, {52, 53, 1}, {53, 60, 0}},
+		},
+		{
+			name:   "unknown duration",
+			script: "one two bb",
+			asr:    asrResult(52, 0, "one two"),
+			window: models.TimeRange{Start: 50},
+			want:   [][3]float64{{52, 53, 1}, {53, 54, 1}, {54, 54, 0}},
+		},
+		{
+			name:   "nothing recognized",
+			script: "aaa b",
+			asr:    &models.WhisperResult{Duration: 8},
+			window: models.TimeRange{Start: 2},
+			want:   [][3]float64{{2, 6, 0}, {6, 8, 0}},
+		},
+	}
+	for _, tt := range tests {
+		res := Align(tt.script, tt.asr, tt.window)
+		var words []models.Word
+		for _, seg := range res.Segments {
+			words = append(words, seg.Words...)
+		}
+		if len(words) != len(tt.want) {
+			t.Errorf("%v: got %d words, want %d", tt.name, len(words), len(tt.want))
+			continue
+		}
+		for i, w := range words {
+			want := tt.want[i]
+			if math.Abs(w.Start-want[0]) > 1e-9 || math.Abs(w.End-want[1]) > 1e-9 || w.Score != want[2] {
+				t.Errorf("%v: word %q at %v-%v, score %v, want %v", tt.name, w.Word, w.Start, w.End, w.Score, want)
+			}
+		}
+	}
+}
+
+func TestAlignSegments(t *testing.T) {
+	res := Align("Hello there. How are you?\nFine", asrResult(0, 10, "hello there how are you fine"), models.TimeRange{})
+	var texts []string
+	for _, seg := range res.Segments {
+		texts = append(texts, seg.Text)
+	}
+	if want := []string{"Hello there.", "How are you?", "Fine"}; fmt.Sprint(texts) != fmt.Sprint(want) {
+		t.Errorf("segments %q, want %q", texts, want)
+	}
+	if seg := res.Segments[1]; seg.Start != 2 || seg.End != 5 || seg.Words[1].Word != " are" {
+		t.Errorf("segment %+v", seg)
+	}
+}
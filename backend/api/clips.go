@@ -0,0 +1,321 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/media"
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/utils"
+)
+
+// Formats of the manifest of a dataset.
+const (
+	ManifestCsv   = "csv"
+	ManifestJsonl = "jsonl"
+)
+
+// Sample rate of the clips of a dataset, if not given.
+const defaultDatasetSampleRate = 16000
+
+// This function cuts the audio of a segment, given by its `segment` id, or of a
+// range given with `start` and `end`, and returns it as `wav`, `mp3` or `opus`.
+// The clip is extended by `padding` seconds on each side, and resampled to mono
+// at `sampleRate` if given.
+func (s *Server) handleGetClip(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if !t.HasMedia() {
+		return fiber.NewError(fiber.StatusBadRequest, "The transcription has no media")
+	}
+	format := c.Query("format", media.ClipWav)
+	padding, err := strconv.ParseFloat(c.Query("padding", "0"), 64)
+	if err != nil || padding < 0 || padding > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "The padding must be between 0 and 10 seconds")
+	}
+	sampleRate := c.QueryInt("sampleRate", 0)
+	if err := validateClip(format, sampleRate); err != nil {
+		return err
+	}
+
+	var start, end float64
+	name := export.BaseName(t)
+	if id := c.Query("segment"); id != "" {
+		i := t.Result.IndexOf(id)
+		if i < 0 {
+			return fiber.NewError(fiber.StatusNotFound, "Segment not found")
+		}
+		start, end = t.Result.Segments[i].Start, t.Result.Segments[i].End
+		name += "_" + id
+	} else {
+		if c.Query("start") == "" || c.Query("end") == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Either a segment or a start and an end must be given")
+		}
+		if start, err = utils.ParseTimestamp(c.Query("start")); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid start")
+		}
+		if end, err = utils.ParseTimestamp(c.Query("end")); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid end")
+		}
+		name += fmt.Sprintf("_%.2f-%.2f", start, end)
+	}
+	start, end = padClip(t, start, end, padding)
+	if end <= start {
+		return fiber.NewError(fiber.StatusBadRequest, "The clip must end after it starts")
+	}
+
+	// Clips of a redacted transcription are cut from its redacted media
+	mediaFile, err := t.MediaFile()
+	if err != nil {
+		return fiber.NewError(fiber.StatusConflict, "The redacted copy of the media is not ready")
+	}
+	src, release, err := s.Storage.Local(context.Background(), mediaFile)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading the media of transcription %v", t.ID.Hex())
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	defer release()
+	data, err := clip(src, start, end, format, sampleRate)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error cutting a clip of transcription %v", t.ID.Hex())
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	c.Set("Content-Type", media.ClipContentType(format))
+	c.Attachment(name + "." + format)
+	return c.Send(data)
+}
+
+type datasetRequest struct {
+	// Ids of the transcriptions to export. If empty, Filter is used instead.
+	Ids    []string             `json:"ids"`
+	Filter *TranscriptionFilter `json:"filter"`
+	// Format of the clips, `wav` by default.
+	Format string `json:"format"`
+	// Manifest is `csv` (the default) or `jsonl`.
+	Manifest string  `json:"manifest"`
+	Padding  float64 `json:"padding"`
+	// SampleRate of the clips, which are mixed to mono. 0 keeps the original audio.
+	SampleRate *int `json:"sampleRate"`
+	// Translation takes the text of the translation to this language, instead
+	// of the transcription.
+	Translation string `json:"translation"`
+}
+
+// DatasetEntry is a line of the manifest of a dataset.
+type DatasetEntry struct {
+	File            string  `json:"file"`
+	Text            string  `json:"text"`
+	Start           float64 `json:"start"`
+	End             float64 `json:"end"`
+	Duration        float64 `json:"duration"`
+	Speaker         string  `json:"speaker"`
+	Language        string  `json:"language"`
+	TranscriptionID string  `json:"transcription_id"`
+	SegmentID       string  `json:"segment_id"`
+}
+
+// This function streams a ZIP archive with a clip of every segment of many
+// transcriptions, in `clips/<name>_<n>.<format>`, and a manifest that maps
+// every clip to its text.
+func (s *Server) handleDataset(c *fiber.Ctx) error {
+	var req datasetRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if req.Format == "" {
+		req.Format = media.ClipWav
+	}
+	if req.Manifest == "" {
+		req.Manifest = ManifestCsv
+	}
+	if req.Manifest != ManifestCsv && req.Manifest != ManifestJsonl {
+		return fiber.NewError(fiber.StatusBadRequest, "The manifest must be csv or jsonl")
+	}
+	if req.Padding < 0 || req.Padding > 10 {
+		return fiber.NewError(fiber.StatusBadRequest, "The padding must be between 0 and 10 seconds")
+	}
+	sampleRate := defaultDatasetSampleRate
+	if req.SampleRate != nil {
+		sampleRate = *req.SampleRate
+	}
+	if err := validateClip(req.Format, sampleRate); err != nil {
+		return err
+	}
+
+	transcriptions, err := s.selectTranscriptions(req.Ids, req.Filter)
+	if err != nil {
+		return err
+	}
+
+	c.Set("Content-Type", "application/zip")
+	c.Attachment("whishper-dataset.zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		zw := zip.NewWriter(w)
+		entries := []DatasetEntry{}
+		names := exportNames(transcriptions)
+		for i, t := range transcriptions {
+			if !t.HasMedia() {
+				log.Warn().Msgf("Transcription %v has no media, skipping it", t.ID.Hex())
+				continue
+			}
+			res := &t.Result
+			if req.Translation != "" {
+				if res = findTranslation(t, req.Translation); res == nil {
+					log.Warn().Msgf("Transcription %v has no translation to %v, skipping it", t.ID.Hex(), req.Translation)
+					continue
+				}
+			}
+			mediaFile, err := t.MediaFile()
+			if err != nil {
+				log.Warn().Msgf("The redacted copy of the media of transcription %v is not ready, skipping it", t.ID.Hex())
+				continue
+			}
+			src, release, err := s.Storage.Local(context.Background(), mediaFile)
+			if err != nil {
+				log.Error().Err(err).Msgf("Error reading the media of transcription %v", t.ID.Hex())
+				continue
+			}
+			for j, seg := range res.Segments {
+				text := strings.TrimSpace(seg.Text)
+				start, end := padClip(t, seg.Start, seg.End, req.Padding)
+				if text == "" || end <= start {
+					continue
+				}
+				data, err := clip(src, start, end, req.Format, sampleRate)
+				if err != nil {
+					log.Error().Err(err).Msgf("Error cutting segment %v of transcription %v", seg.ID, t.ID.Hex())
+					continue
+				}
+				file := fmt.Sprintf("clips/%v_%04d.%v", names[i], j+1, req.Format)
+				fw, err := zw.Create(file)
+				if err != nil {
+					log.Error().Err(err).Msg("Error creating zip entry")
+					release()
+					return
+				}
+				if _, err := fw.Write(data); err != nil {
+					log.Error().Err(err).Msg("Error writing zip entry")
+					release()
+					return
+				}
+				entries = append(entries, DatasetEntry{
+					File:            file,
+					Text:            text,
+					Start:           start,
+					End:             end,
+					Duration:        end - start,
+					Speaker:         t.SpeakerName(seg.Speaker),
+					Language:        res.Language,
+					TranscriptionID: t.ID.Hex(),
+					SegmentID:       seg.ID,
+				})
+				w.Flush()
+			}
+			release()
+		}
+
+		fw, err := zw.Create("manifest." + req.Manifest)
+		if err != nil {
+			log.Error().Err(err).Msg("Error creating zip entry")
+			return
+		}
+		if err := writeManifest(req.Manifest, entries, fw); err != nil {
+			log.Error().Err(err).Msg("Error writing the dataset manifest")
+		}
+		if err := zw.Close(); err != nil {
+			log.Error().Err(err).Msg("Error closing zip archive")
+		}
+	})
+	return nil
+}
+
+func validateClip(format string, sampleRate int) error {
+	if !contains(media.ClipFormats, format) {
+		return fiber.NewError(fiber.StatusBadRequest, "The format must be wav, mp3 or opus")
+	}
+	if sampleRate != 0 && (sampleRate < 8000 || sampleRate > 48000) {
+		return fiber.NewError(fiber.StatusBadRequest, "The sample rate must be between 8000 and 48000")
+	}
+	if rates := media.ClipSampleRates[format]; sampleRate != 0 && rates != nil && !containsInt(rates, sampleRate) {
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The sample rate of %v clips must be one of %v", format, rates))
+	}
+	return nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// padClip extends a range by the padding on each side, without going beyond
+// the media.
+func padClip(t *models.Transcription, start, end, padding float64) (float64, float64) {
+	start -= padding
+	if start < 0 {
+		start = 0
+	}
+	end += padding
+	if t.Media != nil && t.Media.Duration > 0 && end > t.Media.Duration {
+		end = t.Media.Duration
+	}
+	return start, end
+}
+
+// clip cuts a range of a local media file, and returns the encoded clip.
+func clip(src string, start, end float64, format string, sampleRate int) ([]byte, error) {
+	f, err := os.CreateTemp("", "whishper-clip-*."+format)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := media.Clip(context.Background(), src, f.Name(), start, end, sampleRate); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f.Name())
+}
+
+func writeManifest(format string, entries []DatasetEntry, w io.Writer) error {
+	if format == ManifestJsonl {
+		enc := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "text", "start", "end", "duration", "speaker", "language", "transcription_id", "segment_id"})
+	for _, e := range entries {
+		cw.Write([]string{
+			e.File, e.Text,
+			strconv.FormatFloat(e.Start, 'f', 3, 64),
+			strconv.FormatFloat(e.End, 'f', 3, 64),
+			strconv.FormatFloat(e.Duration, 'f', 3, 64),
+			e.Speaker, e.Language, e.TranscriptionID, e.SegmentID,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
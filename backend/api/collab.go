@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Message types of the collaborative editing protocol.
+const (
+	MessageTypeWelcome       = "welcome"
+	MessageTypePresence      = "presence"
+	MessageTypeLock          = "lock"
+	MessageTypeUnlock        = "unlock"
+	MessageTypeEdit          = "edit"
+	MessageTypeRejected      = "rejected"
+	MessageTypeTranscription = "transcription"
+	MessageTypeError         = "error"
+)
+
+// Presence tells who is connected to the room of a transcription, and which
+// segment they are viewing or editing.
+type Presence struct {
+	ID          string `json:"id"`
+	User        string `json:"user"`
+	Segment     string `json:"segment,omitempty"`
+	Translation string `json:"translation,omitempty"`
+	Editing     bool   `json:"editing"`
+}
+
+// Collaborator is a client connected to the room of a transcription.
+type Collaborator struct {
+	Presence
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// send writes a message to the collaborator. Writes to a connection must not
+// run concurrently.
+func (c *Collaborator) send(msg interface{}) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := c.conn.WriteJSON(msg); err != nil {
+		log.Debug().Err(err).Msgf("Error sending message to collaborator %v", c.ID)
+	}
+}
+
+// Lock is held by a collaborator on a segment of the result or of a translation.
+type Lock struct {
+	Segment     string `json:"segment"`
+	Translation string `json:"translation,omitempty"`
+	Owner       string `json:"owner"`
+	User        string `json:"user"`
+}
+
+// CollabMessage is the message exchanged in a room. Only the fields of its
+// type are set.
+type CollabMessage struct {
+	Type        string `json:"type"`
+	Segment     string `json:"segment,omitempty"`
+	Translation string `json:"translation,omitempty"`
+	// presence
+	Editing bool `json:"editing,omitempty"`
+	// edit: the new text and/or times of the segment. Previous is the text the
+	// edit was made on; if someone else changed it in the meantime, the edit is
+	// rejected instead of overwriting their change.
+	Text     *string  `json:"text,omitempty"`
+	Previous *string  `json:"previous,omitempty"`
+	Start    *float64 `json:"start,omitempty"`
+	End      *float64 `json:"end,omitempty"`
+	// sent by the server
+	ID            string                `json:"id,omitempty"`
+	User          string                `json:"user,omitempty"`
+	Message       string                `json:"message,omitempty"`
+	Users         []Presence            `json:"users,omitempty"`
+	Locks         []Lock                `json:"locks,omitempty"`
+	Current       *models.Segment       `json:"current,omitempty"`
+	Transcription *models.Transcription `json:"transcription,omitempty"`
+}
+
+// room holds the collaborators of a transcription and their segment locks.
+type room struct {
+	mu      sync.Mutex
+	clients map[*websocket.Conn]*Collaborator
+	locks   map[string]Lock
+}
+
+func lockKey(translation, segment string) string {
+	return translation + "/" + segment
+}
+
+func (r *room) collaborators() []*Collaborator {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := make([]*Collaborator, 0, len(r.clients))
+	for _, c := range r.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+func (r *room) presence() []Presence {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := make([]Presence, 0, len(r.clients))
+	for _, c := range r.clients {
+		users = append(users, c.Presence)
+	}
+	return users
+}
+
+func (r *room) lockList() []Lock {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	locks := make([]Lock, 0, len(r.locks))
+	for _, l := range r.locks {
+		locks = append(locks, l)
+	}
+	return locks
+}
+
+// broadcast sends a message to every collaborator in the room.
+func (r *room) broadcast(msg interface{}) {
+	for _, c := range r.collaborators() {
+		c.send(msg)
+	}
+}
+
+// join adds a connection to the room of a transcription, creating the room if needed.
+func (s *Server) join(id string, conn *websocket.Conn) (*room, *Collaborator) {
+	c := &Collaborator{Presence: Presence{ID: primitive.NewObjectID().Hex(), User: wsAuthor(conn)}, conn: conn}
+
+	s.roomsMu.Lock()
+	defer s.roomsMu.Unlock()
+	r := s.rooms[id]
+	if r == nil {
+		r = &room{clients: make(map[*websocket.Conn]*Collaborator), locks: make(map[string]Lock)}
+		s.rooms[id] = r
+	}
+	r.mu.Lock()
+	r.clients[conn] = c
+	r.mu.Unlock()
+	return r, c
+}
+
+// leave removes a collaborator from a room, releasing its locks, and removes
+// the room once it is empty.
+func (s *Server) leave(id string, r *room, c *Collaborator) {
+	s.roomsMu.Lock()
+	r.mu.Lock()
+	delete(r.clients, c.conn)
+	var released []Lock
+	for key, l := range r.locks {
+		if l.Owner == c.ID {
+			released = append(released, l)
+			delete(r.locks, key)
+		}
+	}
+	empty := len(r.clients) == 0
+	if empty {
+		delete(s.rooms, id)
+	}
+	r.mu.Unlock()
+	s.roomsMu.Unlock()
+
+	if empty {
+		return
+	}
+	for _, l := range released {
+		r.broadcast(&CollabMessage{Type: MessageTypeUnlock, Segment: l.Segment, Translation: l.Translation, ID: l.Owner, User: l.User})
+	}
+	r.broadcast(&CollabMessage{Type: MessageTypePresence, Users: r.presence()})
+}
+
+// broadcastRoom sends a message to the collaborators of a transcription, if any.
+func (s *Server) broadcastRoom(id string, msg interface{}) {
+	s.roomsMu.Lock()
+	r := s.rooms[id]
+	s.roomsMu.Unlock()
+	if r != nil {
+		r.broadcast(msg)
+	}
+}
+
+// heldLock returns a lock held on one of the given segments of a transcription
+// by someone other than the collaborator, or nil. Segments are given by their
+// lock key. Writes made outside of the room are checked against it, so that they
+// do not overwrite a segment someone is editing.
+func (s *Server) heldLock(id, collaborator string, keys []string) *Lock {
+	s.roomsMu.Lock()
+	r := s.rooms[id]
+	s.roomsMu.Unlock()
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range keys {
+		if l, ok := r.locks[key]; ok && l.Owner != collaborator {
+			return &l
+		}
+	}
+	return nil
+}
+
+// changedSegments returns the lock keys of the segments of a transcription that
+// an update changes or removes.
+func changedSegments(before, after *models.Transcription) []string {
+	var keys []string
+	diff := func(translation string, a, b []models.Segment) {
+		next := make(map[string]models.Segment, len(b))
+		for _, seg := range b {
+			next[seg.ID] = seg
+		}
+		for _, seg := range a {
+			if n, ok := next[seg.ID]; !ok || !sameSegment(seg, n) {
+				keys = append(keys, lockKey(translation, seg.ID))
+			}
+		}
+	}
+	diff("", before.Result.Segments, after.Result.Segments)
+	for _, tr := range before.Translations {
+		var segments []models.Segment
+		for _, a := range after.Translations {
+			if a.TargetLanguage == tr.TargetLanguage {
+				segments = a.Result.Segments
+			}
+		}
+		diff(tr.TargetLanguage, tr.Result.Segments, segments)
+	}
+	return keys
+}
+
+func sameSegment(a, b models.Segment) bool {
+	if len(a.Words) == 0 && len(b.Words) == 0 {
+		a.Words, b.Words = nil, nil
+	}
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+// handleCollab runs the collaborative editing session of a client in the room
+// of a transcription, until the client disconnects.
+func (s *Server) handleCollab(conn *websocket.Conn) {
+	id := conn.Params("id")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		conn.WriteJSON(&CollabMessage{Type: MessageTypeError, Message: "Transcription not found"})
+		return
+	}
+
+	r, c := s.join(id, conn)
+	defer s.leave(id, r, c)
+	c.send(&CollabMessage{Type: MessageTypeWelcome, ID: c.ID, User: c.User, Users: r.presence(),
+		Locks: r.lockList(), Transcription: t})
+	r.broadcast(&CollabMessage{Type: MessageTypePresence, Users: r.presence()})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if err.Error() != "websocket: close 1000 (normal)" &&
+				err.Error() != "websocket: close 1001 (going away)" {
+				log.Debug().Err(err).Msgf("Error reading message")
+			}
+			return
+		}
+		var msg CollabMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			c.send(&CollabMessage{Type: MessageTypeError, Message: "Invalid message"})
+			continue
+		}
+		switch msg.Type {
+		case MessageTypePresence:
+			r.mu.Lock()
+			c.Segment, c.Translation, c.Editing = msg.Segment, msg.Translation, msg.Editing
+			r.mu.Unlock()
+			r.broadcast(&CollabMessage{Type: MessageTypePresence, Users: r.presence()})
+		case MessageTypeLock:
+			s.lockSegment(r, c, &msg)
+		case MessageTypeUnlock:
+			r.mu.Lock()
+			key := lockKey(msg.Translation, msg.Segment)
+			held := r.locks[key].Owner == c.ID
+			if held {
+				delete(r.locks, key)
+			}
+			r.mu.Unlock()
+			if held {
+				r.broadcast(&CollabMessage{Type: MessageTypeUnlock, Segment: msg.Segment, Translation: msg.Translation, ID: c.ID, User: c.User})
+			}
+		case MessageTypeEdit:
+			s.editSegment(id, r, c, &msg)
+		default:
+			c.send(&CollabMessage{Type: MessageTypeError, Message: "Unknown message type"})
+		}
+	}
+}
+
+// lockSegment gives a collaborator the exclusive right to edit a segment, if
+// nobody else holds it.
+func (s *Server) lockSegment(r *room, c *Collaborator, msg *CollabMessage) {
+	key := lockKey(msg.Translation, msg.Segment)
+	r.mu.Lock()
+	l, locked := r.locks[key]
+	if !locked {
+		l = Lock{Segment: msg.Segment, Translation: msg.Translation, Owner: c.ID, User: c.User}
+		r.locks[key] = l
+	}
+	r.mu.Unlock()
+
+	if locked && l.Owner != c.ID {
+		c.send(&CollabMessage{Type: MessageTypeRejected, Segment: msg.Segment, Translation: msg.Translation,
+			Message: "The segment is locked by " + l.User})
+		return
+	}
+	r.broadcast(&CollabMessage{Type: MessageTypeLock, Segment: l.Segment, Translation: l.Translation, ID: l.Owner, User: l.User})
+}
+
+// editSegment applies the edit of a segment on top of the latest version of the
+// transcription, so that concurrent edits of other segments are merged. Edits
+// of a segment locked by someone else, or whose text changed since the client
+// saw it, are rejected.
+func (s *Server) editSegment(id string, r *room, c *Collaborator, msg *CollabMessage) {
+	reject := func(reason string, current *models.Segment) {
+		c.send(&CollabMessage{Type: MessageTypeRejected, Segment: msg.Segment, Translation: msg.Translation,
+			Message: reason, Current: current})
+	}
+
+	r.mu.Lock()
+	l, locked := r.locks[lockKey(msg.Translation, msg.Segment)]
+	r.mu.Unlock()
+	if locked && l.Owner != c.ID {
+		reject("The segment is locked by "+l.User, nil)
+		return
+	}
+
+	const attempts = 5
+	for i := 0; i < attempts; i++ {
+		t := s.Db.GetTranscription(id)
+		if t == nil {
+			reject("Transcription not found", nil)
+			return
+		}
+		res, index := &t.Result, -1
+		if msg.Translation != "" {
+			for k := range t.Translations {
+				if t.Translations[k].TargetLanguage == msg.Translation {
+					res, index = &t.Translations[k].Result, k
+				}
+			}
+			if index < 0 {
+				reject("Translation not found", nil)
+				return
+			}
+		}
+		k := res.IndexOf(msg.Segment)
+		if k < 0 {
+			reject(models.ErrSegmentNotFound.Error(), nil)
+			return
+		}
+		current := res.Segments[k]
+		if msg.Previous != nil && current.Text != *msg.Previous {
+			reject("The segment was changed by someone else", &current)
+			return
+		}
+
+		before := t.Copy()
+		var seg *models.Segment
+		var err error
+		if msg.Start != nil || msg.End != nil {
+			seg, err = res.RetimeSegment(msg.Segment, msg.Start, msg.End)
+		}
+		if err == nil && msg.Text != nil {
+			seg, err = res.EditSegmentText(msg.Segment, *msg.Text)
+		}
+		if err != nil {
+			reject(err.Error(), &current)
+			return
+		}
+		if seg == nil {
+			return
+		}
+
+		version, err := s.Db.UpdateResult(id, t.Version, index, res)
+		if errors.Is(err, database.ErrVersionConflict) {
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Error updating result of transcription %v", id)
+			reject("Internal server error", nil)
+			return
+		}
+		t.Version = version
+		s.RecordRevision(before, t, c.User, "edit segment")
+		s.broadcastSegments(&SegmentsMessage{
+			Type:            MessageTypeSegments,
+			TranscriptionID: id,
+			Version:         version,
+			Translation:     msg.Translation,
+			Segments:        []models.Segment{*seg},
+			Removed:         []string{},
+			Text:            res.Text,
+		})
+		return
+	}
+	reject("The transcription is being changed too often, try again", nil)
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+func lockTranscription() *models.Transcription {
+	return &models.Transcription{
+		Result: models.WhisperResult{Segments: []models.Segment{
+			{ID: "a", Text: " Hello", Words: []models.Word{{Word: " Hello"}}},
+			{ID: "b", Text: " there"},
+		}},
+		Translations: []models.Translation{{TargetLanguage: "fr", Result: models.WhisperResult{Segments: []models.Segment{
+			{ID: "c", Text: " Bonjour"},
+		}}}},
+	}
+}
+
+func TestChangedSegments(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *models.Transcription)
+		want   []string
+	}{
+		{"nothing", func(t *models.Transcription) {}, nil},
+		{"empty words", func(t *models.Transcription) { t.Result.Segments[1].Words = []models.Word{} }, nil},
+		{"text", func(t *models.Transcription) { t.Result.Segments[0].Text = " Hi" }, []string{"/a"}},
+		{"removed", func(t *models.Transcription) { t.Result.Segments = t.Result.Segments[:1] }, []string{"/b"}},
+		{"added", func(t *models.Transcription) {
+			t.Result.Segments = append(t.Result.Segments, models.Segment{ID: "d"})
+		}, nil},
+		{"translation", func(t *models.Transcription) { t.Translations[0].Result.Segments[0].End = 1 }, []string{"fr/c"}},
+		{"translation removed", func(t *models.Transcription) { t.Translations = nil }, []string{"fr/c"}},
+	}
+	for _, tt := range tests {
+		after := lockTranscription()
+		tt.change(after)
+		got := changedSegments(lockTranscription(), after)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHeldLock(t *testing.T) {
+	s := &Server{rooms: map[string]*room{
+		"t": {locks: map[string]Lock{lockKey("", "a"): {Segment: "a", Owner: "alice-id", User: "alice"}}},
+	}}
+	if l := s.heldLock("t", "", []string{"/b", "/a"}); l == nil || l.User != "alice" {
+		t.Errorf("got %v, want the lock of alice", l)
+	}
+	if l := s.heldLock("t", "alice-id", []string{"/a"}); l != nil {
+		t.Errorf("the lock of alice blocks her: %v", l)
+	}
+	if l := s.heldLock("t", "", []string{"fr/a"}); l != nil {
+		t.Errorf("the lock of the result blocks the translation: %v", l)
+	}
+	if l := s.heldLock("other", "", []string{"/a"}); l != nil {
+		t.Errorf("the lock blocks another transcription: %v", l)
+	}
+}
@@ -0,0 +1,142 @@
+package api
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/utils"
+	"codeberg.org/pluja/whishper/waveform"
+)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Deduplicate hashes the media file of a transcription that is not saved yet, or
+// was just downloaded. If the same media is already stored, the new copy is
+// deleted, and the transcription is linked to the stored file. If the
+// transcription asks for it, the result of a done transcription of the same
+// media with the same settings is copied, and true is returned.
+func (s *Server) Deduplicate(t *models.Transcription) bool {
+	if !t.HasMedia() {
+		return false
+	}
+	ctx := context.Background()
+	if t.Hash == "" {
+		r, err := s.Storage.Open(ctx, t.FileName, 0, -1)
+		if err == nil {
+			t.Hash, err = utils.Hash(r)
+			r.Close()
+		}
+		if err != nil {
+			log.Error().Err(err).Msgf("Error hashing file %v", t.FileName)
+			return false
+		}
+	}
+
+	var matches []*models.Transcription
+	for _, m := range s.Db.GetTranscriptionsByHash(t.Hash) {
+		if m.ID != t.ID {
+			matches = append(matches, m)
+		}
+	}
+
+	for _, m := range matches {
+		if !m.HasMedia() || m.FileName == t.FileName {
+			continue
+		}
+		if _, err := s.Storage.Stat(ctx, m.FileName); err != nil {
+			continue
+		}
+		log.Info().Msgf("File %v is a duplicate of %v, linking it", t.FileName, m.FileName)
+		if err := s.Storage.Delete(ctx, t.FileName); err != nil {
+			log.Error().Err(err).Msgf("Error deleting file %v", t.FileName)
+		}
+		t.FileName = m.FileName
+		if t.Media == nil {
+			t.Media = m.Media
+		}
+		break
+	}
+
+	if !t.Reuse {
+		return false
+	}
+	for _, m := range matches {
+		if m.Status != models.TranscriptionStatusDone || !t.SameSettings(m) {
+			continue
+		}
+		log.Info().Msgf("Reusing the result of transcription %v", m.ID.Hex())
+		c := m.Copy()
+		t.Result = c.Result
+		t.Speakers = c.Speakers
+		t.Translations = c.Translations
+		t.Status = models.TranscriptionStatusDone
+		t.ReusedFrom = m.ID.Hex()
+		return true
+	}
+	return false
+}
+
+// mediaShared tells if the media file of a transcription is used by another one.
+func (s *Server) mediaShared(t *models.Transcription) bool {
+	if t.Hash == "" {
+		return false
+	}
+	for _, m := range s.Db.GetTranscriptionsByHash(t.Hash) {
+		if m.ID != t.ID && m.FileName == t.FileName {
+			return true
+		}
+	}
+	return false
+}
+
+// This function returns the transcriptions of the media with the given SHA-256,
+// so that clients can hash a file before uploading it, and offer to reuse an
+// existing result.
+func (s *Server) handleGetDuplicates(c *fiber.Ctx) error {
+	hash := c.Params("hash")
+	if !hashPattern.MatchString(hash) {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid hash")
+	}
+	transcriptions := s.Db.GetTranscriptionsByHash(hash)
+	if transcriptions == nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(transcriptions)
+}
+
+// redactionShared tells if a redacted copy of the media of a transcription is
+// used by another transcription of the same media.
+func (s *Server) redactionShared(hash string, id primitive.ObjectID, fileName string) bool {
+	if hash == "" {
+		return false
+	}
+	for _, m := range s.Db.GetTranscriptionsByHash(hash) {
+		if m.ID != id && m.Redaction != nil && m.Redaction.FileName == fileName {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteMedia deletes the media file of a transcription and its waveform, unless
+// another transcription uses them, and its redacted copy of the media.
+func (s *Server) deleteMedia(t *models.Transcription) {
+	var files []string
+	if t.HasMedia() && !s.mediaShared(t) {
+		files = append(files, t.FileName)
+		files = append(files, waveform.Files(t.FileName)...)
+	}
+	if t.Redaction != nil && t.Redaction.FileName != "" && !s.redactionShared(t.Hash, t.ID, t.Redaction.FileName) {
+		files = append(files, t.Redaction.FileName)
+	}
+	for _, f := range files {
+		if err := s.Storage.Delete(context.Background(), f); err != nil {
+			log.Error().Err(err).Msgf("Error deleting file %v", f)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// This function exports a transcription, or one of its translations, in the given format.
+// The `translation` query parameter selects a translation by target language, and
+// `timestamps` and `speakers` toggle the per-paragraph labels. With `redact`, personal
+// information is replaced with placeholders in the exported file.
+func (s *Server) handleExport(c *fiber.Ctx) error {
+	id := c.Params("id")
+	format := c.Params("format")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if c.QueryBool("redact", false) {
+		redactor, err := (&RedactRequest{}).redactor()
+		if err != nil {
+			return err
+		}
+		t = t.Copy()
+		redactor.Transcription(t)
+	}
+
+	res := &t.Result
+	if target := c.Query("translation"); target != "" {
+		res = findTranslation(t, target)
+		if res == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Translation not found")
+		}
+	}
+
+	opts := export.DefaultOptions()
+	opts.Timestamps = c.QueryBool("timestamps", false)
+	opts.Speakers = c.QueryBool("speakers", false)
+
+	if !contains(export.Formats, format) {
+		return fiber.NewError(fiber.StatusBadRequest, "Unsupported format")
+	}
+	var buf bytes.Buffer
+	if err := export.Write(format, t, res, opts, &buf); err != nil {
+		log.Warn().Err(err).Msgf("Could not export transcription %v", id)
+		if errors.Is(err, export.ErrUnsupportedText) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, err.Error())
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	c.Set("Content-Type", export.ContentType(format))
+	c.Attachment(export.BaseName(t) + "." + format)
+	c.Write(buf.Bytes())
+	return nil
+}
+
+// TranscriptionFilter selects transcriptions by their settings. Empty fields match everything.
+type TranscriptionFilter struct {
+	Status    *int   `json:"status"`
+	Language  string `json:"language"`
+	ModelSize string `json:"modelSize"`
+	// Search matches a substring of the original file name or source URL.
+	Search string `json:"search"`
+}
+
+func (f *TranscriptionFilter) Match(t *models.Transcription) bool {
+	if f.Status != nil && t.Status != *f.Status {
+		return false
+	}
+	if f.Language != "" && t.Language != f.Language && t.Result.Language != f.Language {
+		return false
+	}
+	if f.ModelSize != "" && t.ModelSize != f.ModelSize {
+		return false
+	}
+	if f.Search != "" {
+		search := strings.ToLower(f.Search)
+		if !strings.Contains(strings.ToLower(t.DisplayName()), search) &&
+			!strings.Contains(strings.ToLower(t.SourceUrl), search) {
+			return false
+		}
+	}
+	return true
+}
+
+type bulkExportRequest struct {
+	// Ids of the transcriptions to export. If empty, Filter is used instead.
+	Ids     []string             `json:"ids"`
+	Filter  *TranscriptionFilter `json:"filter"`
+	Formats []string             `json:"formats"`
+	// Translations restricts the exported translations to these target languages.
+	// All translations are exported if it is empty.
+	Translations []string `json:"translations"`
+	Timestamps   bool     `json:"timestamps"`
+	Speakers     bool     `json:"speakers"`
+	// Redact replaces personal information with placeholders.
+	Redact bool `json:"redact"`
+}
+
+// This function streams a ZIP archive with every selected transcription, and its
+// translations, in every requested format. Files are named after the original media
+// file: `<name>.<format>` for the transcription and `<name>.<lang>.<format>` for
+// translations.
+func (s *Server) handleBulkExport(c *fiber.Ctx) error {
+	var req bulkExportRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if len(req.Formats) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "No formats requested")
+	}
+	for _, f := range req.Formats {
+		if !contains(export.Formats, f) {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Unsupported format %v", f))
+		}
+	}
+
+	transcriptions, err := s.selectTranscriptions(req.Ids, req.Filter)
+	if err != nil {
+		return err
+	}
+	if req.Redact {
+		redactor, err := (&RedactRequest{}).redactor()
+		if err != nil {
+			return err
+		}
+		for i, t := range transcriptions {
+			transcriptions[i] = t.Copy()
+			redactor.Transcription(transcriptions[i])
+		}
+	}
+
+	opts := export.DefaultOptions()
+	opts.Timestamps = req.Timestamps
+	opts.Speakers = req.Speakers
+
+	c.Set("Content-Type", "application/zip")
+	c.Attachment("whishper-export.zip")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		zw := zip.NewWriter(w)
+		names := exportNames(transcriptions)
+		used := make(map[string]bool)
+		for i, t := range transcriptions {
+			files := map[string]*models.WhisperResult{names[i]: &t.Result}
+			order := []string{names[i]}
+			for j, tr := range t.Translations {
+				if len(req.Translations) == 0 || contains(req.Translations, tr.TargetLanguage) {
+					name := names[i] + "." + tr.TargetLanguage
+					files[name] = &t.Translations[j].Result
+					order = append(order, name)
+				}
+			}
+			for _, name := range order {
+				res := files[name]
+				for _, format := range req.Formats {
+					// A file that cannot be exported is left out, rather than
+					// added incomplete
+					var buf bytes.Buffer
+					if err := export.Write(format, t, res, opts, &buf); err != nil {
+						log.Error().Err(err).Msgf("Error exporting transcription %v as %v", t.ID.Hex(), format)
+						continue
+					}
+					fw, err := zw.Create(uniqueEntry(used, name, format))
+					if err != nil {
+						log.Error().Err(err).Msg("Error creating zip entry")
+						return
+					}
+					if _, err := fw.Write(buf.Bytes()); err != nil {
+						log.Error().Err(err).Msg("Error writing zip entry")
+						return
+					}
+				}
+			}
+			w.Flush()
+		}
+		if err := zw.Close(); err != nil {
+			log.Error().Err(err).Msg("Error closing zip archive")
+		}
+	})
+	return nil
+}
+
+// selectTranscriptions returns the transcriptions with the given ids, or all the
+// transcriptions matching the filter if no ids are given.
+func (s *Server) selectTranscriptions(ids []string, filter *TranscriptionFilter) ([]*models.Transcription, error) {
+	var transcriptions []*models.Transcription
+	if len(ids) > 0 {
+		for _, id := range ids {
+			t := s.Db.GetTranscription(id)
+			if t == nil {
+				log.Warn().Msgf("Transcription with id %v not found", id)
+				return nil, fiber.NewError(fiber.StatusNotFound, fmt.Sprintf("Transcription %v not found", id))
+			}
+			transcriptions = append(transcriptions, t)
+		}
+		return transcriptions, nil
+	}
+	if filter == nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Either ids or a filter must be given")
+	}
+	for _, t := range s.Db.GetAllTranscriptions() {
+		if filter.Match(t) {
+			transcriptions = append(transcriptions, t)
+		}
+	}
+	return transcriptions, nil
+}
+
+// exportNames assigns a unique file name to each transcription. Names derive from
+// the original media file; if two transcriptions share it, the id is appended.
+func exportNames(transcriptions []*models.Transcription) []string {
+	count := make(map[string]int)
+	for _, t := range transcriptions {
+		count[export.BaseName(t)]++
+	}
+	names := make([]string, len(transcriptions))
+	for i, t := range transcriptions {
+		names[i] = export.BaseName(t)
+		if count[names[i]] > 1 {
+			names[i] += "_" + t.ID.Hex()
+		}
+	}
+	return names
+}
+
+// uniqueEntry returns the name of a zip entry, with a number added before the
+// extension if it is already used: the name of a transcription may end like the
+// name of the translation of another one, and translations may share a language.
+func uniqueEntry(used map[string]bool, base, ext string) string {
+	name := base + "." + ext
+	for n := 2; used[name]; n++ {
+		name = fmt.Sprintf("%v_%d.%v", base, n, ext)
+	}
+	used[name] = true
+	return name
+}
+
+func findTranslation(t *models.Transcription, target string) *models.WhisperResult {
+	for i := range t.Translations {
+		if t.Translations[i].TargetLanguage == target {
+			return &t.Translations[i].Result
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,25 @@
+package api
+
+import "testing"
+
+func TestUniqueEntry(t *testing.T) {
+	used := make(map[string]bool)
+	tests := []struct {
+		base, ext, want string
+	}{
+		{"foo", "srt", "foo.srt"},
+		{"foo.en", "srt", "foo.en.srt"},
+		// The English translation of foo
+		{"foo.en", "srt", "foo.en_2.srt"},
+		// A second English translation
+		{"foo.en", "srt", "foo.en_3.srt"},
+		{"foo.en", "vtt", "foo.en.vtt"},
+		{"foo_2", "srt", "foo_2.srt"},
+		{"foo", "srt", "foo_3.srt"},
+	}
+	for _, tt := range tests {
+		if got := uniqueEntry(used, tt.base, tt.ext); got != tt.want {
+			t.Errorf("uniqueEntry(%q, %q) = %q, want %q", tt.base, tt.ext, got, tt.want)
+		}
+	}
+}
@@ -1,15 +1,26 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
 	"github.com/rs/zerolog/log"
 
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/media"
 	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/utils"
 )
 
 func (s *Server) handleGetAllTranscriptions(c *fiber.Ctx) error {
@@ -65,46 +76,28 @@ func (s *Server) handlePostTranscription(c *fiber.Ctx) error {
 			log.Error().Err(err).Msg("Error getting file field from the form")
 			return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 		}
-		timeid := time.Now().Format("2006_01_02-150405000")
-		filename = timeid + models.FileNameSeparator + file.Filename
-		// if it's empty and there is no sourceurl we set a timestamp-based filename
-		if filename == timeid+models.FileNameSeparator {
-			filename = timeid + models.FileNameSeparator + time.Now().Format("2006_01_02-150405")
-		}
-
-		// Save the file to the uploads directory.
-		err = c.SaveFile(file, fmt.Sprintf("%v/%v", os.Getenv("UPLOAD_DIR"), filename))
+		filename, transcription.Media, err = s.saveFormFile(file)
 		if err != nil {
-			log.Error().Err(err).Msgf("Error saving the form file to disk into %v", os.Getenv("UPLOAD_DIR"))
-			return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+			return err
 		}
 	}
 
 	// Parse the body into the transcription struct.
-	transcription.Language = c.FormValue("language")
-	transcription.ModelSize = c.FormValue("modelSize")
 	transcription.FileName = filename
 	transcription.Status = models.TranscriptionStatusPending
-	transcription.Task = "transcribe"
 	transcription.SourceUrl = c.FormValue("sourceUrl")
-	transcription.Device = c.FormValue("device")
-	if transcription.Device != "cpu" && transcription.Device != "cuda" {
-		log.Warn().Msgf("Device %v not supported, using cpu", transcription.Device)
-		transcription.Device = "cpu"
+	if err := s.parseTranscriptionForm(&transcription, func(key string) string { return c.FormValue(key) }); err != nil {
+		s.deleteUpload(filename)
+		return err
 	}
 
 	log.Debug().Msgf("Transcription: %+v", transcription)
-	// Save transcription to database
-	res, err := s.Db.NewTranscription(&transcription)
+	res, err := s.CreateTranscription(&transcription)
 	if err != nil {
-		log.Error().Err(err).Msg("Error saving transcription to database")
+		s.deleteUpload(filename)
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
 	}
 
-	// Broadcast transcription to websocket clients
-	s.BroadcastTranscription(res)
-	s.NewTranscriptionCh <- true
-	
 	// Convert the transcription to JSON.
 	json, err := json.Marshal(res)
 	if err != nil {
@@ -118,6 +111,212 @@ func (s *Server) handlePostTranscription(c *fiber.Ctx) error {
 	return nil
 }
 
+// parseTranscriptionForm sets the settings of a new transcription from the fields
+// of a form, given by value. Its media, if any, must already be probed.
+func (s *Server) parseTranscriptionForm(t *models.Transcription, value func(key string) string) error {
+	t.Language = value("language")
+	t.ModelSize = value("modelSize")
+	t.Task = value("task")
+	if t.Task == "" {
+		t.Task = models.TaskTranscribe
+	}
+	switch t.Task {
+	case models.TaskTranscribe:
+	case models.TaskTranslate:
+		t.TranslationOutput = value("translationOutput")
+		if t.TranslationOutput == "" {
+			t.TranslationOutput = models.TranslationOutputTranslation
+		}
+		if t.TranslationOutput != models.TranslationOutputTranslation &&
+			t.TranslationOutput != models.TranslationOutputResult {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Translation output %v not supported", t.TranslationOutput))
+		}
+	case models.TaskAlign:
+		t.Script = strings.TrimSpace(value("text"))
+		if t.Script == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "The align task requires a text")
+		}
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("Task %v not supported", t.Task))
+	}
+	t.Diarize = value("diarize") == "true"
+	if t.Diarize && !utils.DiarizationSupported() {
+		return fiber.NewError(fiber.StatusBadRequest, "The ASR service does not support speaker diarization")
+	}
+	t.Reuse = value("reuse") == "true"
+	if n := value("numSpeakers"); n != "" {
+		num, err := strconv.Atoi(n)
+		if err != nil || num < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid number of speakers")
+		}
+		t.NumSpeakers = num
+	}
+	t.InitialPrompt = strings.TrimSpace(value("initialPrompt"))
+	t.Hotwords = models.ParseHotwords(value("hotwords"))
+	if id := value("vocabulary"); id != "" {
+		v := s.Db.GetVocabulary(id)
+		if v == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Vocabulary not found")
+		}
+		t.Hotwords = models.MergeHotwords(t.Hotwords, v.Words)
+	}
+	t.Device = value("device")
+	if t.Device != "cpu" && t.Device != "cuda" {
+		log.Warn().Msgf("Device %v not supported, using cpu", t.Device)
+		t.Device = "cpu"
+	}
+	if o := value("options"); o != "" {
+		if err := json.Unmarshal([]byte(o), &t.Options); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid decoding options")
+		}
+	}
+	if err := t.Options.Validate(t.Device); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid decoding options: "+err.Error())
+	}
+	t.Options = t.Options.WithDefaults(t.Device)
+	if t.Task == models.TaskAlign && !*t.Options.WordTimestamps {
+		return fiber.NewError(fiber.StatusBadRequest, "The align task requires word timestamps")
+	}
+	if value("start") != "" || value("end") != "" {
+		var r models.TimeRange
+		var err error
+		if v := value("start"); v != "" {
+			if r.Start, err = utils.ParseTimestamp(v); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid start of the range")
+			}
+		}
+		if v := value("end"); v != "" {
+			if r.End, err = utils.ParseTimestamp(v); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid end of the range")
+			}
+		}
+		if err := r.Validate(t.Media); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid range: "+err.Error())
+		}
+		t.Range = &r
+	}
+	if p := value("preprocess"); p != "" {
+		var preprocess models.PreprocessOptions
+		if err := json.Unmarshal([]byte(p), &preprocess); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid pre-processing options")
+		}
+		if err := preprocess.Validate(t.Media); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid pre-processing options: "+err.Error())
+		}
+		t.Preprocess = &preprocess
+	}
+	return nil
+}
+
+// CreateTranscription saves a new pending transcription, and queues it for the
+// monitor. Its media is deduplicated first, and if an existing result is reused,
+// the transcription is saved as done.
+func (s *Server) CreateTranscription(transcription *models.Transcription) (*models.Transcription, error) {
+	fileName := transcription.FileName
+	reused := s.Deduplicate(transcription)
+
+	// Save transcription to database
+	res, err := s.Db.NewTranscription(transcription)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving transcription to database")
+		return nil, err
+	}
+
+	// A linked media file already has its waveform
+	if res.HasMedia() && res.FileName == fileName {
+		s.GenerateWaveform(res.FileName)
+	}
+
+	// Broadcast transcription to websocket clients
+	s.BroadcastTranscription(res)
+	if reused {
+		s.RecordRevision(nil, res, "whishper", "reuse")
+		return res, nil
+	}
+	s.NewTranscriptionCh <- true
+	return res, nil
+}
+
+// saveFormFile probes an uploaded file, and stores it in the storage unless it is
+// rejected. It returns the name it was stored with, and its description.
+func (s *Server) saveFormFile(file *multipart.FileHeader) (string, *models.MediaInfo, error) {
+	filename := UploadFileName(file.Filename)
+
+	// The file is probed from a local copy, before it is stored
+	tmp, err := os.CreateTemp("", "whishper-upload-*"+filepath.Ext(filename))
+	if err != nil {
+		log.Error().Err(err).Msgf("Error storing the form file %v", filename)
+		return "", nil, fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	defer os.Remove(tmp.Name())
+	f, err := file.Open()
+	if err == nil {
+		_, err = io.Copy(tmp, f)
+		f.Close()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error storing the form file %v", filename)
+		return "", nil, fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	info, err := probeUpload(tmp.Name(), filename)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := s.Storage.SaveFile(context.Background(), filename, tmp.Name()); err != nil {
+		log.Error().Err(err).Msgf("Error storing the form file %v", filename)
+		return "", nil, fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return filename, info, nil
+}
+
+// deleteUpload deletes a stored upload whose transcription was not created.
+func (s *Server) deleteUpload(filename string) {
+	if filename == "" {
+		return
+	}
+	if err := s.Storage.Delete(context.Background(), filename); err != nil {
+		log.Error().Err(err).Msgf("Error deleting file %v", filename)
+	}
+}
+
+// UploadFileName returns the name a file is stored with in the storage,
+// prefixed with a timestamp.
+func UploadFileName(name string) string {
+	timeid := time.Now().Format("2006_01_02-150405000")
+	// if it's empty and there is no sourceurl we set a timestamp-based filename
+	if name == "" {
+		name = time.Now().Format("2006_01_02-150405")
+	}
+	return timeid + models.FileNameSeparator + name
+}
+
+const probeTimeout = 30 * time.Second
+
+// probeUpload describes the local file of an upload, stored as filename. Files
+// that cannot be transcribed are rejected; if the file could not be probed at
+// all, it is accepted without a description.
+func probeUpload(path, filename string) (*models.MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	info, err := media.Probe(ctx, path)
+	if err == nil {
+		return info, nil
+	}
+	if media.IsInvalid(err) {
+		log.Warn().Err(err).Msgf("Rejecting upload %v", filename)
+		if errors.Is(err, media.ErrUnsupported) {
+			return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, media.ErrUnsupported.Error())
+		}
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	log.Warn().Err(err).Msgf("Could not probe upload %v", filename)
+	return nil, nil
+}
+
 func (s *Server) handleDeleteTranscription(c *fiber.Ctx) error {
 	// First get the transcription from the database
 	id := c.Params("id")
@@ -127,24 +326,31 @@ func (s *Server) handleDeleteTranscription(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusNotFound, "Not found")
 	}
 
-	// Then delete the file from disk
-	err := os.Remove(fmt.Sprintf("%v/%v", os.Getenv("UPLOAD_DIR"), t.FileName))
-	if err != nil {
-		log.Error().Err(err).Msgf("Error deleting file %v", t.FileName)
-	}
+	// Then delete the files from disk. The media may be shared with other
+	// transcriptions of the same file.
+	s.deleteMedia(t)
 
 	// Finally delete the transcription from the database
-	err = s.Db.DeleteTranscription(id)
+	err := s.Db.DeleteTranscription(id)
 	if err != nil {
 		log.Error().Err(err).Msgf("Error deleting transcription %v", id)
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
 	}
+	if err := s.Db.DeleteRevisions(id); err != nil {
+		log.Error().Err(err).Msgf("Error deleting revisions of transcription %v", id)
+	}
+	for _, r := range s.Db.GetRenders(id) {
+		s.deleteRender(r)
+	}
 
 	// Return status deleted
 	c.Status(fiber.StatusOK)
 	return nil
 }
 
+// This function replaces a whole transcription. The body must have the version of
+// the transcription the changes were made on: if it was updated since then, nothing
+// is changed and a 409 Conflict is returned with the current transcription.
 func (s *Server) handlePatchTranscription(c *fiber.Ctx) error {
 	var transcription models.Transcription
 	// Parse the body into the transcription struct.
@@ -153,16 +359,38 @@ func (s *Server) handlePatchTranscription(c *fiber.Ctx) error {
 		log.Error().Err(err).Msg("Error parsing JSON body")
 		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
 	}
+	var version struct {
+		Version *int64 `json:"version"`
+	}
+	if json.Unmarshal(c.Body(), &version); version.Version == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "The version of the transcription is required")
+	}
+
+	// Keep the previous state for the revision history
+	before := s.Db.GetTranscription(transcription.ID.Hex())
+	if before == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if before.Version != transcription.Version {
+		return s.conflict(c, before.ID.Hex())
+	}
+	if sameTranscription(before, &transcription) {
+		return fiber.NewError(fiber.StatusNotModified, "Not modified")
+	}
+	if l := s.heldLock(before.ID.Hex(), c.Query("collaborator"), changedSegments(before, &transcription)); l != nil {
+		return fiber.NewError(fiber.StatusLocked, "The segment is locked by "+l.User)
+	}
 
 	// Update the transcription in the database
 	ut, err := s.Db.UpdateTranscription(&transcription)
 	if err != nil {
-		log.Error().Err(err).Msgf("Error updating transcription")
-		if err.Error() == "no documents were modified" {
-			return fiber.NewError(fiber.StatusNotModified, "Not found")
+		if errors.Is(err, database.ErrVersionConflict) {
+			return s.conflict(c, before.ID.Hex())
 		}
+		log.Error().Err(err).Msgf("Error updating transcription")
 		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
 	}
+	s.RecordRevision(before, ut, author(c), "edit")
 
 	// Write the JSON to the response body.
 	s.BroadcastTranscription(ut)
@@ -179,26 +407,96 @@ func (s *Server) handlePatchTranscription(c *fiber.Ctx) error {
 	return nil
 }
 
+// sameTranscription tells if two transcriptions have the same content.
+func sameTranscription(a, b *models.Transcription) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
 func (s *Server) handleTranslate(c *fiber.Ctx) error {
 	id := c.Params("id")
 	targetLang := c.Params("target")
 
 	transcription := s.Db.GetTranscription(id)
+	if transcription == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return s.TranslateTranscription(transcription, targetLang, author(c))
+}
 
+// TranslateTranscription translates the result of a transcription to the target
+// language, and stores it as a new translation. On success, transcription holds
+// the stored transcription.
+func (s *Server) TranslateTranscription(transcription *models.Transcription, targetLang, author string) error {
 	// Set status as translating
-	transcription.Status = models.TrannscriptionStatusTranslating
-	s.Db.UpdateTranscription(transcription)
+	err := database.UpdateWithRetry(s.Db, transcription, func(t *models.Transcription) {
+		t.Status = models.TrannscriptionStatusTranslating
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error updating transcription")
+	}
 	s.BroadcastTranscription(transcription)
 
-	err := transcription.Translate(targetLang)
+	// Translate a copy, as the transcription may be edited while the translation runs
+	translated := transcription.Copy()
+	err = translated.Translate(targetLang)
 	if err != nil {
 		log.Debug().Err(err).Msg("Error with translation")
 		return err
 	}
+	translation := translated.Translations[len(translated.Translations)-1]
 
 	// Set as done
-	transcription.Status = models.TranscriptionStatusDone
-	s.Db.UpdateTranscription(transcription)
+	var before *models.Transcription
+	err = database.UpdateWithRetry(s.Db, transcription, func(t *models.Transcription) {
+		before = t.Copy()
+		t.Translations = append(t.Translations, translation)
+		t.Status = models.TranscriptionStatusDone
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Error updating transcription")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	s.RecordRevision(before, transcription, author, "translate")
 	s.BroadcastTranscription(transcription)
 	return nil
 }
+
+// saveTranscription stores the changes made to a transcription by a handler,
+// broadcasts it to the websocket clients and writes it in the response body.
+// If `before` is given, the change is recorded as a revision with the given action.
+func (s *Server) saveTranscription(c *fiber.Ctx, before, t *models.Transcription, action string) error {
+	ut, err := s.Db.UpdateTranscription(t)
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			return s.conflict(c, t.ID.Hex())
+		}
+		log.Error().Err(err).Msgf("Error updating transcription %v", t.ID.Hex())
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if before != nil {
+		s.RecordRevision(before, ut, author(c), action)
+	}
+	s.BroadcastTranscription(ut)
+
+	json, err := json.Marshal(ut)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Error parsing json!")
+	}
+	c.Set("Content-Type", "application/json")
+	c.Write(json)
+	return nil
+}
+
+// conflict responds to an update made on an outdated version of a transcription,
+// with a 409 Conflict status and the current transcription in the body.
+func (s *Server) conflict(c *fiber.Ctx, id string) error {
+	log.Debug().Msgf("Version conflict updating transcription %v", id)
+	current := s.Db.GetTranscription(id)
+	if current == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return c.Status(fiber.StatusConflict).JSON(current)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"io"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/utils"
+)
+
+// This function imports existing subtitles (SRT, WebVTT or a Whisper JSON result) as a
+// finished transcription, without running the ASR. The media file is optional, but it
+// is needed to play the media in the editor.
+func (s *Server) handleImportTranscription(c *fiber.Ctx) error {
+	subtitles, err := c.FormFile("subtitles")
+	if err != nil {
+		log.Error().Err(err).Msg("Error getting subtitles field from the form")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	f, err := subtitles.Open()
+	if err != nil {
+		log.Error().Err(err).Msg("Error opening subtitles file")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		log.Error().Err(err).Msg("Error reading subtitles file")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	format := c.FormValue("format")
+	if format == "" {
+		format = utils.DetectSubtitleFormat(subtitles.Filename, data)
+	}
+	result, err := utils.ParseSubtitles(format, data)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Error parsing %v subtitles", format)
+		return fiber.NewError(fiber.StatusBadRequest, "Could not parse subtitles: "+err.Error())
+	}
+
+	var transcription models.Transcription
+	transcription.Language = c.FormValue("language")
+	if transcription.Language == "" {
+		transcription.Language = result.Language
+	}
+	if result.Language == "" {
+		result.Language = transcription.Language
+	}
+	transcription.Status = models.TranscriptionStatusDone
+	transcription.Task = models.TaskImport
+	transcription.SourceUrl = c.FormValue("sourceUrl")
+	transcription.Result = *result
+	transcription.SyncSpeakers()
+	transcription.Translations = []models.Translation{}
+
+	if file, err := c.FormFile("file"); err == nil {
+		transcription.FileName, transcription.Media, err = s.saveFormFile(file)
+		if err != nil {
+			return err
+		}
+	} else {
+		// There is no media file, but keep the name of the subtitles so that
+		// exports are named after them.
+		transcription.FileName = models.FileNameSeparator + subtitles.Filename
+	}
+
+	fileName := transcription.FileName
+	uploaded := transcription.HasMedia()
+	s.Deduplicate(&transcription)
+	res, err := s.Db.NewTranscription(&transcription)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving transcription to database")
+		if uploaded {
+			s.deleteUpload(fileName)
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if res.HasMedia() && res.FileName == fileName {
+		s.GenerateWaveform(res.FileName)
+	}
+	s.RecordRevision(nil, res, author(c), "import")
+	s.BroadcastTranscription(res)
+
+	json, err := json.Marshal(res)
+	if err != nil {
+		return fiber.NewError(fiber.StatusServiceUnavailable, "On vacation!")
+	}
+	c.Status(fiber.StatusCreated)
+	c.Set("Content-Type", "application/json")
+	c.Write(json)
+	return nil
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/redact"
+)
+
+type RedactRequest struct {
+	// Rules are used on top of the configured ones, unless Defaults is false.
+	Rules    []redact.Rule `json:"rules"`
+	Defaults *bool         `json:"defaults"`
+	// Media makes a redacted copy of the media, where the spans are `silence`d
+	// or `bleep`ed.
+	Media string `json:"media"`
+	// PurgeHistory deletes the revisions holding the text before the redaction.
+	PurgeHistory bool `json:"purgeHistory"`
+	DryRun       bool `json:"dryRun"`
+}
+
+// redactor builds the redactor for the rules of the request.
+func (req *RedactRequest) redactor() (*redact.Redactor, error) {
+	var rules []redact.Rule
+	if req.Defaults == nil || *req.Defaults {
+		configured, err := redact.ConfiguredRules()
+		if err != nil {
+			log.Error().Err(err).Msg("Error loading the redaction rules")
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "Invalid redaction rules configured")
+		}
+		rules = configured
+	}
+	rules = append(rules, req.Rules...)
+	if len(rules) == 0 {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "No redaction rules given")
+	}
+	r, err := redact.New(rules)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return r, nil
+}
+
+// This function replaces personal information in the result and translations of
+// a transcription with placeholders, and optionally starts making a redacted copy
+// of the media in the background.
+func (s *Server) handleRedact(c *fiber.Ctx) error {
+	var req RedactRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if req.Media != "" && req.Media != models.RedactionSilence && req.Media != models.RedactionBleep {
+		return fiber.NewError(fiber.StatusBadRequest, "Media redaction must be silence or bleep")
+	}
+	redactor, err := req.redactor()
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if req.Media != "" && !t.HasMedia() {
+		return fiber.NewError(fiber.StatusBadRequest, "The transcription has no media")
+	}
+
+	spans := redactor.Transcription(t.Copy())
+	if req.DryRun {
+		return c.JSON(fiber.Map{"spans": nonNil(spans)})
+	}
+	if req.Media != "" && !hasMediaSpans(spans) && (t.Redaction == nil || !hasMediaSpans(t.Redaction.Spans)) {
+		return fiber.NewError(fiber.StatusBadRequest, "Nothing to redact in the media")
+	}
+
+	var before *models.Transcription
+	err = database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+		before = t.Copy()
+		spans = redactor.Transcription(t)
+		if t.Redaction == nil {
+			t.Redaction = &models.Redaction{}
+		}
+		t.Redaction.Spans = append(t.Redaction.Spans, spans...)
+		if req.Media != "" {
+			t.Redaction.Media = req.Media
+			t.Redaction.Status = models.TranscriptionStatusPending
+		}
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			return s.conflict(c, id)
+		}
+		log.Error().Err(err).Msgf("Error updating transcription %v", id)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	if req.PurgeHistory {
+		// Keep only the redacted state in the history
+		if err := s.Db.DeleteRevisions(id); err != nil {
+			log.Error().Err(err).Msgf("Error deleting revisions of transcription %v", id)
+		}
+		s.RecordRevision(nil, t, author(c), "redact")
+	} else {
+		s.RecordRevision(before, t, author(c), "redact")
+	}
+	s.BroadcastTranscription(t)
+
+	if req.Media != "" {
+		go s.redactMedia(id)
+	}
+	return c.JSON(fiber.Map{"spans": nonNil(spans), "transcription": t})
+}
+
+// redactMedia makes the redacted copy of the media of a transcription, with all
+// the spans redacted so far.
+func (s *Server) redactMedia(id string) {
+	t := s.Db.GetTranscription(id)
+	if t == nil || t.Redaction == nil {
+		return
+	}
+	setStatus := func(status int, fileName string) {
+		err := database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+			if t.Redaction == nil {
+				t.Redaction = &models.Redaction{}
+			}
+			t.Redaction.Status = status
+			if fileName != "" {
+				t.Redaction.FileName = fileName
+			}
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Error updating transcription %v", t.ID.Hex())
+			return
+		}
+		s.BroadcastTranscription(t)
+	}
+
+	setStatus(models.TranscriptionStatusRunning, "")
+	// The media may be shared with other transcriptions, which have their own
+	// redactions, so the copy is named after the transcription
+	ext := filepath.Ext(t.FileName)
+	fileName := fmt.Sprintf("%v.redacted-%v%v", strings.TrimSuffix(t.FileName, ext), t.ID.Hex(), ext)
+	previous := t.Redaction.FileName
+	err := s.redactFile(t.FileName, fileName, t.Redaction.Spans, t.Redaction.Media)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error redacting the media of transcription %v", t.ID.Hex())
+		setStatus(models.TranscriptionStatusError, "")
+		return
+	}
+	setStatus(models.TranscriptionStatusDone, fileName)
+	if previous != "" && previous != fileName && !s.redactionShared(t.Hash, t.ID, previous) {
+		// Copies made before they were named after the transcription
+		if err := s.Storage.Delete(context.Background(), previous); err != nil {
+			log.Error().Err(err).Msgf("Error deleting file %v", previous)
+		}
+	}
+}
+
+// redactFile redacts a local copy of the media into a temporary file, which is
+// then stored as fileName.
+func (s *Server) redactFile(src, fileName string, spans []models.RedactedSpan, mode string) error {
+	ctx := context.Background()
+	path, release, err := s.Storage.Local(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer release()
+	f, err := os.CreateTemp("", "whishper-redact-*"+filepath.Ext(fileName))
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := redact.Media(ctx, path, f.Name(), spans, mode); err != nil {
+		return err
+	}
+	return s.Storage.SaveFile(ctx, fileName, f.Name())
+}
+
+// hasMediaSpans tells if any span is in the original result, which is timed
+// with the media.
+func hasMediaSpans(spans []models.RedactedSpan) bool {
+	for _, s := range spans {
+		if s.Translation == "" {
+			return true
+		}
+	}
+	return false
+}
+
+func nonNil(spans []models.RedactedSpan) []models.RedactedSpan {
+	if spans == nil {
+		return []models.RedactedSpan{}
+	}
+	return spans
+}
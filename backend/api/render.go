@@ -0,0 +1,176 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/models"
+)
+
+type RenderRequest struct {
+	// Mode is `burn` to draw a single subtitle track on the video, or `soft` to
+	// embed subtitle tracks in the container.
+	Mode string `json:"mode"`
+	// Original adds the result as a track (default: true). Translations add the
+	// translations to these target languages.
+	Original     *bool                `json:"original"`
+	Translations []string             `json:"translations"`
+	Style        models.SubtitleStyle `json:"style"`
+	Speakers     bool                 `json:"speakers"`
+	// Container is `mp4` (the default when burning) or `mkv` (the default for
+	// soft subtitles).
+	Container string `json:"container"`
+}
+
+// This function creates a job that renders the media of a transcription with its
+// subtitles. The job runs in the background; its status is returned by the
+// render endpoints, and the result is downloaded once it is done.
+func (s *Server) handlePostRender(c *fiber.Ctx) error {
+	var req RenderRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if !t.HasMedia() {
+		return fiber.NewError(fiber.StatusBadRequest, "The transcription has no media")
+	}
+	if t.Status != models.TranscriptionStatusDone {
+		return fiber.NewError(fiber.StatusBadRequest, "The transcription is not done")
+	}
+	if _, err := t.MediaFile(); err != nil {
+		return fiber.NewError(fiber.StatusConflict, "The redacted copy of the media is not ready")
+	}
+
+	r := models.Render{
+		TranscriptionID: t.ID,
+		Mode:            req.Mode,
+		Original:        req.Original == nil || *req.Original,
+		Translations:    []string{},
+		Speakers:        req.Speakers,
+		Container:       req.Container,
+		Status:          models.TranscriptionStatusPending,
+		CreatedAt:       time.Now(),
+		UpdatedAt:       time.Now(),
+	}
+	for _, language := range req.Translations {
+		if findTranslation(t, language) == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Translation to "+language+" not found")
+		}
+		r.Translations = append(r.Translations, language)
+	}
+	switch r.Mode {
+	case models.RenderBurn:
+		if r.Tracks() != 1 {
+			return fiber.NewError(fiber.StatusBadRequest, "Burning needs exactly one subtitle track")
+		}
+		if t.Media != nil && t.Media.Video == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "The media has no video")
+		}
+		if err := req.Style.Validate(); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid style: "+err.Error())
+		}
+		r.Style = req.Style.WithDefaults()
+		if r.Container == "" {
+			r.Container = models.ContainerMp4
+		}
+	case models.RenderSoft:
+		if r.Tracks() == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "No subtitle tracks selected")
+		}
+		if r.Container == "" {
+			r.Container = models.ContainerMkv
+		}
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "The mode must be burn or soft")
+	}
+	if r.Container != models.ContainerMp4 && r.Container != models.ContainerMkv {
+		return fiber.NewError(fiber.StatusBadRequest, "The container must be mp4 or mkv")
+	}
+
+	res, err := s.Db.NewRender(&r)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving render to database")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	s.NewRenderCh <- true
+	return c.Status(fiber.StatusAccepted).JSON(res)
+}
+
+func (s *Server) handleGetRenders(c *fiber.Ctx) error {
+	if s.Db.GetTranscription(c.Params("id")) == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	renders := s.Db.GetRenders(c.Params("id"))
+	if renders == nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(renders)
+}
+
+func (s *Server) handleGetRender(c *fiber.Ctx) error {
+	r := s.Db.GetRender(c.Params("id"))
+	if r == nil {
+		log.Warn().Msgf("Render with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return c.JSON(r)
+}
+
+// This function downloads the rendered media, named after the original media.
+func (s *Server) handleDownloadRender(c *fiber.Ctx) error {
+	r := s.Db.GetRender(c.Params("id"))
+	if r == nil {
+		log.Warn().Msgf("Render with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if r.Status != models.TranscriptionStatusDone {
+		return fiber.NewError(fiber.StatusConflict, "The render is not done")
+	}
+	name := "render." + r.Container
+	if t := s.Db.GetTranscription(r.TranscriptionID.Hex()); t != nil {
+		name = export.BaseName(t) + ".subtitled." + r.Container
+	}
+	return s.sendFile(c, r.FileName, name)
+}
+
+func (s *Server) handleDeleteRender(c *fiber.Ctx) error {
+	r := s.Db.GetRender(c.Params("id"))
+	if r == nil {
+		log.Warn().Msgf("Render with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if r.Status == models.TranscriptionStatusRunning {
+		return fiber.NewError(fiber.StatusConflict, "The render is running")
+	}
+	if err := s.deleteRender(r); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	c.Status(fiber.StatusOK)
+	return nil
+}
+
+// deleteRender deletes a render and its file.
+func (s *Server) deleteRender(r *models.Render) error {
+	if r.FileName != "" {
+		if err := s.Storage.Delete(context.Background(), r.FileName); err != nil {
+			log.Error().Err(err).Msgf("Error deleting file %v", r.FileName)
+		}
+	}
+	if err := s.Db.DeleteRender(r.ID.Hex()); err != nil {
+		log.Error().Err(err).Msgf("Error deleting render %v", r.ID.Hex())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	ReplaceScopeResult      = "result"
+	ReplaceScopeTranslation = "translation"
+	ReplaceScopeBoth        = "both"
+)
+
+type ReplaceRequest struct {
+	models.ReplaceOptions
+	// Scope is `result`, `translation` or `both`.
+	Scope string `json:"scope"`
+	// Translation is the target language of the translation to search, or empty
+	// for all of them.
+	Translation string `json:"translation"`
+	// DryRun only returns the matches, without changing anything.
+	DryRun bool `json:"dryRun"`
+}
+
+type ReplaceResponse struct {
+	Count   int                   `json:"count"`
+	Matches []models.ReplaceMatch `json:"matches"`
+	// Version of the transcription after the replacement
+	Version int64 `json:"version"`
+}
+
+// replaceAll runs the replacement on the results selected by the request.
+func (req *ReplaceRequest) replaceAll(r *models.Replacer, t *models.Transcription) *ReplaceResponse {
+	resp := &ReplaceResponse{Matches: []models.ReplaceMatch{}}
+	if req.Scope == ReplaceScopeResult || req.Scope == ReplaceScopeBoth {
+		resp.Matches = append(resp.Matches, r.ReplaceResult(&t.Result, "")...)
+	}
+	if req.Scope == ReplaceScopeTranslation || req.Scope == ReplaceScopeBoth {
+		for i := range t.Translations {
+			tr := &t.Translations[i]
+			if req.Translation == "" || tr.TargetLanguage == req.Translation {
+				resp.Matches = append(resp.Matches, r.ReplaceResult(&tr.Result, tr.TargetLanguage)...)
+			}
+		}
+	}
+	for _, m := range resp.Matches {
+		resp.Count += m.Count
+	}
+	return resp
+}
+
+// This function finds and replaces text in the result and/or translations of a
+// transcription. The whole replacement is stored as a single revision.
+func (s *Server) handleReplace(c *fiber.Ctx) error {
+	var req ReplaceRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if req.Find == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "The text to find is required")
+	}
+	if req.Scope == "" {
+		req.Scope = ReplaceScopeResult
+	}
+	if req.Scope != ReplaceScopeResult && req.Scope != ReplaceScopeTranslation && req.Scope != ReplaceScopeBoth {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid scope "+req.Scope)
+	}
+	replacer, err := models.NewReplacer(req.ReplaceOptions)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid regular expression: "+err.Error())
+	}
+
+	id := c.Params("id")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if req.Translation != "" && req.Scope != ReplaceScopeResult && !hasTranslation(t, req.Translation) {
+		return fiber.NewError(fiber.StatusNotFound, "Translation not found")
+	}
+
+	preview := req.replaceAll(replacer, t.Copy())
+	preview.Version = t.Version
+	if req.DryRun || preview.Count == 0 {
+		return c.JSON(preview)
+	}
+
+	// The replacement is run again on the latest version if the transcription
+	// is changed in the meantime.
+	var before *models.Transcription
+	var resp *ReplaceResponse
+	err = database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+		before = t.Copy()
+		resp = req.replaceAll(replacer, t)
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			return s.conflict(c, id)
+		}
+		log.Error().Err(err).Msgf("Error updating transcription %v", id)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	resp.Version = t.Version
+	s.RecordRevision(before, t, author(c), "replace")
+	s.BroadcastTranscription(t)
+	return c.JSON(resp)
+}
+
+func hasTranslation(t *models.Transcription, target string) bool {
+	for _, tr := range t.Translations {
+		if tr.TargetLanguage == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"os"
+	"strconv"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Confidence below which segments and words are reviewed, if not set with
+// REVIEW_THRESHOLD or the `threshold` query parameter.
+const defaultReviewThreshold = 0.5
+
+type ReviewResponse struct {
+	TranscriptionID string                `json:"transcriptionId"`
+	Name            string                `json:"name"`
+	Threshold       float64               `json:"threshold"`
+	Progress        models.ReviewProgress `json:"progress"`
+	Items           []models.ReviewItem   `json:"items"`
+}
+
+// reviewThreshold returns the threshold given in the request, or the configured one.
+func reviewThreshold(c *fiber.Ctx) (float64, error) {
+	value := c.Query("threshold", os.Getenv("REVIEW_THRESHOLD"))
+	if value == "" {
+		return defaultReviewThreshold, nil
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return 0, fiber.NewError(fiber.StatusBadRequest, "The threshold must be between 0 and 1")
+	}
+	return threshold, nil
+}
+
+// review lists the items to review of a transcription. Verified items are left
+// out of the list unless `all` is set, but they always count for the progress.
+func review(t *models.Transcription, threshold float64, all bool) *ReviewResponse {
+	items := t.ReviewItems(threshold)
+	resp := &ReviewResponse{
+		TranscriptionID: t.ID.Hex(),
+		Name:            t.DisplayName(),
+		Threshold:       threshold,
+		Progress:        models.Progress(items),
+		Items:           items,
+	}
+	if !all {
+		resp.Items = []models.ReviewItem{}
+		for _, item := range items {
+			if !item.Verified {
+				resp.Items = append(resp.Items, item)
+			}
+		}
+	}
+	return resp
+}
+
+func (s *Server) handleGetReview(c *fiber.Ctx) error {
+	threshold, err := reviewThreshold(c)
+	if err != nil {
+		return err
+	}
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return c.JSON(review(t, threshold, c.QueryBool("all")))
+}
+
+// This function returns the review queue of all the finished transcriptions
+// that still have items to verify.
+func (s *Server) handleGetReviewQueue(c *fiber.Ctx) error {
+	threshold, err := reviewThreshold(c)
+	if err != nil {
+		return err
+	}
+	queue := []*ReviewResponse{}
+	for _, t := range s.Db.GetAllTranscriptions() {
+		if t.Status != models.TranscriptionStatusDone {
+			continue
+		}
+		if r := review(t, threshold, c.QueryBool("all")); r.Progress.Verified < r.Progress.Total {
+			queue = append(queue, r)
+		}
+	}
+	return c.JSON(queue)
+}
+
+// This function marks items of the review queue of a transcription as verified,
+// or as not verified if `verified` is false. Items are given as in the queue,
+// with their `segmentId` and `word` (-1 for the whole segment).
+func (s *Server) handleVerify(c *fiber.Ctx) error {
+	var req struct {
+		Items    []models.ReviewItem `json:"items"`
+		Verified *bool               `json:"verified"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if len(req.Items) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "No items given")
+	}
+	verified := req.Verified == nil || *req.Verified
+	threshold, err := reviewThreshold(c)
+	if err != nil {
+		return err
+	}
+
+	id := c.Params("id")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	apply := func(t *models.Transcription) error {
+		for _, item := range req.Items {
+			if err := t.Result.SetVerified(item.SegmentID, item.Word, verified); err != nil {
+				if errors.Is(err, models.ErrSegmentNotFound) {
+					return fiber.NewError(fiber.StatusNotFound, err.Error())
+				}
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid word index")
+			}
+		}
+		return nil
+	}
+	// Check the items before storing anything
+	if err := apply(t.Copy()); err != nil {
+		return err
+	}
+
+	// Verification does not change the content, so it is not recorded as a revision.
+	err = database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+		if err := apply(t); err != nil {
+			log.Warn().Err(err).Msgf("Item to verify of transcription %v changed", id)
+		}
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("Error updating transcription %v", id)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	s.BroadcastTranscription(t)
+	return c.JSON(review(t, threshold, c.QueryBool("all")))
+}
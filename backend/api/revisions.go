@@ -0,0 +1,202 @@
+package api
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Header used by clients to tell who made a change. The client IP is used if it is missing.
+const AuthorHeader = "X-Whishper-User"
+
+// revisionSnapshotInterval is how often revisions hold a full snapshot of the
+// result and translations. The others only hold the diff from the previous state.
+const revisionSnapshotInterval = 10
+
+// RecordRevision stores the diff of the result and translations of a transcription
+// from its previous state, after a change. `before` may be nil when the result is
+// created. The first revision, and then one every revisionSnapshotInterval, also
+// stores a snapshot. Nothing is stored if the result and translations did not change.
+func (s *Server) RecordRevision(before, after *models.Transcription, author, action string) {
+	var diff []models.SegmentDiff
+	if before == nil {
+		diff = models.DiffTranscription(nil, nil, &after.Result, after.Translations)
+	} else {
+		diff = models.DiffTranscription(&before.Result, before.Translations, &after.Result, after.Translations)
+	}
+	if before != nil && len(diff) == 0 {
+		return
+	}
+
+	revisions := s.Db.GetRevisions(after.ID.Hex())
+	revision := &models.Revision{
+		TranscriptionID: after.ID,
+		Author:          author,
+		Action:          action,
+		CreatedAt:       time.Now(),
+		Diff:            diff,
+	}
+	if before == nil || needsSnapshot(revisions) {
+		result := models.CopyResult(after.Result)
+		revision.Result = &result
+		revision.Translations = models.CopyTranslations(after.Translations)
+	} else {
+		result, translations := models.WithoutSegments(after.Result, after.Translations)
+		revision.Result = &result
+		revision.Translations = translations
+		revision.Partial = true
+	}
+	if _, err := s.Db.NewRevision(revision); err != nil {
+		log.Error().Err(err).Msgf("Error saving revision of transcription %v", after.ID.Hex())
+		return
+	}
+
+	// Apply the retention limits
+	keep, _ := strconv.Atoi(os.Getenv("REVISION_LIMIT"))
+	if os.Getenv("REVISION_LIMIT") == "" {
+		keep = 50
+	}
+	var oldest time.Time
+	if days, _ := strconv.Atoi(os.Getenv("REVISION_MAX_AGE_DAYS")); days > 0 {
+		oldest = time.Now().AddDate(0, 0, -days)
+	}
+	s.keepRevisionBase(append([]*models.Revision{revision}, revisions...), keep, oldest)
+	if err := s.Db.PruneRevisions(after.ID.Hex(), keep, oldest); err != nil {
+		log.Error().Err(err).Msgf("Error pruning revisions of transcription %v", after.ID.Hex())
+	}
+}
+
+// needsSnapshot tells if the next revision must be a full one, given the
+// revisions of the transcription, newest first.
+func needsSnapshot(revisions []*models.Revision) bool {
+	for i, r := range revisions {
+		if !r.Partial {
+			return i >= revisionSnapshotInterval-1
+		}
+	}
+	return true
+}
+
+// keepRevisionBase makes the oldest revision that is kept by the pruning a full
+// one, so that the revisions after it can still be rebuilt once the full
+// revision they are based on is deleted. Revisions are given newest first.
+func (s *Server) keepRevisionBase(revisions []*models.Revision, keep int, oldest time.Time) {
+	k := len(revisions) - 1
+	if keep > 0 && k > keep-1 {
+		k = keep - 1
+	}
+	for k > 0 && revisions[k].CreatedAt.Before(oldest) {
+		k--
+	}
+	r := revisions[k]
+	if !r.Partial || k == len(revisions)-1 {
+		return
+	}
+	result, translations, err := models.RebuildRevision(s.Db.GetRevisionHistory(r.TranscriptionID.Hex(), r.ID.Hex()))
+	if err == nil {
+		err = s.Db.SetRevisionSnapshot(r.ID.Hex(), result, translations)
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error storing the snapshot of revision %v", r.ID.Hex())
+	}
+}
+
+// author returns who is making a request.
+func author(c *fiber.Ctx) string {
+	if a := c.Get(AuthorHeader); a != "" {
+		return a
+	}
+	return c.IP()
+}
+
+func (s *Server) handleGetRevisions(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if s.Db.GetTranscription(id) == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return c.JSON(s.Db.GetRevisions(id))
+}
+
+func (s *Server) handleGetRevision(c *fiber.Ctx) error {
+	r, err := s.getRevision(c.Params("id"), c.Params("revision"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(r)
+}
+
+// This function returns the diff between two revisions, `from` and `to`. If `to`
+// is not given, the diff is made against the current state of the transcription.
+func (s *Server) handleDiffRevisions(c *fiber.Ctx) error {
+	id := c.Params("id")
+	from, err := s.getRevision(id, c.Query("from"))
+	if err != nil {
+		return err
+	}
+
+	var toResult *models.WhisperResult
+	var toTranslations []models.Translation
+	if c.Query("to") != "" {
+		to, err := s.getRevision(id, c.Query("to"))
+		if err != nil {
+			return err
+		}
+		toResult, toTranslations = to.Result, to.Translations
+	} else {
+		t := s.Db.GetTranscription(id)
+		if t == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Not found")
+		}
+		toResult, toTranslations = &t.Result, t.Translations
+	}
+	return c.JSON(models.DiffTranscription(from.Result, from.Translations, toResult, toTranslations))
+}
+
+// This function restores the result and translations of a revision. The restore is
+// recorded as a new revision, so it can be undone too.
+func (s *Server) handleRestoreRevision(c *fiber.Ctx) error {
+	id := c.Params("id")
+	r, err := s.getRevision(id, c.Params("revision"))
+	if err != nil {
+		return err
+	}
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	before := t.Copy()
+	t.Result = *r.Result
+	t.Translations = r.Translations
+	if t.Translations == nil {
+		t.Translations = []models.Translation{}
+	}
+	t.SyncSpeakers()
+	return s.saveTranscription(c, before, t, "restore")
+}
+
+func (s *Server) getRevision(transcriptionId, revisionId string) (*models.Revision, error) {
+	r := s.Db.GetRevision(revisionId)
+	if r == nil || r.TranscriptionID.Hex() != transcriptionId {
+		log.Warn().Msgf("Revision %v of transcription %v not found", revisionId, transcriptionId)
+		return nil, fiber.NewError(fiber.StatusNotFound, "Revision not found")
+	}
+	if r.Partial {
+		result, translations, err := models.RebuildRevision(s.Db.GetRevisionHistory(transcriptionId, revisionId))
+		if err != nil {
+			log.Error().Err(err).Msgf("Error rebuilding revision %v of transcription %v", revisionId, transcriptionId)
+			return nil, fiber.NewError(fiber.StatusGone, "The revision cannot be rebuilt")
+		}
+		r.Result, r.Translations = result, translations
+	}
+	if r.Result == nil {
+		r.Result = &models.WhisperResult{}
+	}
+	return r, nil
+}
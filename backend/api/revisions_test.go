@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+func TestNeedsSnapshot(t *testing.T) {
+	history := func(partial ...bool) []*models.Revision {
+		revisions := make([]*models.Revision, len(partial))
+		for i, p := range partial {
+			revisions[i] = &models.Revision{Partial: p}
+		}
+		return revisions
+	}
+	partials := make([]bool, revisionSnapshotInterval-1)
+	for i := range partials {
+		partials[i] = true
+	}
+	tests := []struct {
+		name      string
+		revisions []*models.Revision
+		want      bool
+	}{
+		{"first", nil, true},
+		{"after a full one", history(false), false},
+		{"a few after a full one", history(true, true, false), false},
+		{"interval reached", history(append(partials, false)...), true},
+		{"no full one", history(true, true), true},
+	}
+	for _, tt := range tests {
+		if got := needsSnapshot(tt.revisions); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
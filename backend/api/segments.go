@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/models"
+)
+
+const MessageTypeSegments = "segments"
+
+// SegmentsMessage is broadcast to the ws clients when segments are edited, instead
+// of the whole transcription. Clients should replace the segments with the same ids,
+// add the new ones, drop the removed ones and keep them sorted by start time.
+type SegmentsMessage struct {
+	Type            string `json:"type"`
+	TranscriptionID string `json:"transcriptionId"`
+	// Version of the transcription after the change
+	Version int64 `json:"version"`
+	// Translation is the target language of the edited translation, or empty
+	// for the original result.
+	Translation string           `json:"translation,omitempty"`
+	Segments    []models.Segment `json:"segments"`
+	Removed     []string         `json:"removed"`
+	Text        string           `json:"text"`
+}
+
+// segmentOp runs an edit operation on the result (or the translation selected with
+// the `translation` query parameter) of a transcription, stores the result and
+// broadcasts the changed segments. Operations on segments locked by a
+// collaborator other than the one given by the `collaborator` query parameter
+// are rejected.
+func (s *Server) segmentOp(c *fiber.Ctx, action string, op func(res *models.WhisperResult) ([]models.Segment, []string, error)) error {
+	id := c.Params("id")
+	t := s.Db.GetTranscription(id)
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", id)
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	if v := c.Query("version"); v != "" && v != strconv.FormatInt(t.Version, 10) {
+		return s.conflict(c, id)
+	}
+
+	res, index := &t.Result, -1
+	target := c.Query("translation")
+	if target != "" {
+		for i := range t.Translations {
+			if t.Translations[i].TargetLanguage == target {
+				res, index = &t.Translations[i].Result, i
+			}
+		}
+		if index < 0 {
+			return fiber.NewError(fiber.StatusNotFound, "Translation not found")
+		}
+	}
+
+	before := t.Copy()
+	changed, removed, err := op(res)
+	if err != nil {
+		if errors.Is(err, models.ErrSegmentNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	keys := make([]string, 0, len(changed)+len(removed))
+	for _, seg := range changed {
+		keys = append(keys, lockKey(target, seg.ID))
+	}
+	for _, segment := range removed {
+		keys = append(keys, lockKey(target, segment))
+	}
+	if l := s.heldLock(id, c.Query("collaborator"), keys); l != nil {
+		return fiber.NewError(fiber.StatusLocked, "The segment is locked by "+l.User)
+	}
+
+	version, err := s.Db.UpdateResult(id, t.Version, index, res)
+	if err != nil {
+		if errors.Is(err, database.ErrVersionConflict) {
+			return s.conflict(c, id)
+		}
+		log.Error().Err(err).Msgf("Error updating result of transcription %v", id)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	t.Version = version
+	s.RecordRevision(before, t, author(c), action)
+
+	if changed == nil {
+		changed = []models.Segment{}
+	}
+	if removed == nil {
+		removed = []string{}
+	}
+	msg := &SegmentsMessage{
+		Type:            MessageTypeSegments,
+		TranscriptionID: id,
+		Version:         version,
+		Translation:     target,
+		Segments:        changed,
+		Removed:         removed,
+		Text:            res.Text,
+	}
+	s.broadcastSegments(msg)
+	return c.JSON(msg)
+}
+
+// broadcastSegments sends a segments message to all ws clients, and to the
+// collaborators editing the transcription.
+func (s *Server) broadcastSegments(msg *SegmentsMessage) {
+	s.broadcast(msg)
+	s.broadcastRoom(msg.TranscriptionID, msg)
+}
+
+func (s *Server) handleSplitSegment(c *fiber.Ctx) error {
+	var req struct {
+		// Time at which to split, used if Word is not given
+		Time float64 `json:"time"`
+		// Index of the first word of the second segment
+		Word *int `json:"word"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	word := -1
+	if req.Word != nil {
+		word = *req.Word
+	}
+	return s.segmentOp(c, "split segment", func(res *models.WhisperResult) ([]models.Segment, []string, error) {
+		segments, err := res.SplitSegment(c.Params("segment"), req.Time, word)
+		return segments, nil, err
+	})
+}
+
+func (s *Server) handleMergeSegments(c *fiber.Ctx) error {
+	var req struct {
+		Segments []string `json:"segments"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	return s.segmentOp(c, "merge segments", func(res *models.WhisperResult) ([]models.Segment, []string, error) {
+		merged, removed, err := res.MergeSegments(req.Segments)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []models.Segment{*merged}, removed, nil
+	})
+}
+
+func (s *Server) handleInsertSegment(c *fiber.Ctx) error {
+	var seg models.Segment
+	if err := json.Unmarshal(c.Body(), &seg); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	return s.segmentOp(c, "insert segment", func(res *models.WhisperResult) ([]models.Segment, []string, error) {
+		inserted, err := res.InsertSegment(seg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []models.Segment{*inserted}, nil, nil
+	})
+}
+
+func (s *Server) handleDeleteSegment(c *fiber.Ctx) error {
+	return s.segmentOp(c, "delete segment", func(res *models.WhisperResult) ([]models.Segment, []string, error) {
+		id := c.Params("segment")
+		return nil, []string{id}, res.DeleteSegment(id)
+	})
+}
+
+func (s *Server) handleRetimeSegment(c *fiber.Ctx) error {
+	var req struct {
+		Start *float64 `json:"start"`
+		End   *float64 `json:"end"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	return s.segmentOp(c, "retime segment", func(res *models.WhisperResult) ([]models.Segment, []string, error) {
+		seg, err := res.RetimeSegment(c.Params("segment"), req.Start, req.End)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []models.Segment{*seg}, nil, nil
+	})
+}
@@ -1,7 +1,7 @@
 package api
 
 import (
-	"os"
+	"sync"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/websocket"
@@ -11,17 +11,27 @@ import (
 
 	"codeberg.org/pluja/whishper/database"
 	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/storage"
 )
 
 type Server struct {
 	ListenAddr         string
 	Router             *fiber.App
 	Db                 database.Db
+	Storage            storage.Storage
 	NewTranscriptionCh chan bool
-	clients            []*websocket.Conn
+	NewRenderCh        chan bool
+	clients            []*wsClient
+	clientsMu          sync.Mutex
+	// Collaborative editing rooms, by transcription ID
+	rooms   map[string]*room
+	roomsMu sync.Mutex
+	// Resumable uploads that are being written, by upload ID
+	uploading map[string]bool
+	uploadsMu sync.Mutex
 }
 
-func NewServer(listenAddr string, db database.Db) *Server {
+func NewServer(listenAddr string, db database.Db, store storage.Storage) *Server {
 	return &Server{
 		ListenAddr: listenAddr,
 		Router: fiber.New(fiber.Config{
@@ -29,10 +39,16 @@ func NewServer(listenAddr string, db database.Db) *Server {
 			JSONDecoder:  json.Unmarshal,
 			BodyLimit:    100000 * 1024 * 1024, // Increase body limit to 100000MB (100GB)
 			ServerHeader: "Fiber",              // Optional, for easier debugging
+			// Stream the request bodies, so that resumable uploads are written as they are received
+			StreamRequestBody: true,
 		}),
 		Db:                 db,
-		clients:            make([]*websocket.Conn, 0),
+		Storage:            store,
+		clients:            make([]*wsClient, 0),
+		rooms:              make(map[string]*room),
+		uploading:          make(map[string]bool),
 		NewTranscriptionCh: make(chan bool, 100),
+		NewRenderCh:        make(chan bool, 100),
 	}
 }
 
@@ -47,7 +63,10 @@ func (s *Server) SetupWebsocket() {
 	s.Router.Get("/ws/transcriptions", websocket.New(func(c *websocket.Conn) {
 
 		// Add this connection to the slice of clients
-		s.clients = append(s.clients, c)
+		client := &wsClient{conn: c}
+		s.clientsMu.Lock()
+		s.clients = append(s.clients, client)
+		s.clientsMu.Unlock()
 
 		for {
 			_, msg, err := c.ReadMessage()
@@ -58,35 +77,63 @@ func (s *Server) SetupWebsocket() {
 					log.Debug().Err(err).Msgf("Error reading message")
 				}
 				// Remove the client from the slice if it has disconnected
-				s.clients = removeWsClient(s.clients, c)
+				s.clientsMu.Lock()
+				s.clients = removeWsClient(s.clients, client)
+				s.clientsMu.Unlock()
 				return
 			}
-			s.handleWebsocketMessage(c, msg)
+			s.handleWebsocketMessage(client, msg)
 		}
 	}))
+
+	s.Router.Get("/ws/transcriptions/:id", websocket.New(s.handleCollab))
 }
 
 func (s *Server) BroadcastTranscription(t *models.Transcription) {
-	// Convert the transcription to JSON.
-	json, err := json.Marshal(&t)
+	s.broadcast(t)
+	s.broadcastRoom(t.ID.Hex(), &CollabMessage{Type: MessageTypeTranscription, Transcription: t})
+}
+
+// broadcast sends a message, encoded as JSON, to all ws clients.
+func (s *Server) broadcast(msg interface{}) {
+	json, err := json.Marshal(msg)
 	if err != nil {
-		log.Error().Err(err).Msg("Error marshalling transcription to JSON:")
+		log.Error().Err(err).Msg("Error marshalling message to JSON:")
 		return
 	}
-	for _, client := range s.clients {
-		if err := client.WriteMessage(websocket.TextMessage, json); err != nil {
+	// Messages are broadcast from many goroutines, so the clients are copied
+	// rather than iterated while others may connect or leave
+	s.clientsMu.Lock()
+	clients := append([]*wsClient{}, s.clients...)
+	s.clientsMu.Unlock()
+	for _, client := range clients {
+		if err := client.send(json); err != nil {
 			log.Error().Err(err).Msg("Error broadcasting message:")
 		}
 	}
 }
 
 func (s *Server) SetupMiddleware() {
-	s.Router.Use(cors.New())
+	s.Router.Use(cors.New(cors.Config{
+		ExposeHeaders: TusHeaders,
+		// Only preflight requests are answered by the middleware, the tus OPTIONS
+		// requests reach their handler
+		Next: func(c *fiber.Ctx) bool {
+			return c.Method() == fiber.MethodOptions && c.Get(fiber.HeaderAccessControlRequestMethod) == ""
+		},
+	}))
 }
 
 func (s *Server) RegisterRoutes() {
-	// Static routes
-	s.Router.Static("/api/video", os.Getenv("UPLOAD_DIR"))
+	// Media files, streamed with range requests for playback
+	s.Router.Get("/api/video/*", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/video/%v", c.Params("*"))
+		err := s.handleGetVideo(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/video/*")
+		}
+		return err
+	})
 
 	// Register HTTP route for getting initial state.
 	s.Router.Get("/api/transcriptions", func(c *fiber.Ctx) error {
@@ -117,6 +164,155 @@ func (s *Server) RegisterRoutes() {
 		return err
 	})
 
+	// Register HTTP routes for editing the segments of a transcription.
+	s.Router.Post("/api/transcriptions/:id/segments", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/segments", c.Params("id"))
+		err := s.handleInsertSegment(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/segments")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/replace", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/replace", c.Params("id"))
+		err := s.handleReplace(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/replace")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/segments/merge", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/segments/merge", c.Params("id"))
+		err := s.handleMergeSegments(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/segments/merge")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/segments/:segment/split", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/segments/%v/split", c.Params("id"), c.Params("segment"))
+		err := s.handleSplitSegment(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/segments/:segment/split")
+		}
+		return err
+	})
+
+	s.Router.Patch("/api/transcriptions/:id/segments/:segment", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("PATCH /api/transcriptions/%v/segments/%v", c.Params("id"), c.Params("segment"))
+		err := s.handleRetimeSegment(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling PATCH /api/transcriptions/:id/segments/:segment")
+		}
+		return err
+	})
+
+	s.Router.Delete("/api/transcriptions/:id/segments/:segment", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("DELETE /api/transcriptions/%v/segments/%v", c.Params("id"), c.Params("segment"))
+		err := s.handleDeleteSegment(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling DELETE /api/transcriptions/:id/segments/:segment")
+		}
+		return err
+	})
+
+	// Register HTTP routes for the revision history of a transcription.
+	s.Router.Get("/api/transcriptions/:id/revisions", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/revisions", c.Params("id"))
+		err := s.handleGetRevisions(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/revisions")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/transcriptions/:id/revisions/diff", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/revisions/diff", c.Params("id"))
+		err := s.handleDiffRevisions(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/revisions/diff")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/transcriptions/:id/revisions/:revision", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/revisions/%v", c.Params("id"), c.Params("revision"))
+		err := s.handleGetRevision(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/revisions/:revision")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/revisions/:revision/restore", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/revisions/%v/restore", c.Params("id"), c.Params("revision"))
+		err := s.handleRestoreRevision(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/revisions/:revision/restore")
+		}
+		return err
+	})
+
+	// Register HTTP routes for managing the speakers of a transcription.
+	s.Router.Get("/api/transcriptions/:id/speakers", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/speakers", c.Params("id"))
+		err := s.handleGetSpeakers(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/speakers")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/speakers/merge", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/speakers/merge", c.Params("id"))
+		err := s.handleMergeSpeakers(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/speakers/merge")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/speakers/assign", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/speakers/assign", c.Params("id"))
+		err := s.handleAssignSpeaker(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/speakers/assign")
+		}
+		return err
+	})
+
+	s.Router.Patch("/api/transcriptions/:id/speakers/:speaker", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("PATCH /api/transcriptions/%v/speakers/%v", c.Params("id"), c.Params("speaker"))
+		err := s.handlePatchSpeaker(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling PATCH /api/transcriptions/:id/speakers/:speaker")
+		}
+		return err
+	})
+
+	// Register HTTP route for exporting a transcription.
+	s.Router.Get("/api/transcriptions/:id/export/:format", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/export/%v", c.Params("id"), c.Params("format"))
+		err := s.handleExport(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/export/:format")
+		}
+		return err
+	})
+
+	// Register HTTP route for exporting many transcriptions as a ZIP archive.
+	s.Router.Post("/api/export", func(c *fiber.Ctx) error {
+		log.Debug().Msg("POST /api/export")
+		err := s.handleBulkExport(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/export")
+		}
+		return err
+	})
+
 	// Register HTTP route for receiving the form data and creating new transcription job.
 	s.Router.Post("/api/transcriptions", func(c *fiber.Ctx) error {
 		log.Debug().Msg("POST /api/transcriptions")
@@ -127,6 +323,71 @@ func (s *Server) RegisterRoutes() {
 		return err
 	})
 
+	s.Router.Get("/api/duplicates/:hash", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/duplicates/%v", c.Params("hash"))
+		err := s.handleGetDuplicates(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/duplicates/:hash")
+		}
+		return err
+	})
+
+	// Register HTTP routes for resumable uploads with the tus protocol.
+	s.Router.Options("/api/uploads", func(c *fiber.Ctx) error {
+		log.Debug().Msg("OPTIONS /api/uploads")
+		err := s.handleOptionsUpload(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling OPTIONS /api/uploads")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/uploads", func(c *fiber.Ctx) error {
+		log.Debug().Msg("POST /api/uploads")
+		err := s.handlePostUpload(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/uploads")
+		}
+		return err
+	})
+
+	s.Router.Head("/api/uploads/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("HEAD /api/uploads/%v", c.Params("id"))
+		err := s.handleHeadUpload(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling HEAD /api/uploads/:id")
+		}
+		return err
+	})
+
+	s.Router.Patch("/api/uploads/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("PATCH /api/uploads/%v", c.Params("id"))
+		err := s.handlePatchUpload(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling PATCH /api/uploads/:id")
+		}
+		return err
+	})
+
+	s.Router.Delete("/api/uploads/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("DELETE /api/uploads/%v", c.Params("id"))
+		err := s.handleDeleteUpload(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling DELETE /api/uploads/:id")
+		}
+		return err
+	})
+
+	// Register HTTP route for importing existing subtitles as a transcription.
+	s.Router.Post("/api/transcriptions/import", func(c *fiber.Ctx) error {
+		log.Debug().Msg("POST /api/transcriptions/import")
+		err := s.handleImportTranscription(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/import")
+		}
+		return err
+	})
+
 	s.Router.Patch("/api/transcriptions", func(c *fiber.Ctx) error {
 		//log.Debug().Msgf("PATCH /api/transcriptions/%v", c.Params("id"))
 		err := s.handlePatchTranscription(c)
@@ -136,6 +397,159 @@ func (s *Server) RegisterRoutes() {
 		return err
 	})
 
+	s.Router.Get("/api/transcriptions/:id/clip", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/clip", c.Params("id"))
+		err := s.handleGetClip(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/clip")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/dataset", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/dataset")
+		err := s.handleDataset(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/dataset")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/transcriptions/:id/waveform", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/waveform", c.Params("id"))
+		err := s.handleGetWaveform(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/waveform")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/renders", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/renders", c.Params("id"))
+		err := s.handlePostRender(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/renders")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/transcriptions/:id/renders", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/renders", c.Params("id"))
+		err := s.handleGetRenders(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/renders")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/renders/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/renders/%v", c.Params("id"))
+		err := s.handleGetRender(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/renders/:id")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/renders/:id/download", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/renders/%v/download", c.Params("id"))
+		err := s.handleDownloadRender(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/renders/:id/download")
+		}
+		return err
+	})
+
+	s.Router.Delete("/api/renders/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("DELETE /api/renders/%v", c.Params("id"))
+		err := s.handleDeleteRender(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling DELETE /api/renders/:id")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/redact", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/redact", c.Params("id"))
+		err := s.handleRedact(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/redact")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/review", func(c *fiber.Ctx) error {
+		log.Debug().Msg("GET /api/review")
+		err := s.handleGetReviewQueue(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/review")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/transcriptions/:id/review", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/transcriptions/%v/review", c.Params("id"))
+		err := s.handleGetReview(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/transcriptions/:id/review")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/transcriptions/:id/review/verify", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("POST /api/transcriptions/%v/review/verify", c.Params("id"))
+		err := s.handleVerify(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/transcriptions/:id/review/verify")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/vocabularies", func(c *fiber.Ctx) error {
+		log.Debug().Msg("GET /api/vocabularies")
+		err := s.handleGetVocabularies(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/vocabularies")
+		}
+		return err
+	})
+
+	s.Router.Post("/api/vocabularies", func(c *fiber.Ctx) error {
+		log.Debug().Msg("POST /api/vocabularies")
+		err := s.handlePostVocabulary(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling POST /api/vocabularies")
+		}
+		return err
+	})
+
+	s.Router.Get("/api/vocabularies/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("GET /api/vocabularies/%v", c.Params("id"))
+		err := s.handleGetVocabulary(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling GET /api/vocabularies/:id")
+		}
+		return err
+	})
+
+	s.Router.Patch("/api/vocabularies/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("PATCH /api/vocabularies/%v", c.Params("id"))
+		err := s.handlePatchVocabulary(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling PATCH /api/vocabularies/:id")
+		}
+		return err
+	})
+
+	s.Router.Delete("/api/vocabularies/:id", func(c *fiber.Ctx) error {
+		log.Debug().Msgf("DELETE /api/vocabularies/%v", c.Params("id"))
+		err := s.handleDeleteVocabulary(c)
+		if err != nil {
+			log.Error().Err(err).Msg("Error handling DELETE /api/vocabularies/:id")
+		}
+		return err
+	})
+
 	// Register HTTP route for receiving the form data and creating new transcription job.
 	s.Router.Delete("/api/transcriptions/:id", func(c *fiber.Ctx) error {
 		log.Debug().Msgf("DELETE /api/transcriptions/%v", c.Params("id"))
@@ -148,7 +562,7 @@ func (s *Server) RegisterRoutes() {
 }
 
 // Helper function to remove a WebSocket connection from the slice
-func removeWsClient(s []*websocket.Conn, r *websocket.Conn) []*websocket.Conn {
+func removeWsClient(s []*wsClient, r *wsClient) []*wsClient {
 	for i, v := range s {
 		if v == r {
 			return append(s[:i], s[i+1:]...)
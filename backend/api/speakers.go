@@ -0,0 +1,116 @@
+package api
+
+import (
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+func (s *Server) handleGetSpeakers(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	speakers := t.Speakers
+	if speakers == nil {
+		speakers = []models.Speaker{}
+	}
+	return c.JSON(speakers)
+}
+
+// This function changes the display name and/or colour of a speaker.
+func (s *Server) handlePatchSpeaker(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	sp := t.GetSpeaker(c.Params("speaker"))
+	if sp == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Speaker not found")
+	}
+
+	var req struct {
+		Name  *string `json:"name"`
+		Color *string `json:"color"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if req.Name != nil {
+		sp.Name = *req.Name
+	}
+	if req.Color != nil {
+		sp.Color = *req.Color
+	}
+	// Only the speaker table changes, which is not part of the revision history
+	return s.saveTranscription(c, nil, t, "")
+}
+
+// This function merges several speakers into one, relabelling all their segments.
+func (s *Server) handleMergeSpeakers(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	var req struct {
+		Speakers []string `json:"speakers"`
+		Into     string   `json:"into"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if t.GetSpeaker(req.Into) == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Speaker not found")
+	}
+	for _, id := range req.Speakers {
+		if t.GetSpeaker(id) == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Speaker not found")
+		}
+	}
+
+	before := t.Copy()
+	t.MergeSpeakers(req.Speakers, req.Into)
+	return s.saveTranscription(c, before, t, "merge speakers")
+}
+
+// This function assigns a speaker to some segments. If the speaker does not exist
+// yet, it is added to the speaker table.
+func (s *Server) handleAssignSpeaker(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	var req struct {
+		Segments []string `json:"segments"`
+		Speaker  string   `json:"speaker"`
+		// Name of the speaker, if it is a new one
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	if req.Speaker == "" || len(req.Segments) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "A speaker and some segments are required")
+	}
+
+	before := t.Copy()
+	if t.AssignSpeaker(req.Segments, req.Speaker) == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "Segment not found")
+	}
+	t.SyncSpeakers()
+	if sp := t.GetSpeaker(req.Speaker); sp != nil && req.Name != "" {
+		sp.Name = req.Name
+	}
+	return s.saveTranscription(c, before, t, "assign speaker")
+}
@@ -0,0 +1,535 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/storage"
+)
+
+// Version and extensions of the tus resumable upload protocol that are supported.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+)
+
+// defaultUploadExpiration is the time after which uploads that received no data
+// are deleted, unless UPLOAD_EXPIRATION_HOURS is set.
+const defaultUploadExpiration = 24 * time.Hour
+
+// TusHeaders are the headers of the tus protocol that browsers must be allowed to
+// read.
+const TusHeaders = "Location,Tus-Resumable,Tus-Version,Tus-Extension,Upload-Offset,Upload-Length,Upload-Expires,Transcription-Id"
+
+var tusIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// tusUpload is the state of a resumable upload. It is stored as JSON next to the
+// received data, in the `tus` folder of the uploads directory, so that uploads
+// survive restarts.
+type tusUpload struct {
+	ID        string            `json:"id"`
+	Length    int64             `json:"length"`
+	Metadata  map[string]string `json:"metadata"`
+	CreatedAt time.Time         `json:"createdAt"`
+	// ExpiresAt is when the upload is deleted if it receives no more data.
+	// Complete uploads are kept until then too, so that a client that missed
+	// the response can still get the id of the transcription.
+	ExpiresAt time.Time `json:"expiresAt"`
+	// FileName is the name the file is stored with in the storage, once it
+	// is complete.
+	FileName string `json:"fileName,omitempty"`
+	// TranscriptionID is set once the transcription of the file is created.
+	TranscriptionID string `json:"transcriptionId,omitempty"`
+}
+
+// uploadExpiration returns how long uploads are kept without receiving data.
+func uploadExpiration() time.Duration {
+	if v := os.Getenv("UPLOAD_EXPIRATION_HOURS"); v != "" {
+		hours, err := strconv.Atoi(v)
+		if err == nil && hours > 0 {
+			return time.Duration(hours) * time.Hour
+		}
+		log.Warn().Msgf("Invalid UPLOAD_EXPIRATION_HOURS %v, using %v", v, defaultUploadExpiration)
+	}
+	return defaultUploadExpiration
+}
+
+func tusDir() string {
+	return filepath.Join(os.Getenv("UPLOAD_DIR"), "tus")
+}
+
+func (u *tusUpload) dataPath() string {
+	return filepath.Join(tusDir(), u.ID)
+}
+
+func (u *tusUpload) infoPath() string {
+	return filepath.Join(tusDir(), u.ID+".info")
+}
+
+// expires returns when the upload expires. Uploads saved before expiration
+// was supported expire after their creation.
+func (u *tusUpload) expires() time.Time {
+	if u.ExpiresAt.IsZero() {
+		return u.CreatedAt.Add(uploadExpiration())
+	}
+	return u.ExpiresAt
+}
+
+func (u *tusUpload) expired() bool {
+	return time.Now().After(u.expires())
+}
+
+// setExpires sets the Upload-Expires header of the response.
+func (u *tusUpload) setExpires(c *fiber.Ctx) {
+	c.Set("Upload-Expires", u.expires().UTC().Format(http.TimeFormat))
+}
+
+// offset returns the number of bytes received.
+func (u *tusUpload) offset(store storage.Storage) (int64, error) {
+	if u.TranscriptionID != "" {
+		return u.Length, nil
+	}
+	stat, err := os.Stat(u.dataPath())
+	if os.IsNotExist(err) && u.FileName != "" {
+		// The file was already stored, but the transcription was not created
+		info, err := store.Stat(context.Background(), u.FileName)
+		return info.Size, err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// save stores the state of the upload, replacing it atomically.
+func (u *tusUpload) save() error {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := u.infoPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, u.infoPath())
+}
+
+// remove deletes the state of the upload, and the data received if the file
+// was not stored yet.
+func (u *tusUpload) remove() {
+	for _, path := range []string{u.dataPath(), u.infoPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Msgf("Error deleting file %v", path)
+		}
+	}
+}
+
+// value returns a field of the metadata, used as the form of the transcription.
+func (u *tusUpload) value(key string) string {
+	return u.Metadata[key]
+}
+
+func loadTusUpload(id string) *tusUpload {
+	if !tusIDPattern.MatchString(id) {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(tusDir(), id+".info"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Msgf("Error reading upload %v", id)
+		}
+		return nil
+	}
+	var u tusUpload
+	if err := json.Unmarshal(data, &u); err != nil {
+		log.Error().Err(err).Msgf("Error decoding upload %v", id)
+		return nil
+	}
+	return &u
+}
+
+// parseTusMetadata decodes the Upload-Metadata header: comma separated pairs of
+// a key and a base64 encoded value.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %v", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// checkTusVersion sets the Tus-Resumable header of the response, and rejects the
+// requests made with another version of the protocol.
+func checkTusVersion(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return fiber.NewError(fiber.StatusPreconditionFailed, "Unsupported tus version")
+	}
+	return nil
+}
+
+// lockUpload marks an upload as being written, and tells if it was not already.
+func (s *Server) lockUpload(id string) bool {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	if s.uploading[id] {
+		return false
+	}
+	s.uploading[id] = true
+	return true
+}
+
+func (s *Server) unlockUpload(id string) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	delete(s.uploading, id)
+}
+
+// This function describes the tus protocol supported by the server.
+func (s *Server) handleOptionsUpload(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// This function creates a resumable upload. The transcription settings are given
+// in the Upload-Metadata header, with the same names as the fields of the form
+// of the transcriptions, and the name of the file in `filename`. They are checked
+// before the upload starts.
+func (s *Server) handlePostUpload(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+	if c.Get("Upload-Defer-Length") != "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Deferred lengths are not supported")
+	}
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid Upload-Length")
+	}
+	metadata, err := parseTusMetadata(c.Get("Upload-Metadata"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid Upload-Metadata: "+err.Error())
+	}
+
+	u := tusUpload{
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: time.Now(),
+	}
+	u.ExpiresAt = u.CreatedAt.Add(uploadExpiration())
+	var t models.Transcription
+	if err := s.parseTranscriptionForm(&t, u.value); err != nil {
+		return err
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	u.ID = hex.EncodeToString(b)
+	if err := os.MkdirAll(tusDir(), 0755); err != nil {
+		log.Error().Err(err).Msg("Error creating the uploads folder")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if err := os.WriteFile(u.dataPath(), nil, 0644); err != nil {
+		log.Error().Err(err).Msgf("Error creating upload %v", u.ID)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if err := u.save(); err != nil {
+		log.Error().Err(err).Msgf("Error saving upload %v", u.ID)
+		u.remove()
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	c.Location("/api/uploads/" + u.ID)
+	u.setExpires(c)
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// This function returns the offset of an upload, to resume it.
+func (s *Server) handleHeadUpload(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+	u := loadTusUpload(c.Params("id"))
+	if u == nil || u.expired() {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	offset, err := u.offset(s.Storage)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading upload %v", u.ID)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	// The server may have stopped after the last chunk was received, but before
+	// the transcription was created. If a request is writing the upload, it
+	// creates the transcription itself.
+	if offset == u.Length && s.lockUpload(u.ID) {
+		defer s.unlockUpload(u.ID)
+		// Reload the upload, which may have been completed since it was read
+		if u = loadTusUpload(u.ID); u == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Not found")
+		}
+		if err := s.completeUpload(c, u); err != nil {
+			return err
+		}
+	}
+	c.Set("Cache-Control", "no-store")
+	c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	u.setExpires(c)
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// This function appends a chunk to an upload, at the offset given by the client.
+// The body is written to the disk as it is received, so an interrupted request
+// keeps the bytes received until then. Once the upload is complete, the
+// transcription is created, and its id is returned in the Transcription-Id header.
+func (s *Server) handlePatchUpload(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "The content type must be application/offset+octet-stream")
+	}
+	if !s.lockUpload(c.Params("id")) {
+		return fiber.NewError(fiber.StatusLocked, "The upload is being written")
+	}
+	defer s.unlockUpload(c.Params("id"))
+	u := loadTusUpload(c.Params("id"))
+	if u == nil || u.expired() {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	start, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || start < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid Upload-Offset")
+	}
+	offset, err := u.offset(s.Storage)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading upload %v", u.ID)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if start != offset {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("The upload is at offset %d", offset))
+	}
+
+	if offset < u.Length {
+		f, err := os.OpenFile(u.dataPath(), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error opening upload %v", u.ID)
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+		}
+		body := c.Context().RequestBodyStream()
+		if body == nil {
+			body = bytes.NewReader(c.Body())
+		}
+		n, err := io.Copy(f, io.LimitReader(body, u.Length-offset))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		offset += n
+		if n > 0 {
+			// The upload is active: it expires later
+			u.ExpiresAt = time.Now().Add(uploadExpiration())
+			if err := u.save(); err != nil {
+				log.Error().Err(err).Msgf("Error saving upload %v", u.ID)
+			}
+		}
+		u.setExpires(c)
+		if err != nil {
+			c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			log.Warn().Err(err).Msgf("Upload %v interrupted at offset %d", u.ID, offset)
+			return fiber.NewError(fiber.StatusBadRequest, "Error receiving the upload")
+		}
+	}
+
+	c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	u.setExpires(c)
+	if offset == u.Length {
+		if err := s.completeUpload(c, u); err != nil {
+			return err
+		}
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// completeUpload stores a complete upload in the storage, and creates
+// its transcription. It does nothing if the transcription was already created.
+func (s *Server) completeUpload(c *fiber.Ctx, u *tusUpload) error {
+	if u.TranscriptionID != "" {
+		c.Set("Transcription-Id", u.TranscriptionID)
+		return nil
+	}
+
+	// The name is saved first, so that the file is found again if the server
+	// stops after moving it
+	if u.FileName == "" {
+		name := filepath.Base(u.Metadata["filename"])
+		if name == "." || name == string(filepath.Separator) {
+			name = ""
+		}
+		u.FileName = UploadFileName(name)
+		if err := u.save(); err != nil {
+			log.Error().Err(err).Msgf("Error saving upload %v", u.ID)
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+		}
+	}
+	transcription := models.Transcription{
+		FileName: u.FileName,
+		Status:   models.TranscriptionStatusPending,
+	}
+	var err error
+	if _, serr := os.Stat(u.dataPath()); serr == nil {
+		// The file is probed before it is stored, so that rejected files are
+		// never stored
+		transcription.Media, err = probeUpload(u.dataPath(), u.FileName)
+		if err != nil {
+			u.remove()
+			return err
+		}
+		if err := s.Storage.SaveFile(context.Background(), u.FileName, u.dataPath()); err != nil {
+			log.Error().Err(err).Msgf("Error moving upload %v", u.ID)
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+		}
+	} else {
+		// The file was stored before the server stopped. The local copy is
+		// fetched without the timeout of the probe, as it may be downloaded from
+		// a remote storage, and the upload is kept if it fails, to be completed
+		// again.
+		path, release, err := s.Storage.Local(context.Background(), u.FileName)
+		if err != nil {
+			log.Error().Err(err).Msgf("Error reading upload %v", u.ID)
+			return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+		}
+		transcription.Media, err = probeUpload(path, u.FileName)
+		release()
+		if err != nil {
+			s.deleteUpload(u.FileName)
+			u.remove()
+			return err
+		}
+	}
+	if err := s.parseTranscriptionForm(&transcription, u.value); err != nil {
+		s.deleteUpload(u.FileName)
+		u.remove()
+		return err
+	}
+	// If the transcription cannot be created, the stored file and the upload
+	// are kept, so that it is created when the client resumes the upload
+	res, err := s.CreateTranscription(&transcription)
+	if err != nil {
+		if transcription.FileName != u.FileName {
+			// The file was a duplicate, and its copy is already deleted
+			u.remove()
+		}
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	u.TranscriptionID = res.ID.Hex()
+	if err := u.save(); err != nil {
+		log.Error().Err(err).Msgf("Error saving upload %v", u.ID)
+	}
+	c.Set("Transcription-Id", u.TranscriptionID)
+	log.Info().Msgf("Upload %v complete, created transcription %v", u.ID, u.TranscriptionID)
+	return nil
+}
+
+// This function cancels an upload, and deletes the data received. The file of a
+// complete upload belongs to its transcription, and is kept.
+func (s *Server) handleDeleteUpload(c *fiber.Ctx) error {
+	if err := checkTusVersion(c); err != nil {
+		return err
+	}
+	if !s.lockUpload(c.Params("id")) {
+		return fiber.NewError(fiber.StatusLocked, "The upload is being written")
+	}
+	defer s.unlockUpload(c.Params("id"))
+	u := loadTusUpload(c.Params("id"))
+	if u == nil {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+
+	if u.FileName != "" && u.TranscriptionID == "" {
+		s.Storage.Delete(context.Background(), u.FileName)
+	}
+	u.remove()
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// StartUploadSweeper deletes the expired uploads, with the data they received,
+// every hour.
+func (s *Server) StartUploadSweeper() {
+	for {
+		s.sweepUploads()
+		time.Sleep(time.Hour)
+	}
+}
+
+// sweepUploads deletes the expired uploads. The file of an upload that was
+// stored but has no transcription is deleted too. Data files left without
+// their state are deleted once they are as old as an expired upload.
+func (s *Server) sweepUploads() {
+	entries, err := os.ReadDir(tusDir())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error().Err(err).Msg("Error listing the uploads")
+		}
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".info.tmp") {
+			// A state that was being saved when the server stopped
+			if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > uploadExpiration() {
+				os.Remove(filepath.Join(tusDir(), e.Name()))
+			}
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), ".info")
+		if !tusIDPattern.MatchString(id) || !s.lockUpload(id) {
+			continue
+		}
+		if id == e.Name() {
+			// A data file, deleted with its state, or on its own if the state is lost
+			if _, err := os.Stat(filepath.Join(tusDir(), id+".info")); os.IsNotExist(err) {
+				if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > uploadExpiration() {
+					log.Info().Msgf("Deleting upload data %v without its state", id)
+					os.Remove(filepath.Join(tusDir(), id))
+				}
+			}
+		} else if u := loadTusUpload(id); u != nil && u.expired() {
+			log.Info().Msgf("Deleting expired upload %v", id)
+			if u.FileName != "" && u.TranscriptionID == "" {
+				s.deleteUpload(u.FileName)
+			}
+			u.remove()
+		}
+		s.unlockUpload(id)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/pluja/whishper/storage"
+)
+
+func TestSweepUploads(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("UPLOAD_DIR", dir)
+	if err := os.MkdirAll(tusDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := storage.NewDisk(dir)
+	s := &Server{Storage: store, uploading: map[string]bool{}}
+
+	newUpload := func(id string, expires time.Time, fileName, transcription string) *tusUpload {
+		u := &tusUpload{ID: id, Length: 10, CreatedAt: expires.Add(-time.Hour), ExpiresAt: expires,
+			FileName: fileName, TranscriptionID: transcription}
+		if err := u.save(); err != nil {
+			t.Fatal(err)
+		}
+		if fileName == "" {
+			if err := os.WriteFile(u.dataPath(), []byte("abc"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return u
+	}
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	active := newUpload(strings.Repeat("a", 32), future, "", "")
+	partial := newUpload(strings.Repeat("b", 32), past, "", "")
+	done := newUpload(strings.Repeat("c", 32), past, "done.mp4", "t1")
+	stored := newUpload(strings.Repeat("d", 32), past, "stored.mp4", "")
+	writing := newUpload(strings.Repeat("e", 32), past, "", "")
+	for _, name := range []string{"done.mp4", "stored.mp4"} {
+		if err := store.Save(context.Background(), name, strings.NewReader("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphan := filepath.Join(tusDir(), strings.Repeat("f", 32))
+	if err := os.WriteFile(orphan, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * uploadExpiration())
+	os.Chtimes(orphan, old, old)
+
+	s.lockUpload(writing.ID)
+	s.sweepUploads()
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	if !exists(active.infoPath()) || !exists(active.dataPath()) {
+		t.Error("an active upload was deleted")
+	}
+	if exists(partial.infoPath()) || exists(partial.dataPath()) {
+		t.Error("an expired upload was kept")
+	}
+	if exists(done.infoPath()) || !exists(filepath.Join(dir, "done.mp4")) {
+		t.Error("the state of a complete upload was kept, or its file was deleted")
+	}
+	if exists(stored.infoPath()) || exists(filepath.Join(dir, "stored.mp4")) {
+		t.Error("a stored upload without transcription was kept")
+	}
+	if !exists(writing.infoPath()) {
+		t.Error("an upload being written was deleted")
+	}
+	if exists(orphan) {
+		t.Error("data without state was kept")
+	}
+}
+
+func TestUploadExpiration(t *testing.T) {
+	t.Setenv("UPLOAD_EXPIRATION_HOURS", "")
+	if got := uploadExpiration(); got != defaultUploadExpiration {
+		t.Errorf("default expiration %v", got)
+	}
+	t.Setenv("UPLOAD_EXPIRATION_HOURS", "2")
+	if got := uploadExpiration(); got != 2*time.Hour {
+		t.Errorf("expiration %v", got)
+	}
+	u := &tusUpload{CreatedAt: time.Now().Add(-3 * time.Hour)}
+	if !u.expired() {
+		t.Error("an upload saved without expiration does not expire")
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// This function streams a stored file, such as the media of a transcription, its
+// redacted copy or a render. Range requests are supported, so that players can
+// seek without downloading the whole file.
+func (s *Server) handleGetVideo(c *fiber.Ctx) error {
+	// The name is not decoded by the router
+	name, err := url.PathUnescape(c.Params("*"))
+	if err != nil || name == "" {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return s.sendFile(c, name, "")
+}
+
+// sendFile streams a stored file, or the range of it asked with the Range header.
+// If attachment is not empty, the file is downloaded with that name.
+func (s *Server) sendFile(c *fiber.Ctx, name, attachment string) error {
+	ctx := context.Background()
+	info, err := s.Storage.Stat(ctx, name)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading file %v", name)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	if !info.ModTime.IsZero() {
+		c.Set(fiber.HeaderLastModified, info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if attachment != "" {
+		c.Attachment(attachment)
+	}
+
+	start, length := int64(0), info.Size
+	// Other units and multiple ranges are not supported, and the whole file is sent
+	if header := c.Get(fiber.HeaderRange); strings.HasPrefix(header, "bytes=") && !strings.Contains(header, ",") {
+		var ok bool
+		start, length, ok = parseRange(header, info.Size)
+		if !ok {
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+			return fiber.NewError(fiber.StatusRequestedRangeNotSatisfiable, "Invalid range")
+		}
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Size))
+		c.Status(fiber.StatusPartialContent)
+	}
+	if c.Method() == fiber.MethodHead {
+		c.Response().Header.SetContentLength(int(length))
+		return nil
+	}
+
+	r, err := s.Storage.Open(ctx, name, start, length)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading file %v", name)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	// The reader is closed once it is sent
+	return c.SendStream(r, int(length))
+}
+
+// parseRange parses a Range header with a single range of bytes, and returns its
+// start and length within a file of the given size. Ranges past the end of the
+// file are shortened.
+func parseRange(header string, size int64) (int64, int64, bool) {
+	spec := strings.TrimSpace(strings.TrimPrefix(header, "bytes="))
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if first == "" {
+		// The last bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
@@ -0,0 +1,46 @@
+package api
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header        string
+		size          int64
+		start, length int64
+		ok            bool
+	}{
+		{"bytes=0-99", 1000, 0, 100, true},
+		{"bytes=100-199", 1000, 100, 100, true},
+		{"bytes=500-", 1000, 500, 500, true},
+		{"bytes=0-", 1000, 0, 1000, true},
+		{"bytes=999-999", 1000, 999, 1, true},
+		{"bytes= 10-19 ", 1000, 10, 10, true},
+		// Ranges past the end are shortened
+		{"bytes=900-2000", 1000, 900, 100, true},
+		// Suffix ranges
+		{"bytes=-100", 1000, 900, 100, true},
+		{"bytes=-2000", 1000, 0, 1000, true},
+		{"bytes=-1", 1, 0, 1, true},
+		// Unsatisfiable ranges
+		{"bytes=1000-", 1000, 0, 0, false},
+		{"bytes=1000-1100", 1000, 0, 0, false},
+		{"bytes=-0", 1000, 0, 0, false},
+		{"bytes=-10", 0, 0, 0, false},
+		{"bytes=0-", 0, 0, 0, false},
+		// Invalid ranges
+		{"bytes=20-10", 1000, 0, 0, false},
+		{"bytes=-", 1000, 0, 0, false},
+		{"bytes=10", 1000, 0, 0, false},
+		{"bytes=a-b", 1000, 0, 0, false},
+		{"bytes=-5-10", 1000, 0, 0, false},
+		{"bytes=5--10", 1000, 0, 0, false},
+		{"bytes=", 1000, 0, 0, false},
+	}
+	for _, tt := range tests {
+		start, length, ok := parseRange(tt.header, tt.size)
+		if ok != tt.ok || (ok && (start != tt.start || length != tt.length)) {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v",
+				tt.header, tt.size, start, length, ok, tt.start, tt.length, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+func (s *Server) handleGetVocabularies(c *fiber.Ctx) error {
+	vocabularies := s.Db.GetVocabularies()
+	if vocabularies == nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(vocabularies)
+}
+
+func (s *Server) handleGetVocabulary(c *fiber.Ctx) error {
+	v := s.Db.GetVocabulary(c.Params("id"))
+	if v == nil {
+		log.Warn().Msgf("Vocabulary with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	return c.JSON(v)
+}
+
+// parseVocabulary reads a vocabulary from a JSON body with a `name` and a list
+// of `words`.
+func parseVocabulary(c *fiber.Ctx) (*models.Vocabulary, error) {
+	var v models.Vocabulary
+	if err := json.Unmarshal(c.Body(), &v); err != nil {
+		log.Error().Err(err).Msg("Error parsing JSON body")
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Bad request")
+	}
+	v.Name = strings.TrimSpace(v.Name)
+	if v.Name == "" {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "The vocabulary name is required")
+	}
+	// Words are sent to the ASR as a comma separated list
+	v.Words = models.ParseHotwords(strings.Join(v.Words, "\n"))
+	v.UpdatedAt = time.Now()
+	return &v, nil
+}
+
+func (s *Server) handlePostVocabulary(c *fiber.Ctx) error {
+	v, err := parseVocabulary(c)
+	if err != nil {
+		return err
+	}
+	res, err := s.Db.NewVocabulary(v)
+	if err != nil {
+		log.Error().Err(err).Msg("Error saving vocabulary to database")
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.Status(fiber.StatusCreated).JSON(res)
+}
+
+func (s *Server) handlePatchVocabulary(c *fiber.Ctx) error {
+	current := s.Db.GetVocabulary(c.Params("id"))
+	if current == nil {
+		log.Warn().Msgf("Vocabulary with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	v, err := parseVocabulary(c)
+	if err != nil {
+		return err
+	}
+	v.ID = current.ID
+	res, err := s.Db.UpdateVocabulary(v)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error updating vocabulary %v", c.Params("id"))
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(res)
+}
+
+func (s *Server) handleDeleteVocabulary(c *fiber.Ctx) error {
+	if s.Db.GetVocabulary(c.Params("id")) == nil {
+		log.Warn().Msgf("Vocabulary with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if err := s.Db.DeleteVocabulary(c.Params("id")); err != nil {
+		log.Error().Err(err).Msgf("Error deleting vocabulary %v", c.Params("id"))
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	c.Status(fiber.StatusOK)
+	return nil
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/waveform"
+)
+
+// waveformRetryDelay is how long a failed waveform generation is reported as
+// failed before it is tried again.
+const waveformRetryDelay = 10 * time.Minute
+
+var (
+	waveformsMu sync.Mutex
+	// Media files whose waveform is being generated
+	waveforms = make(map[string]bool)
+	// Media files whose waveform could not be generated, with the time of the failure
+	waveformFailures = make(map[string]time.Time)
+)
+
+// waveformFailed tells whether the last generation of the waveform of a media
+// file failed recently.
+func waveformFailed(fileName string) bool {
+	waveformsMu.Lock()
+	defer waveformsMu.Unlock()
+	failed, ok := waveformFailures[fileName]
+	return ok && time.Since(failed) < waveformRetryDelay
+}
+
+// GenerateWaveform computes the waveform peaks of a stored media file in the
+// background, and stores them next to it. Nothing is done if they are already
+// being generated.
+func (s *Server) GenerateWaveform(fileName string) {
+	waveformsMu.Lock()
+	if waveforms[fileName] {
+		waveformsMu.Unlock()
+		return
+	}
+	waveforms[fileName] = true
+	waveformsMu.Unlock()
+
+	go func() {
+		defer func() {
+			waveformsMu.Lock()
+			delete(waveforms, fileName)
+			waveformsMu.Unlock()
+		}()
+		err := s.generateWaveform(fileName)
+		waveformsMu.Lock()
+		if err != nil {
+			waveformFailures[fileName] = time.Now()
+		} else {
+			delete(waveformFailures, fileName)
+		}
+		waveformsMu.Unlock()
+		if err != nil {
+			log.Error().Err(err).Msgf("Error generating the waveform of %v", fileName)
+			return
+		}
+		log.Debug().Msgf("Generated the waveform of %v", fileName)
+	}()
+}
+
+func (s *Server) generateWaveform(fileName string) error {
+	ctx := context.Background()
+	src, release, err := s.Storage.Local(ctx, fileName)
+	if err != nil {
+		return err
+	}
+	defer release()
+	files, err := waveform.Generate(ctx, src)
+	if err != nil {
+		return err
+	}
+	for level, data := range files {
+		if err := s.Storage.Save(ctx, waveform.Path(fileName, level), bytes.NewReader(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// This function serves the waveform peaks of the media of a transcription, at the
+// zoom level given in samples per pixel with `zoom` (the finest by default), as
+// audiowaveform JSON or, with `format=dat`, in its binary format. If the peaks do
+// not exist yet, they are generated and 202 Accepted is returned. If the generation
+// failed, 422 Unprocessable Entity is returned for a while before it is tried again.
+func (s *Server) handleGetWaveform(c *fiber.Ctx) error {
+	t := s.Db.GetTranscription(c.Params("id"))
+	if t == nil {
+		log.Warn().Msgf("Transcription with id %v not found", c.Params("id"))
+		return fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if !t.HasMedia() {
+		return fiber.NewError(fiber.StatusNotFound, "The transcription has no media")
+	}
+
+	level := waveform.Levels[0]
+	if zoom := c.Query("zoom"); zoom != "" {
+		level = 0
+		for _, l := range waveform.Levels {
+			if zoom == strconv.Itoa(l) {
+				level = l
+			}
+		}
+		if level == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, fmt.Sprintf("The zoom must be one of %v", waveform.Levels))
+		}
+	}
+	format := c.Query("format", "json")
+	if format != "json" && format != "dat" {
+		return fiber.NewError(fiber.StatusBadRequest, "The format must be json or dat")
+	}
+
+	ctx := context.Background()
+	name := waveform.Path(t.FileName, level)
+	r, err := s.Storage.Open(ctx, name, 0, -1)
+	if errors.Is(err, fs.ErrNotExist) {
+		if _, err := s.Storage.Stat(ctx, t.FileName); err != nil {
+			return fiber.NewError(fiber.StatusNotFound, "The media file is missing")
+		}
+		if waveformFailed(t.FileName) {
+			return fiber.NewError(fiber.StatusUnprocessableEntity, "The waveform of the media could not be generated")
+		}
+		s.GenerateWaveform(t.FileName)
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "The waveform is being generated"})
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading waveform %v", name)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading waveform %v", name)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	if format == "dat" {
+		c.Set(fiber.HeaderContentType, "application/octet-stream")
+		return c.Send(data)
+	}
+	w, err := waveform.Decode(data)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading waveform %v", name)
+		return fiber.NewError(fiber.StatusInternalServerError, "Internal server error")
+	}
+	return c.JSON(w)
+}
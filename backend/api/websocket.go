@@ -1,15 +1,43 @@
 package api
 
 import (
+	"errors"
+	"sync"
+
 	"github.com/goccy/go-json"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"codeberg.org/pluja/whishper/database"
 	"codeberg.org/pluja/whishper/models"
 )
 
-func (s *Server) handleWebsocketMessage(wsess *websocket.Conn, msg []byte) {
+const MessageTypeConflict = "conflict"
+
+// wsClient is a client connected to the websocket of all transcriptions.
+type wsClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+}
+
+// send writes a message to the client. Writes to a connection must not run
+// concurrently.
+func (c *wsClient) send(msg []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// ConflictMessage is sent to a ws client whose update was made on an outdated
+// version of a transcription. It holds the current transcription.
+type ConflictMessage struct {
+	Type          string                `json:"type"`
+	Transcription *models.Transcription `json:"transcription"`
+}
+
+func (s *Server) handleWebsocketMessage(client *wsClient, msg []byte) {
+	wsess := client.conn
 	log.Info().Msgf("Received message from client: %v", wsess.RemoteAddr().String())
 	// Try to unmarshal message to transcription
 	var transcription models.Transcription
@@ -24,13 +52,53 @@ func (s *Server) handleWebsocketMessage(wsess *websocket.Conn, msg []byte) {
 	// If it has ID, it means it's an update
 	if transcription.ID != primitive.NilObjectID {
 		log.Printf("Updating transcription: %v", transcription.ID)
+		var version struct {
+			Version *int64 `json:"version"`
+		}
+		if json.Unmarshal(msg, &version); version.Version == nil {
+			log.Error().Msgf("Transcription not updated, it does not have a version")
+			return
+		}
+		before := s.Db.GetTranscription(transcription.ID.Hex())
+		if before != nil && before.Version == transcription.Version {
+			if l := s.heldLock(before.ID.Hex(), wsess.Query("collaborator"), changedSegments(before, &transcription)); l != nil {
+				log.Debug().Msgf("Transcription %v not updated, segment %v is locked", transcription.ID, l.Segment)
+				data, err := json.Marshal(&CollabMessage{Type: MessageTypeRejected, Segment: l.Segment,
+					Translation: l.Translation, Message: "The segment is locked by " + l.User})
+				if err == nil {
+					err = client.send(data)
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("Error sending rejected message")
+				}
+				return
+			}
+		}
 		// Update transcription in database
 		res, err = s.Db.UpdateTranscription(&transcription)
+		if errors.Is(err, database.ErrVersionConflict) {
+			// Send the current transcription back to the client
+			log.Debug().Msgf("Version conflict updating transcription %v", transcription.ID)
+			current := s.Db.GetTranscription(transcription.ID.Hex())
+			if current != nil {
+				data, err := json.Marshal(&ConflictMessage{Type: MessageTypeConflict, Transcription: current})
+				if err == nil {
+					err = client.send(data)
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("Error sending conflict message")
+				}
+			}
+			return
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("Error updating transcription in database:")
 			return
 		}
 		log.Printf("Updated transcription in database: %v", res)
+		if before != nil {
+			s.RecordRevision(before, res, wsAuthor(wsess), "edit")
+		}
 	} else {
 		log.Error().Msgf("Transcription not updated, it does not have an ID")
 		return
@@ -39,3 +107,12 @@ func (s *Server) handleWebsocketMessage(wsess *websocket.Conn, msg []byte) {
 	// broadcast with gofiber websocket
 	s.BroadcastTranscription(res)
 }
+
+// wsAuthor returns who is connected to the websocket, as given by the `user`
+// query parameter, or the remote address.
+func wsAuthor(c *websocket.Conn) string {
+	if a := c.Query("user"); a != "" {
+		return a
+	}
+	return c.RemoteAddr().String()
+}
@@ -1,14 +1,90 @@
 package database
 
 import (
+	"errors"
+	"time"
+
 	"codeberg.org/pluja/whishper/models"
 )
 
+// ErrVersionConflict is returned by updates made on an outdated version of a transcription.
+var ErrVersionConflict = errors.New("version conflict")
+
 type Db interface {
 	NewTranscription(*models.Transcription) (*models.Transcription, error)
+	// UpdateTranscription replaces a transcription if its version matches the stored
+	// one, and increases the version. Otherwise it returns ErrVersionConflict.
 	UpdateTranscription(*models.Transcription) (*models.Transcription, error)
+	// UpdateResult only replaces the result of a transcription, or of the
+	// translation at the given index if it is not negative. It is version-checked
+	// like UpdateTranscription, and returns the new version.
+	UpdateResult(id string, version int64, translation int, res *models.WhisperResult) (int64, error)
 	DeleteTranscription(string) error
 	GetTranscription(string) *models.Transcription
 	GetAllTranscriptions() []*models.Transcription
 	GetPendingTranscriptions() []*models.Transcription
+	// GetWatchedTranscriptions returns the transcriptions made from watched folders,
+	// without their result and translations.
+	GetWatchedTranscriptions() []*models.Transcription
+	// GetTranscriptionsByHash returns the transcriptions of the media with the
+	// given hash.
+	GetTranscriptionsByHash(string) []*models.Transcription
+	NewWatchRejection(*models.WatchRejection) (*models.WatchRejection, error)
+	GetWatchRejections() []*models.WatchRejection
+
+	NewRevision(*models.Revision) (*models.Revision, error)
+	GetRevision(string) *models.Revision
+	// GetRevisions returns the revisions of a transcription, newest first, without their snapshots.
+	GetRevisions(transcriptionId string) []*models.Revision
+	// PruneRevisions deletes the revisions of a transcription beyond the newest
+	// `keep` ones (if keep > 0), and those older than `before` (if not zero).
+	PruneRevisions(transcriptionId string, keep int, before time.Time) error
+	// GetRevisionHistory returns the revisions a revision is rebuilt from: the
+	// revisions of its transcription from the last full one up to it, oldest first.
+	GetRevisionHistory(transcriptionId, revisionId string) []*models.Revision
+	// SetRevisionSnapshot stores the result and translations in a partial
+	// revision, making it a full one.
+	SetRevisionSnapshot(id string, result *models.WhisperResult, translations []models.Translation) error
+	DeleteRevisions(transcriptionId string) error
+	NewVocabulary(*models.Vocabulary) (*models.Vocabulary, error)
+	UpdateVocabulary(*models.Vocabulary) (*models.Vocabulary, error)
+	DeleteVocabulary(string) error
+	GetVocabulary(string) *models.Vocabulary
+	GetVocabularies() []*models.Vocabulary
+
+	NewRender(*models.Render) (*models.Render, error)
+	UpdateRender(*models.Render) (*models.Render, error)
+	DeleteRender(string) error
+	GetRender(string) *models.Render
+	// GetRenders returns the renders of a transcription, newest first.
+	GetRenders(transcriptionId string) []*models.Render
+	// GetPendingRenders returns the renders that are pending or were left running,
+	// oldest first.
+	GetPendingRenders() []*models.Render
+}
+
+// UpdateWithRetry applies a change to a transcription and stores it. If the stored
+// transcription was changed in the meantime, the change is applied again on top of
+// the latest version. On success, t holds the stored transcription.
+func UpdateWithRetry(db Db, t *models.Transcription, apply func(*models.Transcription)) error {
+	const attempts = 5
+	current := t
+	for i := 0; i < attempts; i++ {
+		apply(current)
+		_, err := db.UpdateTranscription(current)
+		if err == nil {
+			if current != t {
+				*t = *current
+			}
+			return nil
+		}
+		if !errors.Is(err, ErrVersionConflict) {
+			return err
+		}
+		current = db.GetTranscription(t.ID.Hex())
+		if current == nil {
+			return errors.New("transcription not found")
+		}
+	}
+	return ErrVersionConflict
 }
@@ -157,24 +157,568 @@ func (s *MongoDb) GetPendingTranscriptions() []*models.Transcription {
 	return transcriptions
 }
 
+func (s *MongoDb) GetWatchedTranscriptions() []*models.Transcription {
+	collection := s.client.Database("whishper").Collection("transcriptions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{primitive.E{Key: "watch", Value: bson.D{primitive.E{Key: "$exists", Value: true}}}}
+	opts := options.Find().
+		SetProjection(bson.D{primitive.E{Key: "result", Value: 0}, primitive.E{Key: "translations", Value: 0}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error getting transcriptions: %v", err)
+		return nil
+	}
+
+	defer cursor.Close(ctx)
+	transcriptions := []*models.Transcription{}
+	for cursor.Next(ctx) {
+		var result models.Transcription
+		err := cursor.Decode(&result)
+		if err != nil {
+			log.Printf("Error decoding transcription: %v", err)
+			return nil
+		}
+		transcriptions = append(transcriptions, &result)
+	}
+
+	return transcriptions
+}
+
+func (s *MongoDb) GetTranscriptionsByHash(hash string) []*models.Transcription {
+	collection := s.client.Database("whishper").Collection("transcriptions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{primitive.E{Key: "hash", Value: hash}}
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error getting transcriptions: %v", err)
+		return nil
+	}
+
+	defer cursor.Close(ctx)
+	transcriptions := []*models.Transcription{}
+	for cursor.Next(ctx) {
+		var result models.Transcription
+		err := cursor.Decode(&result)
+		if err != nil {
+			log.Printf("Error decoding transcription: %v", err)
+			return nil
+		}
+		transcriptions = append(transcriptions, &result)
+	}
+
+	return transcriptions
+}
+
 func (m *MongoDb) UpdateTranscription(t *models.Transcription) (*models.Transcription, error) {
 	collection := m.client.Database("whishper").Collection("transcriptions")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	filter := bson.D{primitive.E{Key: "_id", Value: t.ID}}
+	filter := bson.D{primitive.E{Key: "_id", Value: t.ID}, versionFilter(t.Version)}
+	t.Version++
 	updateQuery := bson.D{primitive.E{Key: "$set", Value: t}}
 	updateResult, err := collection.UpdateOne(ctx, filter, updateQuery)
 	if err != nil {
+		t.Version--
 		return nil, err
 	}
 
+	if updateResult.MatchedCount == 0 {
+		t.Version--
+		return nil, m.matchError(ctx, collection, t.ID)
+	}
+
+	return t, nil
+}
+
+// versionFilter matches the given version. Transcriptions stored before versions
+// were introduced have no version field, and match version 0.
+func versionFilter(version int64) primitive.E {
+	if version == 0 {
+		return primitive.E{Key: "version", Value: bson.D{primitive.E{Key: "$in", Value: bson.A{0, nil}}}}
+	}
+	return primitive.E{Key: "version", Value: version}
+}
+
+// matchError tells why an update did not match any document: either the
+// transcription does not exist, or its version changed.
+func (m *MongoDb) matchError(ctx context.Context, collection *mongo.Collection, id primitive.ObjectID) error {
+	n, err := collection.CountDocuments(ctx, bson.D{primitive.E{Key: "_id", Value: id}})
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrVersionConflict
+	}
+	return errors.New("no documents matched the filter")
+}
+
+func (m *MongoDb) UpdateResult(id string, version int64, translation int, res *models.WhisperResult) (int64, error) {
+	collection := m.client.Database("whishper").Collection("transcriptions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Debug().Msg("Error converting id to object id.")
+		return 0, err
+	}
+
+	field := "result"
+	if translation >= 0 {
+		field = fmt.Sprintf("translations.%d.result", translation)
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: oid}, versionFilter(version)}
+	updateQuery := bson.D{primitive.E{Key: "$set", Value: bson.D{
+		primitive.E{Key: field, Value: res},
+		primitive.E{Key: "version", Value: version + 1},
+	}}}
+	updateResult, err := collection.UpdateOne(ctx, filter, updateQuery)
+	if err != nil {
+		return 0, err
+	}
+	if updateResult.MatchedCount == 0 {
+		return 0, m.matchError(ctx, collection, oid)
+	}
+	return version + 1, nil
+}
+
+func (m *MongoDb) NewRevision(r *models.Revision) (*models.Revision, error) {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i, err := collection.InsertOne(ctx, r)
+	if err != nil {
+		log.Printf("Error creating new revision: %v", err)
+		return nil, err
+	}
+	r.ID = i.InsertedID.(primitive.ObjectID)
+	return r, nil
+}
+
+func (m *MongoDb) GetRevision(id string) *models.Revision {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: oid}}
+	var result models.Revision
+	err = collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		log.Printf("Error getting revision: %v", err)
+		return nil
+	}
+	return &result
+}
+
+func (m *MongoDb) GetRevisions(transcriptionId string) []*models.Revision {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(transcriptionId)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	filter := bson.D{primitive.E{Key: "transcriptionId", Value: oid}}
+	opts := options.Find().
+		SetSort(bson.D{primitive.E{Key: "createdAt", Value: -1}, primitive.E{Key: "_id", Value: -1}}).
+		SetProjection(bson.D{primitive.E{Key: "result", Value: 0}, primitive.E{Key: "translations", Value: 0}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error getting revisions: %v", err)
+		return nil
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []*models.Revision{}
+	for cursor.Next(ctx) {
+		var result models.Revision
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("Error decoding revision: %v", err)
+			return nil
+		}
+		revisions = append(revisions, &result)
+	}
+	return revisions
+}
+
+func (m *MongoDb) PruneRevisions(transcriptionId string, keep int, before time.Time) error {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(transcriptionId)
+	if err != nil {
+		return err
+	}
+
+	if keep > 0 {
+		// Find the oldest revision to keep, and delete everything older than it.
+		filter := bson.D{primitive.E{Key: "transcriptionId", Value: oid}}
+		opts := options.Find().
+			SetSort(bson.D{primitive.E{Key: "createdAt", Value: -1}, primitive.E{Key: "_id", Value: -1}}).
+			SetSkip(int64(keep - 1)).
+			SetLimit(1).
+			SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}, primitive.E{Key: "createdAt", Value: 1}})
+		cursor, err := collection.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		var oldest []models.Revision
+		if err := cursor.All(ctx, &oldest); err != nil {
+			return err
+		}
+		if len(oldest) > 0 {
+			_, err = collection.DeleteMany(ctx, bson.D{
+				primitive.E{Key: "transcriptionId", Value: oid},
+				primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$lt", Value: oldest[0].ID}}},
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !before.IsZero() {
+		_, err = collection.DeleteMany(ctx, bson.D{
+			primitive.E{Key: "transcriptionId", Value: oid},
+			primitive.E{Key: "createdAt", Value: bson.D{primitive.E{Key: "$lt", Value: before}}},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MongoDb) GetRevisionHistory(transcriptionId, revisionId string) []*models.Revision {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tid, err := primitive.ObjectIDFromHex(transcriptionId)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	rid, err := primitive.ObjectIDFromHex(revisionId)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+
+	// The last full revision up to the requested one
+	var base models.Revision
+	filter := bson.D{
+		primitive.E{Key: "transcriptionId", Value: tid},
+		primitive.E{Key: "partial", Value: bson.D{primitive.E{Key: "$ne", Value: true}}},
+		primitive.E{Key: "_id", Value: bson.D{primitive.E{Key: "$lte", Value: rid}}},
+	}
+	opts := options.FindOne().
+		SetSort(bson.D{primitive.E{Key: "_id", Value: -1}}).
+		SetProjection(bson.D{primitive.E{Key: "_id", Value: 1}})
+	if err := collection.FindOne(ctx, filter, opts).Decode(&base); err != nil {
+		log.Printf("Error getting the base of revision %v: %v", revisionId, err)
+		return nil
+	}
+
+	filter = bson.D{
+		primitive.E{Key: "transcriptionId", Value: tid},
+		primitive.E{Key: "_id", Value: bson.D{
+			primitive.E{Key: "$gte", Value: base.ID},
+			primitive.E{Key: "$lte", Value: rid},
+		}},
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{primitive.E{Key: "_id", Value: 1}}))
+	if err != nil {
+		log.Printf("Error getting revisions: %v", err)
+		return nil
+	}
+	defer cursor.Close(ctx)
+
+	revisions := []*models.Revision{}
+	for cursor.Next(ctx) {
+		var result models.Revision
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("Error decoding revision: %v", err)
+			return nil
+		}
+		revisions = append(revisions, &result)
+	}
+	return revisions
+}
+
+func (m *MongoDb) SetRevisionSnapshot(id string, result *models.WhisperResult, translations []models.Translation) error {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = collection.UpdateOne(ctx, bson.D{primitive.E{Key: "_id", Value: oid}}, bson.D{
+		primitive.E{Key: "$set", Value: bson.D{
+			primitive.E{Key: "result", Value: result},
+			primitive.E{Key: "translations", Value: translations},
+		}},
+		primitive.E{Key: "$unset", Value: bson.D{primitive.E{Key: "partial", Value: ""}}},
+	})
+	return err
+}
+
+func (m *MongoDb) DeleteRevisions(transcriptionId string) error {
+	collection := m.client.Database("whishper").Collection("revisions")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(transcriptionId)
+	if err != nil {
+		return err
+	}
+	_, err = collection.DeleteMany(ctx, bson.D{primitive.E{Key: "transcriptionId", Value: oid}})
+	return err
+}
+
+func (m *MongoDb) NewVocabulary(v *models.Vocabulary) (*models.Vocabulary, error) {
+	collection := m.client.Database("whishper").Collection("vocabularies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i, err := collection.InsertOne(ctx, v)
+	if err != nil {
+		log.Printf("Error creating new vocabulary: %v", err)
+		return nil, err
+	}
+	v.ID = i.InsertedID.(primitive.ObjectID)
+	return v, nil
+}
+
+func (m *MongoDb) UpdateVocabulary(v *models.Vocabulary) (*models.Vocabulary, error) {
+	collection := m.client.Database("whishper").Collection("vocabularies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: v.ID}}
+	updateQuery := bson.D{primitive.E{Key: "$set", Value: v}}
+	updateResult, err := collection.UpdateOne(ctx, filter, updateQuery)
+	if err != nil {
+		return nil, err
+	}
 	if updateResult.MatchedCount == 0 {
 		return nil, errors.New("no documents matched the filter")
 	}
-	if updateResult.ModifiedCount == 0 {
-		return nil, errors.New("no documents were modified")
+	return v, nil
+}
+
+func (m *MongoDb) DeleteVocabulary(id string) error {
+	collection := m.client.Database("whishper").Collection("vocabularies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
 	}
+	_, err = collection.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: oid}})
+	return err
+}
 
-	return t, nil
+func (m *MongoDb) GetVocabulary(id string) *models.Vocabulary {
+	collection := m.client.Database("whishper").Collection("vocabularies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: oid}}
+	var result models.Vocabulary
+	err = collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		log.Printf("Error getting vocabulary: %v", err)
+		return nil
+	}
+	return &result
+}
+
+func (m *MongoDb) GetVocabularies() []*models.Vocabulary {
+	collection := m.client.Database("whishper").Collection("vocabularies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "name", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.D{}, opts)
+	if err != nil {
+		log.Printf("Error getting vocabularies: %v", err)
+		return nil
+	}
+	defer cursor.Close(ctx)
+
+	vocabularies := []*models.Vocabulary{}
+	for cursor.Next(ctx) {
+		var result models.Vocabulary
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("Error decoding vocabulary: %v", err)
+			return nil
+		}
+		vocabularies = append(vocabularies, &result)
+	}
+	return vocabularies
+}
+
+func (m *MongoDb) NewRender(r *models.Render) (*models.Render, error) {
+	collection := m.client.Database("whishper").Collection("renders")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i, err := collection.InsertOne(ctx, r)
+	if err != nil {
+		log.Printf("Error creating new render: %v", err)
+		return nil, err
+	}
+	r.ID = i.InsertedID.(primitive.ObjectID)
+	return r, nil
+}
+
+func (m *MongoDb) UpdateRender(r *models.Render) (*models.Render, error) {
+	collection := m.client.Database("whishper").Collection("renders")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.D{primitive.E{Key: "_id", Value: r.ID}}
+	updateQuery := bson.D{primitive.E{Key: "$set", Value: r}}
+	updateResult, err := collection.UpdateOne(ctx, filter, updateQuery)
+	if err != nil {
+		return nil, err
+	}
+	if updateResult.MatchedCount == 0 {
+		return nil, errors.New("no documents matched the filter")
+	}
+	return r, nil
+}
+
+func (m *MongoDb) DeleteRender(id string) error {
+	collection := m.client.Database("whishper").Collection("renders")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	_, err = collection.DeleteOne(ctx, bson.D{primitive.E{Key: "_id", Value: oid}})
+	return err
+}
+
+func (m *MongoDb) GetRender(id string) *models.Render {
+	collection := m.client.Database("whishper").Collection("renders")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	filter := bson.D{primitive.E{Key: "_id", Value: oid}}
+	var result models.Render
+	err = collection.FindOne(ctx, filter).Decode(&result)
+	if err != nil {
+		log.Printf("Error getting render: %v", err)
+		return nil
+	}
+	return &result
+}
+
+func (m *MongoDb) GetRenders(transcriptionId string) []*models.Render {
+	oid, err := primitive.ObjectIDFromHex(transcriptionId)
+	if err != nil {
+		log.Printf("Error converting id to object id: %v", err)
+		return nil
+	}
+	filter := bson.D{primitive.E{Key: "transcriptionId", Value: oid}}
+	return m.findRenders(filter, -1)
+}
+
+func (m *MongoDb) GetPendingRenders() []*models.Render {
+	filter := bson.D{primitive.E{Key: "status", Value: bson.D{primitive.E{Key: "$in", Value: bson.A{
+		models.TranscriptionStatusPending, models.TranscriptionStatusRunning,
+	}}}}}
+	return m.findRenders(filter, 1)
+}
+
+// findRenders returns the renders matching a filter, sorted by creation date.
+func (m *MongoDb) findRenders(filter bson.D, order int) []*models.Render {
+	collection := m.client.Database("whishper").Collection("renders")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{primitive.E{Key: "createdAt", Value: order}})
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Error getting renders: %v", err)
+		return nil
+	}
+	defer cursor.Close(ctx)
+
+	renders := []*models.Render{}
+	for cursor.Next(ctx) {
+		var result models.Render
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("Error decoding render: %v", err)
+			return nil
+		}
+		renders = append(renders, &result)
+	}
+	return renders
+}
+
+func (m *MongoDb) NewWatchRejection(r *models.WatchRejection) (*models.WatchRejection, error) {
+	collection := m.client.Database("whishper").Collection("watch_rejections")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i, err := collection.InsertOne(ctx, r)
+	if err != nil {
+		log.Printf("Error creating new watch rejection: %v", err)
+		return nil, err
+	}
+	r.ID = i.InsertedID.(primitive.ObjectID)
+	return r, nil
+}
+
+func (m *MongoDb) GetWatchRejections() []*models.WatchRejection {
+	collection := m.client.Database("whishper").Collection("watch_rejections")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		log.Printf("Error getting watch rejections: %v", err)
+		return nil
+	}
+	defer cursor.Close(ctx)
+
+	rejections := []*models.WatchRejection{}
+	for cursor.Next(ctx) {
+		var result models.WatchRejection
+		if err := cursor.Decode(&result); err != nil {
+			log.Printf("Error decoding watch rejection: %v", err)
+			return nil
+		}
+		rejections = append(rejections, &result)
+	}
+	return rejections
 }
@@ -0,0 +1,89 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const FormatAss = "ass"
+
+// WriteAss writes the segments of a result as Advanced SubStation Alpha
+// subtitles, with the style of the options.
+func WriteAss(res *models.WhisperResult, opts Options, w io.Writer) error {
+	s := opts.Style.WithDefaults()
+	// Boxes are drawn with the outline color, and the outline is their padding
+	borderStyle, outlineColor := 1, s.OutlineColor
+	if s.Box {
+		borderStyle, outlineColor = 3, s.BackColor
+	}
+	_, err := fmt.Fprintf(w, `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+WrapStyle: 0
+ScaledBorderAndShadow: yes
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,%s,%d,%s,%s,%s,%s,%d,%d,0,0,100,100,0,0,%d,%s,%s,%d,60,60,%d,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`, s.FontName, s.FontSize, assColor(s.PrimaryColor), assColor(s.PrimaryColor), assColor(outlineColor), assColor(s.BackColor),
+		assBool(s.Bold), assBool(s.Italic), borderStyle, assNumber(s.Outline), assNumber(s.Shadow), s.Alignment, s.MarginV)
+	if err != nil {
+		return err
+	}
+	for _, seg := range res.Segments {
+		_, err := fmt.Fprintf(w, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n",
+			assTimestamp(seg.Start), assTimestamp(seg.End), assText(cueText(seg, opts)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assColor converts #RRGGBB or #RRGGBBAA, where AA is the opacity, to the
+// &HAABBGGRR of ASS, where AA is the transparency.
+func assColor(color string) string {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) < 6 {
+		return "&H00FFFFFF"
+	}
+	alpha := 0
+	if len(color) == 8 {
+		opacity, _ := strconv.ParseUint(color[6:8], 16, 8)
+		alpha = 255 - int(opacity)
+	}
+	return strings.ToUpper(fmt.Sprintf("&H%02x%s%s%s", alpha, color[4:6], color[2:4], color[0:2]))
+}
+
+func assBool(b bool) int {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+func assNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// assTimestamp formats seconds as h:mm:ss.cc.
+func assTimestamp(seconds float64) string {
+	cs := int64(seconds*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, (cs%360000)/6000, (cs%6000)/100, cs%100)
+}
+
+// assText escapes the override blocks and line breaks of a subtitle text.
+func assText(text string) string {
+	text = strings.ReplaceAll(text, "{", `\{`)
+	text = strings.ReplaceAll(text, "}", `\}`)
+	text = strings.ReplaceAll(text, "\r\n", `\N`)
+	return strings.ReplaceAll(text, "\n", `\N`)
+}
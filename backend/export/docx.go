@@ -0,0 +1,87 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+const docxContentTypes = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/word/document.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.document.main+xml"/>
+</Types>`
+
+const docxRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/>
+</Relationships>`
+
+// WriteDocx writes the document as an Office Open XML (.docx) file.
+func (d *Document) WriteDocx(w io.Writer) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`)
+	body.WriteString(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>`)
+
+	// Title page
+	docxParagraph(&body, docxRun(d.Title, `<w:b/><w:sz w:val="48"/>`))
+	for _, md := range d.Metadata() {
+		docxParagraph(&body, docxRun(md[0]+": ", `<w:b/>`)+docxRun(md[1], ""))
+	}
+	body.WriteString(`<w:p><w:r><w:br w:type="page"/></w:r></w:p>`)
+
+	for _, p := range d.Paragraphs {
+		var runs string
+		timestamp, speaker := d.Prefix(p)
+		if timestamp != "" {
+			runs += docxRun(timestamp+" ", `<w:color w:val="808080"/>`)
+		}
+		if speaker != "" {
+			runs += docxRun(speaker+" ", `<w:b/>`)
+		}
+		runs += docxRun(p.Text, "")
+		docxParagraph(&body, runs)
+	}
+	body.WriteString(`<w:sectPr><w:pgSz w:w="11906" w:h="16838"/><w:pgMar w:top="1440" w:right="1440" w:bottom="1440" w:left="1440" w:header="708" w:footer="708" w:gutter="0"/></w:sectPr>`)
+	body.WriteString(`</w:body></w:document>`)
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"[Content_Types].xml", []byte(docxContentTypes)},
+		{"_rels/.rels", []byte(docxRels)},
+		{"word/document.xml", body.Bytes()},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func docxParagraph(b *bytes.Buffer, runs string) {
+	b.WriteString(`<w:p><w:pPr><w:spacing w:after="160"/></w:pPr>`)
+	b.WriteString(runs)
+	b.WriteString(`</w:p>`)
+}
+
+func docxRun(text, props string) string {
+	var b bytes.Buffer
+	b.WriteString(`<w:r>`)
+	if props != "" {
+		b.WriteString(`<w:rPr>` + props + `</w:rPr>`)
+	}
+	b.WriteString(`<w:t xml:space="preserve">`)
+	xml.EscapeText(&b, []byte(text))
+	b.WriteString(`</w:t></w:r>`)
+	return b.String()
+}
@@ -0,0 +1,237 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	FormatDocx = "docx"
+	FormatOdt  = "odt"
+	FormatPdf  = "pdf"
+)
+
+// Options control how a transcript is laid out in a document.
+type Options struct {
+	// Timestamps prefixes every paragraph with its start time.
+	Timestamps bool
+	// Speakers prefixes every paragraph with the speaker label, if known.
+	Speakers bool
+	// MaxGap is the longest pause, in seconds, between two segments that
+	// still allows them to be merged into the same paragraph.
+	MaxGap float64
+	// MaxChars is the length after which a paragraph is closed at the next
+	// sentence end.
+	MaxChars int
+	// Style of ASS subtitles.
+	Style models.SubtitleStyle
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxGap:   2.0,
+		MaxChars: 500,
+	}
+}
+
+type Paragraph struct {
+	Start   float64
+	End     float64
+	Speaker string
+	Text    string
+}
+
+// Document is the format-independent representation of an exported transcript.
+type Document struct {
+	Title      string
+	FileName   string
+	SourceUrl  string
+	Language   string
+	Model      string
+	Duration   float64
+	Options    Options
+	Paragraphs []Paragraph
+}
+
+// BaseName returns the name of the original media file without its extension,
+// made safe to be used as a file name.
+func BaseName(t *models.Transcription) string {
+	name := t.DisplayName()
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "transcription"
+	}
+	return name
+}
+
+// NewDocument builds a document for the given result, which is either the
+// transcription result or one of its translations.
+func NewDocument(t *models.Transcription, res *models.WhisperResult, opts Options) *Document {
+	language := res.Language
+	if language == "" {
+		language = t.Language
+	}
+	return &Document{
+		Title:      BaseName(t),
+		FileName:   t.DisplayName(),
+		SourceUrl:  t.SourceUrl,
+		Language:   language,
+		Model:      t.ModelSize,
+		Duration:   res.Duration,
+		Options:    opts,
+		Paragraphs: Paragraphs(res, opts),
+	}
+}
+
+// Paragraphs merges consecutive short segments into paragraphs. A new paragraph
+// is started when the speaker changes, when there is a long pause between two
+// segments, or when the current paragraph is long enough and ends a sentence.
+func Paragraphs(res *models.WhisperResult, opts Options) []Paragraph {
+	var paragraphs []Paragraph
+	var current *Paragraph
+	var lastEnd float64
+	for _, seg := range res.Segments {
+		text := strings.TrimSpace(seg.Text)
+		if text == "" {
+			continue
+		}
+		if current != nil {
+			long := opts.MaxChars > 0 && utf8.RuneCountInString(current.Text) >= opts.MaxChars && endsSentence(current.Text)
+			if seg.Speaker != current.Speaker || seg.Start-lastEnd > opts.MaxGap || long {
+				paragraphs = append(paragraphs, *current)
+				current = nil
+			}
+		}
+		if current == nil {
+			current = &Paragraph{Start: seg.Start, End: seg.End, Speaker: seg.Speaker, Text: text}
+		} else {
+			current.Text += " " + text
+			current.End = seg.End
+		}
+		lastEnd = seg.End
+	}
+	if current != nil {
+		paragraphs = append(paragraphs, *current)
+	}
+	return paragraphs
+}
+
+// Metadata returns the label/value pairs shown on the title page.
+func (d *Document) Metadata() [][2]string {
+	md := [][2]string{{"File name", d.FileName}}
+	if d.SourceUrl != "" {
+		md = append(md, [2]string{"Source URL", d.SourceUrl})
+	}
+	md = append(md,
+		[2]string{"Language", d.Language},
+		[2]string{"Model", d.Model},
+		[2]string{"Duration", FormatTimestamp(d.Duration)},
+	)
+	return md
+}
+
+// Prefix returns the timestamp and speaker label of a paragraph, as selected
+// in the document options.
+func (d *Document) Prefix(p Paragraph) (timestamp, speaker string) {
+	if d.Options.Timestamps {
+		timestamp = "[" + FormatTimestamp(p.Start) + "]"
+	}
+	if d.Options.Speakers && p.Speaker != "" {
+		speaker = p.Speaker + ":"
+	}
+	return timestamp, speaker
+}
+
+// Formats lists every format a transcript can be exported to.
+var Formats = []string{FormatSrt, FormatVtt, FormatAss, FormatTxt, FormatJson, FormatDocx, FormatOdt, FormatPdf}
+
+// Write exports a result of the transcription in the given format.
+func Write(format string, t *models.Transcription, res *models.WhisperResult, opts Options, w io.Writer) error {
+	res = withSpeakerNames(t, res)
+	switch format {
+	case FormatSrt:
+		return WriteSrt(res, opts, w)
+	case FormatVtt:
+		return WriteVtt(res, opts, w)
+	case FormatAss:
+		return WriteAss(res, opts, w)
+	case FormatTxt:
+		return WriteTxt(NewDocument(t, res, opts), w)
+	case FormatJson:
+		return WriteJson(res, w)
+	}
+	return NewDocument(t, res, opts).Write(format, w)
+}
+
+// Write renders the document in the given format.
+func (d *Document) Write(format string, w io.Writer) error {
+	switch format {
+	case FormatDocx:
+		return d.WriteDocx(w)
+	case FormatOdt:
+		return d.WriteOdt(w)
+	case FormatPdf:
+		return d.WritePdf(w)
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+func ContentType(format string) string {
+	switch format {
+	case FormatDocx:
+		return "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	case FormatOdt:
+		return "application/vnd.oasis.opendocument.text"
+	case FormatPdf:
+		return "application/pdf"
+	case FormatSrt:
+		return "application/x-subrip"
+	case FormatVtt:
+		return "text/vtt"
+	case FormatAss:
+		return "text/x-ssa"
+	case FormatTxt:
+		return "text/plain; charset=utf-8"
+	case FormatJson:
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// withSpeakerNames returns a copy of the result where the speaker IDs of the
+// segments are replaced by their display names.
+func withSpeakerNames(t *models.Transcription, res *models.WhisperResult) *models.WhisperResult {
+	named := *res
+	named.Segments = make([]models.Segment, len(res.Segments))
+	for i, seg := range res.Segments {
+		if seg.Speaker != "" {
+			seg.Speaker = t.SpeakerName(seg.Speaker)
+		}
+		named.Segments[i] = seg
+	}
+	return &named
+}
+
+// FormatTimestamp formats seconds as hh:mm:ss.
+func FormatTimestamp(seconds float64) string {
+	s := int(seconds)
+	return fmt.Sprintf("%02d:%02d:%02d", s/3600, (s%3600)/60, s%60)
+}
+
+func endsSentence(text string) bool {
+	text = strings.TrimRight(text, `"')]»”’ `)
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, "?") || strings.HasSuffix(text, "!") ||
+		strings.HasSuffix(text, "…") || strings.HasSuffix(text, "。")
+}
@@ -0,0 +1,98 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+const odtMimetype = "application/vnd.oasis.opendocument.text"
+
+const odtManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<manifest:manifest xmlns:manifest="urn:oasis:names:tc:opendocument:xmlns:manifest:1.0" manifest:version="1.2">
+<manifest:file-entry manifest:full-path="/" manifest:media-type="application/vnd.oasis.opendocument.text"/>
+<manifest:file-entry manifest:full-path="content.xml" manifest:media-type="text/xml"/>
+</manifest:manifest>`
+
+const odtContentHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:style="urn:oasis:names:tc:opendocument:xmlns:style:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0" xmlns:fo="urn:oasis:names:tc:opendocument:xmlns:xsl-fo-compatible:1.0" office:version="1.2">
+<office:automatic-styles>
+<style:style style:name="Title" style:family="paragraph"><style:text-properties fo:font-size="24pt" fo:font-weight="bold"/></style:style>
+<style:style style:name="Body" style:family="paragraph"><style:paragraph-properties fo:margin-bottom="0.28cm"/></style:style>
+<style:style style:name="PageBreak" style:family="paragraph"><style:paragraph-properties fo:break-before="page" fo:margin-bottom="0.28cm"/></style:style>
+<style:style style:name="Bold" style:family="text"><style:text-properties fo:font-weight="bold"/></style:style>
+<style:style style:name="Time" style:family="text"><style:text-properties fo:color="#808080"/></style:style>
+</office:automatic-styles>
+<office:body><office:text>`
+
+const odtContentFooter = `</office:text></office:body></office:document-content>`
+
+// WriteOdt writes the document as an OpenDocument Text (.odt) file.
+func (d *Document) WriteOdt(w io.Writer) error {
+	var body bytes.Buffer
+	body.WriteString(odtContentHeader)
+
+	// Title page
+	body.WriteString(`<text:p text:style-name="Title">` + odtEscape(d.Title) + `</text:p>`)
+	for _, md := range d.Metadata() {
+		body.WriteString(`<text:p text:style-name="Body">`)
+		body.WriteString(odtSpan("Bold", md[0]+": ") + odtEscape(md[1]))
+		body.WriteString(`</text:p>`)
+	}
+
+	for i, p := range d.Paragraphs {
+		style := "Body"
+		if i == 0 {
+			style = "PageBreak"
+		}
+		body.WriteString(`<text:p text:style-name="` + style + `">`)
+		timestamp, speaker := d.Prefix(p)
+		if timestamp != "" {
+			body.WriteString(odtSpan("Time", timestamp) + `<text:s/>`)
+		}
+		if speaker != "" {
+			body.WriteString(odtSpan("Bold", speaker) + `<text:s/>`)
+		}
+		body.WriteString(odtEscape(p.Text))
+		body.WriteString(`</text:p>`)
+	}
+	body.WriteString(odtContentFooter)
+
+	zw := zip.NewWriter(w)
+	// The mimetype file must be the first entry and must not be compressed.
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write([]byte(odtMimetype)); err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"META-INF/manifest.xml", []byte(odtManifest)},
+		{"content.xml", body.Bytes()},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func odtSpan(style, text string) string {
+	return `<text:span text:style-name="` + style + `">` + odtEscape(text) + `</text:span>`
+}
+
+func odtEscape(text string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
@@ -0,0 +1,448 @@
+package export
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// The PDF writer uses the standard Helvetica fonts, which every PDF reader
+// provides, so no font has to be embedded. Their text is encoded as WinAnsi
+// (Windows-1252), so other scripts need the TrueType font given by PDF_FONT,
+// which is then embedded. Text that no font can show fails the export, rather
+// than being replaced.
+
+// ErrUnsupportedText is returned when the text of a PDF has characters that its
+// font cannot show.
+var ErrUnsupportedText = errors.New("the PDF font cannot show the text")
+
+const (
+	pdfPageWidth  = 595.0 // A4, in points
+	pdfPageHeight = 842.0
+	pdfMargin     = 56.0
+	pdfFontSize   = 11.0
+	pdfLeading    = 15.0
+)
+
+type pdfLine struct {
+	x, y  float64
+	size  float64
+	bold  bool
+	gray  bool
+	text  string
+	extra []pdfLine // runs following on the same line
+}
+
+type pdfLayout struct {
+	pages [][]pdfLine
+	y     float64
+}
+
+func (l *pdfLayout) newPage() {
+	l.pages = append(l.pages, nil)
+	l.y = pdfPageHeight - pdfMargin
+}
+
+func (l *pdfLayout) add(line pdfLine, leading float64) {
+	if len(l.pages) == 0 || l.y-leading < pdfMargin {
+		l.newPage()
+	}
+	l.y -= leading
+	line.y = l.y
+	for i := range line.extra {
+		line.extra[i].y = l.y
+	}
+	l.pages[len(l.pages)-1] = append(l.pages[len(l.pages)-1], line)
+}
+
+// WritePdf writes the document as a PDF file.
+func (d *Document) WritePdf(w io.Writer) error {
+	ttf, err := loadPdfFont()
+	if err != nil {
+		return err
+	}
+	fonts := &pdfFonts{ttf: ttf, used: map[uint16]rune{}}
+
+	var l pdfLayout
+	width := pdfPageWidth - 2*pdfMargin
+
+	// Title page
+	l.newPage()
+	for _, line := range fonts.wrap(d.Title, true, 24, width, width) {
+		l.add(pdfLine{x: pdfMargin, size: 24, bold: true, text: line}, 30)
+	}
+	l.y -= 20
+	for _, md := range d.Metadata() {
+		label := md[0] + ": "
+		labelWidth := fonts.width(label, true, pdfFontSize)
+		lines := fonts.wrap(md[1], false, pdfFontSize, width-labelWidth, width-labelWidth)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		for i, line := range lines {
+			pl := pdfLine{x: pdfMargin + labelWidth, size: pdfFontSize, text: line}
+			if i == 0 {
+				pl = pdfLine{x: pdfMargin, size: pdfFontSize, bold: true, text: label,
+					extra: []pdfLine{pl}}
+			}
+			l.add(pl, pdfLeading)
+		}
+	}
+
+	// Transcript
+	l.newPage()
+	for i, p := range d.Paragraphs {
+		if i > 0 {
+			l.y -= pdfLeading / 2
+		}
+		timestamp, speaker := d.Prefix(p)
+		var prefix []pdfLine
+		x := pdfMargin
+		if timestamp != "" {
+			prefix = append(prefix, pdfLine{x: x, size: pdfFontSize, gray: true, text: timestamp})
+			x += fonts.width(timestamp+" ", false, pdfFontSize)
+		}
+		if speaker != "" {
+			prefix = append(prefix, pdfLine{x: x, size: pdfFontSize, bold: true, text: speaker})
+			x += fonts.width(speaker+" ", true, pdfFontSize)
+		}
+		// The first line is shortened by the prefix, the rest use the full width.
+		lines := fonts.wrap(p.Text, false, pdfFontSize, width-(x-pdfMargin), width)
+		if len(lines) == 0 {
+			continue
+		}
+		runs := append(prefix, pdfLine{x: x, size: pdfFontSize, text: lines[0]})
+		first := runs[0]
+		first.extra = runs[1:]
+		l.add(first, pdfLeading)
+		for _, line := range lines[1:] {
+			l.add(pdfLine{x: pdfMargin, size: pdfFontSize, text: line}, pdfLeading)
+		}
+	}
+
+	return writePdfPages(w, d.Title, l.pages, fonts)
+}
+
+func writePdfPages(w io.Writer, title string, pages [][]pdfLine, fonts *pdfFonts) error {
+	var buf bytes.Buffer
+	// Objects 1-5 are fixed, pages start at object 6 (page, content). An
+	// embedded font is described by the 5 objects that follow the pages.
+	fontObjects := 6 + 2*len(pages)
+	offsets := make([]int, fontObjects-1)
+	if fonts.ttf != nil {
+		offsets = append(offsets, make([]int, 5)...)
+	}
+	obj := func(n int, content string) {
+		offsets[n-1] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", n, content)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	fontResources := "/F1 3 0 R /F2 4 0 R"
+	if fonts.ttf != nil {
+		fontResources += fmt.Sprintf(" /F3 %d 0 R", fontObjects)
+	}
+	obj(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	obj(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj(3, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj(4, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(5, fmt.Sprintf("<< /Title %s /Producer (Whishper) >>", pdfTextString(title)))
+
+	for i, page := range pages {
+		var content bytes.Buffer
+		for _, line := range page {
+			for _, run := range append([]pdfLine{line}, line.extra...) {
+				color := "0 g 0 G"
+				if run.gray {
+					color = "0.5 g 0.5 G"
+				}
+				text, err := fonts.show(run.text, run.bold, run.size)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&content, "BT %s %.2f %.2f Td %s ET\n", color, run.x, run.y, text)
+			}
+		}
+		obj(6+2*i, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, fontResources, 7+2*i))
+		obj(7+2*i, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	// The objects of the embedded font are written once the glyphs used are known
+	if fonts.ttf != nil {
+		if err := fonts.writeObjects(obj, fontObjects); err != nil {
+			return err
+		}
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfFonts shows the text with the embedded TrueType font, if any, and with the
+// Helvetica fonts the characters it does not have. The glyphs used from the
+// embedded font are collected, to describe them once the text is written.
+type pdfFonts struct {
+	ttf  *trueType
+	used map[uint16]rune
+}
+
+// wrap splits text into lines no wider than width points. The first line may
+// be given a different width to leave room for a prefix. Words wider than a
+// line, as in scripts without spaces, are split between characters.
+func (f *pdfFonts) wrap(text string, bold bool, size, firstWidth, width float64) []string {
+	var lines []string
+	var current string
+	limit := func() float64 {
+		if len(lines) == 0 {
+			return firstWidth
+		}
+		return width
+	}
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if current != "" {
+			candidate = current + " " + word
+		}
+		if current != "" && f.width(candidate, bold, size) > limit() {
+			lines = append(lines, current)
+			candidate = word
+		}
+		if f.width(candidate, bold, size) <= limit() {
+			current = candidate
+			continue
+		}
+		current = ""
+		for _, r := range candidate {
+			if current != "" && f.width(current+string(r), bold, size) > limit() {
+				lines = append(lines, current)
+				current = ""
+			}
+			current += string(r)
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+	return lines
+}
+
+func (f *pdfFonts) width(text string, bold bool, size float64) float64 {
+	widths := helveticaWidths
+	if bold {
+		widths = helveticaBoldWidths
+	}
+	var total int
+	for _, r := range text {
+		if gid, ok := f.glyph(r); ok {
+			total += f.ttf.width(gid)
+		} else if r >= 32 && r <= 126 {
+			total += widths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+func (f *pdfFonts) glyph(r rune) (uint16, bool) {
+	if f.ttf == nil {
+		return 0, false
+	}
+	gid, ok := f.ttf.glyphs[r]
+	return gid, ok
+}
+
+// show returns the operators that show text, switching between the embedded
+// font and Helvetica as needed. The embedded font has no bold variant, so its
+// bold text is stroked. Control characters are shown as spaces, and invisible
+// formatting characters are dropped.
+func (f *pdfFonts) show(text string, bold bool, size float64) (string, error) {
+	var b strings.Builder
+	embedded, started := false, false
+	for _, r := range text {
+		if r < 32 {
+			r = ' '
+		}
+		if unicode.Is(unicode.Cf, r) {
+			continue
+		}
+		gid, ok := f.glyph(r)
+		c, ansi := winAnsi(r)
+		if !ok && !ansi {
+			if f.ttf == nil {
+				return "", fmt.Errorf("%w: %q (U+%04X) needs a TrueType font in PDF_FONT", ErrUnsupportedText, r, r)
+			}
+			return "", fmt.Errorf("%w: %q (U+%04X) is not in PDF_FONT", ErrUnsupportedText, r, r)
+		}
+		if !started || ok != embedded {
+			if started {
+				b.WriteString(closeString(embedded) + " Tj ")
+			}
+			started, embedded = true, ok
+			switch {
+			case embedded && bold:
+				fmt.Fprintf(&b, "/F3 %.1f Tf 2 Tr %.2f w <", size, size*0.03)
+			case embedded:
+				fmt.Fprintf(&b, "/F3 %.1f Tf 0 Tr <", size)
+			case bold:
+				fmt.Fprintf(&b, "/F2 %.1f Tf 0 Tr (", size)
+			default:
+				fmt.Fprintf(&b, "/F1 %.1f Tf 0 Tr (", size)
+			}
+		}
+		if embedded {
+			f.used[gid] = r
+			fmt.Fprintf(&b, "%04X", gid)
+			continue
+		}
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c > 126:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if !started {
+		return fmt.Sprintf("/F1 %.1f Tf () Tj", size), nil
+	}
+	b.WriteString(closeString(embedded) + " Tj")
+	return b.String(), nil
+}
+
+func closeString(hex bool) string {
+	if hex {
+		return ">"
+	}
+	return ")"
+}
+
+// writeObjects writes the objects of the embedded font from object first: the
+// font, its descendant font, descriptor, file and Unicode map.
+func (f *pdfFonts) writeObjects(obj func(int, string), first int) error {
+	gids := make([]int, 0, len(f.used))
+	for gid := range f.used {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	ttf := f.ttf
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, "%d [%d] ", gid, ttf.width(uint16(gid)))
+	}
+	obj(first, fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		ttf.name, first+1, first+4))
+	obj(first+1, fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /W [%s] >>",
+		ttf.name, first+2, widths.String()))
+	obj(first+2, fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		ttf.name, ttf.scale(ttf.bbox[0]), ttf.scale(ttf.bbox[1]), ttf.scale(ttf.bbox[2]), ttf.scale(ttf.bbox[3]),
+		ttf.scale(ttf.ascent), ttf.scale(ttf.descent), ttf.scale(ttf.ascent), first+3))
+
+	var file bytes.Buffer
+	zw := zlib.NewWriter(&file)
+	if _, err := zw.Write(ttf.data); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	obj(first+3, fmt.Sprintf("<< /Length %d /Length1 %d /Filter /FlateDecode >>\nstream\n%s\nendstream", file.Len(), len(ttf.data), file.String()))
+
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	// A block maps at most 100 glyphs
+	for i := 0; i < len(gids); i += 100 {
+		block := gids[i:]
+		if len(block) > 100 {
+			block = block[:100]
+		}
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(block))
+		for _, gid := range block {
+			fmt.Fprintf(&cmap, "<%04X> <%s>\n", gid, utf16Hex(f.used[uint16(gid)]))
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	obj(first+4, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", cmap.Len(), cmap.String()))
+	return nil
+}
+
+// pdfTextString encodes text as a PDF text string, in UTF-16 so that any
+// script can be used in the document information.
+func pdfTextString(text string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, r := range text {
+		b.WriteString(utf16Hex(r))
+	}
+	b.WriteByte('>')
+	return b.String()
+}
+
+func utf16Hex(r rune) string {
+	var s string
+	for _, u := range utf16.Encode([]rune{r}) {
+		s += fmt.Sprintf("%04X", u)
+	}
+	return s
+}
+
+// winAnsiHigh maps the Windows-1252 characters of the 0x80-0x9F range.
+var winAnsiHigh = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B, 'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+func winAnsi(r rune) (byte, bool) {
+	if r < 0x80 || (r >= 0xA0 && r <= 0xFF) {
+		return byte(r), true
+	}
+	c, ok := winAnsiHigh[r]
+	return c, ok
+}
+
+// Glyph widths of the printable ASCII range (32-126), from the Adobe font metrics.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
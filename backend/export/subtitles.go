@@ -0,0 +1,87 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	FormatSrt  = "srt"
+	FormatVtt  = "vtt"
+	FormatTxt  = "txt"
+	FormatJson = "json"
+)
+
+// WriteSrt writes the segments of a result as SubRip subtitles.
+func WriteSrt(res *models.WhisperResult, opts Options, w io.Writer) error {
+	for i, seg := range res.Segments {
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			subtitleTimestamp(seg.Start, ","), subtitleTimestamp(seg.End, ","), cueText(seg, opts))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteVtt writes the segments of a result as WebVTT subtitles.
+func WriteVtt(res *models.WhisperResult, opts Options, w io.Writer) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for i, seg := range res.Segments {
+		text := strings.TrimSpace(seg.Text)
+		if opts.Speakers && seg.Speaker != "" {
+			text = fmt.Sprintf("<v %s>%s", seg.Speaker, text)
+		}
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			subtitleTimestamp(seg.Start, "."), subtitleTimestamp(seg.End, "."), text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTxt writes a result as plain text, one paragraph per line.
+func WriteTxt(d *Document, w io.Writer) error {
+	for _, p := range d.Paragraphs {
+		line := p.Text
+		timestamp, speaker := d.Prefix(p)
+		if speaker != "" {
+			line = speaker + " " + line
+		}
+		if timestamp != "" {
+			line = timestamp + " " + line
+		}
+		if _, err := io.WriteString(w, line+"\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJson writes a result as the JSON returned by the ASR service.
+func WriteJson(res *models.WhisperResult, w io.Writer) error {
+	return json.NewEncoder(w).Encode(res)
+}
+
+func cueText(seg models.Segment, opts Options) string {
+	text := strings.TrimSpace(seg.Text)
+	if opts.Speakers && seg.Speaker != "" {
+		text = seg.Speaker + ": " + text
+	}
+	return text
+}
+
+// subtitleTimestamp formats seconds as hh:mm:ss followed by the milliseconds,
+// using the given decimal separator.
+func subtitleTimestamp(seconds float64, sep string) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, (ms%3600000)/60000, (ms%60000)/1000, sep, ms%1000)
+}
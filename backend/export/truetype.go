@@ -0,0 +1,199 @@
+package export
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// trueType is a TrueType font, embedded whole in the PDFs so that text outside
+// of WinAnsi can be shown. Only what the PDF needs is read: the glyph of each
+// character and the advance widths.
+type trueType struct {
+	data       []byte
+	name       string
+	unitsPerEm int
+	ascent     int
+	descent    int
+	bbox       [4]int
+	glyphs     map[rune]uint16
+	advances   []uint16
+}
+
+var (
+	pdfFontOnce sync.Once
+	pdfFontTTF  *trueType
+	pdfFontErr  error
+)
+
+// loadPdfFont reads the font given by the PDF_FONT environment variable once,
+// or returns nil if there is none.
+func loadPdfFont() (*trueType, error) {
+	pdfFontOnce.Do(func() {
+		path := os.Getenv("PDF_FONT")
+		if path == "" {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err == nil {
+			pdfFontTTF, err = parseTrueType(data)
+		}
+		if err != nil {
+			pdfFontErr = fmt.Errorf("invalid PDF_FONT %v: %w", path, err)
+			return
+		}
+		pdfFontTTF.name = fontName(path)
+	})
+	return pdfFontTTF, pdfFontErr
+}
+
+var nonNameChars = regexp.MustCompile(`[^A-Za-z0-9-]+`)
+
+// fontName names the font after its file, as PDF names cannot have spaces.
+func fontName(path string) string {
+	name := nonNameChars.ReplaceAllString(filepath.Base(path[:len(path)-len(filepath.Ext(path))]), "")
+	if name == "" {
+		return "Embedded"
+	}
+	return name
+}
+
+// parseTrueType reads a TrueType font. Fonts with PostScript outlines and font
+// collections are not supported.
+func parseTrueType(data []byte) (f *trueType, err error) {
+	// Out of range reads of a truncated font are reported as an invalid font
+	defer func() {
+		if r := recover(); r != nil {
+			f, err = nil, errors.New("truncated font")
+		}
+	}()
+	be := binary.BigEndian
+	switch be.Uint32(data) {
+	case 0x00010000, 0x74727565: // 1.0, "true"
+	case 0x4f54544f: // "OTTO"
+		return nil, errors.New("fonts with PostScript outlines are not supported, use a TrueType font")
+	case 0x74746366: // "ttcf"
+		return nil, errors.New("font collections are not supported")
+	default:
+		return nil, errors.New("not a TrueType font")
+	}
+
+	tables := map[string][]byte{}
+	numTables := int(be.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		record := data[12+16*i:]
+		offset, length := be.Uint32(record[8:]), be.Uint32(record[12:])
+		if uint64(offset)+uint64(length) > uint64(len(data)) {
+			return nil, errors.New("truncated font")
+		}
+		tables[string(record[:4])] = data[offset : offset+length]
+	}
+	for _, tag := range []string{"head", "hhea", "hmtx", "cmap"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("missing %v table", tag)
+		}
+	}
+
+	f = &trueType{data: data}
+	head := tables["head"]
+	f.unitsPerEm = int(be.Uint16(head[18:]))
+	if f.unitsPerEm == 0 {
+		return nil, errors.New("invalid units per em")
+	}
+	for i := range f.bbox {
+		f.bbox[i] = int(int16(be.Uint16(head[36+2*i:])))
+	}
+	hhea := tables["hhea"]
+	f.ascent = int(int16(be.Uint16(hhea[4:])))
+	f.descent = int(int16(be.Uint16(hhea[6:])))
+	hmtx := tables["hmtx"]
+	f.advances = make([]uint16, be.Uint16(hhea[34:]))
+	for i := range f.advances {
+		f.advances[i] = be.Uint16(hmtx[4*i:])
+	}
+	if len(f.advances) == 0 {
+		return nil, errors.New("missing advance widths")
+	}
+	f.glyphs, err = parseCmap(tables["cmap"])
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseCmap reads the Unicode mapping of characters to glyphs, preferring the
+// one that covers every plane.
+func parseCmap(cmap []byte) (map[rune]uint16, error) {
+	be := binary.BigEndian
+	var bmp, full []byte
+	for i := 0; i < int(be.Uint16(cmap[2:])); i++ {
+		record := cmap[4+8*i:]
+		platform, encoding := be.Uint16(record), be.Uint16(record[2:])
+		if platform != 0 && !(platform == 3 && (encoding == 1 || encoding == 10)) {
+			continue
+		}
+		sub := cmap[be.Uint32(record[4:]):]
+		switch be.Uint16(sub) {
+		case 4:
+			bmp = sub
+		case 12:
+			full = sub
+		}
+	}
+
+	glyphs := map[rune]uint16{}
+	switch {
+	case full != nil:
+		groups := full[16:]
+		for i := 0; i < int(be.Uint32(full[12:])); i++ {
+			group := groups[12*i:]
+			start, end, gid := be.Uint32(group), be.Uint32(group[4:]), be.Uint32(group[8:])
+			for c := start; c <= end && c <= 0x10FFFF; c++ {
+				if g := gid + c - start; g != 0 {
+					glyphs[rune(c)] = uint16(g)
+				}
+			}
+		}
+	case bmp != nil:
+		segCount := int(be.Uint16(bmp[6:])) / 2
+		ends := bmp[14:]
+		starts := ends[2*segCount+2:]
+		deltas := starts[2*segCount:]
+		rangeOffsets := deltas[2*segCount:]
+		for i := 0; i < segCount; i++ {
+			start, end := int(be.Uint16(starts[2*i:])), int(be.Uint16(ends[2*i:]))
+			delta, rangeOffset := int(be.Uint16(deltas[2*i:])), int(be.Uint16(rangeOffsets[2*i:]))
+			for c := start; c <= end && c != 0xFFFF; c++ {
+				gid := 0
+				if rangeOffset == 0 {
+					gid = (c + delta) & 0xFFFF
+				} else if g := int(be.Uint16(rangeOffsets[2*i+rangeOffset+2*(c-start):])); g != 0 {
+					gid = (g + delta) & 0xFFFF
+				}
+				if gid != 0 {
+					glyphs[rune(c)] = uint16(gid)
+				}
+			}
+		}
+	default:
+		return nil, errors.New("no Unicode character map")
+	}
+	return glyphs, nil
+}
+
+// scale converts font units to thousandths of the font size.
+func (f *trueType) scale(v int) int {
+	return v * 1000 / f.unitsPerEm
+}
+
+// width returns the advance width of a glyph, in thousandths of the font size.
+func (f *trueType) width(gid uint16) int {
+	if int(gid) >= len(f.advances) {
+		return f.scale(int(f.advances[len(f.advances)-1]))
+	}
+	return f.scale(int(f.advances[gid]))
+}
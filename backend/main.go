@@ -10,6 +10,8 @@ import (
 	"codeberg.org/pluja/whishper/api"
 	"codeberg.org/pluja/whishper/database"
 	"codeberg.org/pluja/whishper/monitor"
+	"codeberg.org/pluja/whishper/storage"
+	"codeberg.org/pluja/whishper/watcher"
 )
 
 func main() {
@@ -65,9 +67,17 @@ func main() {
 	log.Debug().Msgf("TranslationEndpoint: %v", *translationEndpoint)
 	log.Debug().Msgf("DbHost: %v", *dbHost)
 
+	store, err := storage.New()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error configuring the storage")
+	}
 	dabs := database.NewMongoDb()
-	server := api.NewServer(*listenAddr, dabs)
+	server := api.NewServer(*listenAddr, dabs, store)
 	go monitor.StartMonitor(server)
 	server.NewTranscriptionCh <- true
+	go monitor.StartRenderer(server)
+	server.NewRenderCh <- true
+	go watcher.Start(server)
+	go server.StartUploadSweeper()
 	server.Run()
 }
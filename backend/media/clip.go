@@ -0,0 +1,59 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Formats of the audio clips.
+const (
+	ClipWav  = "wav"
+	ClipMp3  = "mp3"
+	ClipOpus = "opus"
+)
+
+var ClipFormats = []string{ClipWav, ClipMp3, ClipOpus}
+
+// ClipSampleRates are the only sample rates the encoders of the compressed clip
+// formats accept. Wav clips can have any rate.
+var ClipSampleRates = map[string][]int{
+	ClipMp3:  {8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000},
+	ClipOpus: {8000, 12000, 16000, 24000, 48000},
+}
+
+// ClipContentType returns the MIME type of a clip format.
+func ClipContentType(format string) string {
+	switch format {
+	case ClipMp3:
+		return "audio/mpeg"
+	case ClipOpus:
+		return "audio/ogg"
+	}
+	return "audio/wav"
+}
+
+// Clip cuts the audio of src between start and end, in seconds, and stores it in
+// dst, encoded in the format of its extension. If sampleRate is not 0, the clip
+// is resampled to it and mixed to mono.
+func Clip(ctx context.Context, src, dst string, start, end float64, sampleRate int) error {
+	if end <= start {
+		return fmt.Errorf("invalid clip range %v-%v", start, end)
+	}
+	args := []string{"-ss", seconds(start), "-i", src, "-t", seconds(end - start), "-map", "0:a:0", "-vn"}
+	if sampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate), "-ac", "1")
+	}
+	switch strings.TrimPrefix(filepath.Ext(dst), ".") {
+	case ClipMp3:
+		args = append(args, "-c:a", "libmp3lame", "-q:a", "2")
+	case ClipOpus:
+		args = append(args, "-c:a", "libopus", "-b:a", "64k")
+	default:
+		args = append(args, "-c:a", "pcm_s16le")
+	}
+	args = append(args, dst)
+	return Ffmpeg(ctx, args...)
+}
@@ -0,0 +1,82 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Ffmpeg runs ffmpeg with the given arguments. The binary is taken from the
+// FFMPEG_PATH environment variable, or found in the PATH.
+func Ffmpeg(ctx context.Context, args ...string) error {
+	_, err := run(ctx, binary("FFMPEG_PATH", "ffmpeg"), append([]string{"-hide_banner", "-nostdin", "-y"}, args...)...)
+	return err
+}
+
+func binary(env, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	return name
+}
+
+// run executes a command and returns its output, or its last lines of error
+// output on failure.
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	stdout, _, err := command(ctx, name, args...)
+	return stdout, err
+}
+
+// command executes a command and returns its output and error output.
+func command(ctx context.Context, name string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, nil, fmt.Errorf("%v failed: %w: %v", name, err, lastLines(stderr.String(), 5))
+	}
+	return stdout.Bytes(), stderr.Bytes(), nil
+}
+
+// lastLines returns the last n lines of a command output.
+func lastLines(output string, n int) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PCM decodes the first audio stream of src to signed 16-bit little-endian mono
+// samples at the given rate, and passes them to read as they are decoded.
+func PCM(ctx context.Context, src string, rate int, read func(io.Reader) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary("FFMPEG_PATH", "ffmpeg"), "-hide_banner", "-nostdin", "-i", src,
+		"-map", "0:a:0", "-ac", "1", "-ar", strconv.Itoa(rate), "-f", "s16le", "-")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := read(stdout); err != nil {
+		// Stop decoding, as nobody reads it anymore
+		cancel()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%v failed: %w: %v", cmd.Path, err, lastLines(stderr.String(), 5))
+	}
+	return nil
+}
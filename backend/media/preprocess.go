@@ -0,0 +1,157 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	// Silence is quieter than silenceLevel for at least silenceDuration seconds.
+	silenceLevel    = "-50dB"
+	silenceDuration = 0.5
+	// silenceMargin is kept around the speech when trimming, so that its onset
+	// is not cut.
+	silenceMargin = 0.25
+)
+
+// Preprocess converts a range of the first audio stream of src to a 16 kHz mono
+// WAV file in dst, applying the steps of the options. The duration of the media
+// is used to find trailing silence, and may be 0 if unknown. It returns the time
+// of src at which dst starts, to shift the timestamps of its transcription back.
+func Preprocess(ctx context.Context, src, dst string, o models.PreprocessOptions, r models.TimeRange, duration float64) (float64, error) {
+	start, end := r.Start, r.End
+	if o.TrimSilence {
+		length := 0.0
+		if end > 0 {
+			length = end - start
+		} else if duration > 0 {
+			length = duration - start
+		}
+		speechStart, speechEnd, err := speechBounds(ctx, src, start, length)
+		if err != nil {
+			return 0, err
+		}
+		if speechEnd > 0 && (end == 0 || start+speechEnd < end) {
+			end = start + speechEnd
+		}
+		start += speechStart
+	}
+
+	var args []string
+	if start > 0 {
+		args = append(args, "-ss", seconds(start))
+	}
+	args = append(args, "-i", src)
+	if end > 0 {
+		args = append(args, "-t", seconds(end-start))
+	}
+	args = append(args, "-map", "0:a:0", "-vn")
+	if filters := Filters(o); len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	args = append(args, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", dst)
+	return start, Ffmpeg(ctx, args...)
+}
+
+// Filters returns the ffmpeg audio filters for the steps of the options.
+func Filters(o models.PreprocessOptions) []string {
+	var filters []string
+	switch o.Channel {
+	case models.ChannelLeft:
+		filters = append(filters, "pan=mono|c0=c0")
+	case models.ChannelRight:
+		filters = append(filters, "pan=mono|c0=c1")
+	}
+	if o.Highpass > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%d", o.Highpass))
+	}
+	if o.Denoise {
+		filters = append(filters, "afftdn")
+	}
+	// Normalization goes last, so that it is not undone by the other steps
+	if o.Normalize {
+		filters = append(filters, "loudnorm=I=-16:TP=-1.5:LRA=11")
+	}
+	return filters
+}
+
+// speechBounds finds where the speech of the part of a media file that starts at
+// offset and lasts duration seconds (0 for the rest of the file, if unknown)
+// starts and ends, leaving out the silence at both ends. The bounds are relative
+// to the offset, and are 0 when they are not trimmed.
+func speechBounds(ctx context.Context, src string, offset, duration float64) (float64, float64, error) {
+	filter := fmt.Sprintf("silencedetect=noise=%v:d=%v", silenceLevel, silenceDuration)
+	args := []string{"-hide_banner", "-nostdin"}
+	if offset > 0 {
+		args = append(args, "-ss", seconds(offset))
+	}
+	args = append(args, "-i", src)
+	if duration > 0 {
+		args = append(args, "-t", seconds(duration))
+	}
+	args = append(args, "-map", "0:a:0", "-af", filter, "-f", "null", "-")
+	_, stderr, err := command(ctx, binary("FFMPEG_PATH", "ffmpeg"), args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	silences := parseSilences(stderr)
+	if len(silences) == 0 {
+		return 0, 0, nil
+	}
+
+	var start, end float64
+	first, last := silences[0], silences[len(silences)-1]
+	atEnd := func(s silence) bool {
+		return s.end < 0 || (duration > 0 && s.end >= duration-0.05)
+	}
+	if first.start <= 0.05 {
+		if atEnd(first) {
+			// It is all silence, so there is nothing to trim to
+			return 0, 0, nil
+		}
+		start = math.Max(first.end-silenceMargin, 0)
+	}
+	if atEnd(last) && last.start > start {
+		end = last.start + silenceMargin
+	}
+	return start, end, nil
+}
+
+// silence is a range detected by silencedetect. Its end is -1 if it lasts
+// until the end of the media.
+type silence struct {
+	start, end float64
+}
+
+func parseSilences(stderr []byte) []silence {
+	var silences []silence
+	scanner := bufio.NewScanner(bytes.NewReader(stderr))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "silence_start: "); i >= 0 {
+			value := strings.Fields(line[i+len("silence_start: "):])
+			if len(value) > 0 {
+				start, _ := strconv.ParseFloat(value[0], 64)
+				silences = append(silences, silence{start: math.Max(start, 0), end: -1})
+			}
+		} else if i := strings.Index(line, "silence_end: "); i >= 0 && len(silences) > 0 {
+			value := strings.Fields(line[i+len("silence_end: "):])
+			if len(value) > 0 {
+				silences[len(silences)-1].end, _ = strconv.ParseFloat(value[0], 64)
+			}
+		}
+	}
+	return silences
+}
+
+// seconds formats a time for ffmpeg.
+func seconds(t float64) string {
+	return strconv.FormatFloat(t, 'f', 3, 64)
+}
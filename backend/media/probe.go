@@ -0,0 +1,149 @@
+package media
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+var (
+	ErrEmpty       = errors.New("the file is empty")
+	ErrUnsupported = errors.New("the file is not a supported media file")
+	ErrNoAudio     = errors.New("the file has no audio")
+)
+
+type probeOutput struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		Size       string `json:"size"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+	Streams []struct {
+		CodecType     string `json:"codec_type"`
+		CodecName     string `json:"codec_name"`
+		SampleRate    string `json:"sample_rate"`
+		Channels      int    `json:"channels"`
+		ChannelLayout string `json:"channel_layout"`
+		Width         int    `json:"width"`
+		Height        int    `json:"height"`
+		AvgFrameRate  string `json:"avg_frame_rate"`
+		BitRate       string `json:"bit_rate"`
+		Duration      string `json:"duration"`
+		Disposition   struct {
+			AttachedPic int `json:"attached_pic"`
+		} `json:"disposition"`
+	} `json:"streams"`
+}
+
+// Probe describes a media file with ffprobe, whose binary is taken from the
+// FFPROBE_PATH environment variable or found in the PATH. It fails with
+// ErrEmpty, ErrUnsupported or ErrNoAudio if the file cannot be transcribed.
+// Other errors mean that the file could not be probed.
+func Probe(ctx context.Context, path string) (*models.MediaInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return nil, ErrEmpty
+	}
+
+	out, err := run(ctx, binary("FFPROBE_PATH", "ffprobe"), "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	if err != nil {
+		if ctx.Err() != nil || isNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	var probe probeOutput
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+
+	info := &models.MediaInfo{
+		Duration:  parseFloat(probe.Format.Duration),
+		Container: probe.Format.FormatName,
+		Size:      stat.Size(),
+		BitRate:   parseInt(probe.Format.BitRate),
+	}
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "audio":
+			info.AudioStreams++
+			if info.Audio == nil {
+				info.Audio = &models.AudioStream{
+					Codec:         s.CodecName,
+					SampleRate:    int(parseInt(s.SampleRate)),
+					Channels:      s.Channels,
+					ChannelLayout: s.ChannelLayout,
+					BitRate:       parseInt(s.BitRate),
+				}
+			}
+			if info.Duration == 0 {
+				info.Duration = parseFloat(s.Duration)
+			}
+		case "video":
+			// Cover art of audio files is reported as a video stream
+			if info.Video == nil && s.Disposition.AttachedPic == 0 {
+				info.Video = &models.VideoStream{
+					Codec:     s.CodecName,
+					Width:     s.Width,
+					Height:    s.Height,
+					FrameRate: parseRate(s.AvgFrameRate),
+					BitRate:   parseInt(s.BitRate),
+				}
+			}
+		}
+	}
+
+	if info.Audio == nil {
+		return nil, ErrNoAudio
+	}
+	if info.Duration <= 0 {
+		return nil, ErrEmpty
+	}
+	return info, nil
+}
+
+// IsInvalid tells if a probe error means that the file cannot be transcribed,
+// rather than that it could not be probed.
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrEmpty) || errors.Is(err, ErrUnsupported) || errors.Is(err, ErrNoAudio)
+}
+
+// isNotFound tells if the command could not be run because its binary is missing.
+func isNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
+
+// parseRate parses a frame rate given as a fraction, such as 30000/1001.
+func parseRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
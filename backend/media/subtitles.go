@@ -0,0 +1,99 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SubtitleTrack is a subtitle file to embed in a container.
+type SubtitleTrack struct {
+	Path     string
+	Language string
+	Title    string
+}
+
+// BurnSubtitles draws the ASS subtitles on the video of src, and stores it in dst
+// as H.264 with AAC audio.
+func BurnSubtitles(ctx context.Context, src, subtitles, dst string) error {
+	return Ffmpeg(ctx, "-i", src, "-map", "0:v:0", "-map", "0:a?",
+		"-vf", "ass="+filterPath(subtitles),
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "192k", "-movflags", "+faststart", dst)
+}
+
+// mp4AudioCodecs are the audio codecs that can be copied to an MP4 file.
+var mp4AudioCodecs = map[string]bool{
+	"aac": true, "mp3": true, "ac3": true, "eac3": true, "alac": true, "flac": true, "opus": true,
+}
+
+// MuxSubtitles copies the video and audio of src to dst, with the subtitle
+// tracks embedded. The subtitles are stored as mov_text in MP4, and as they are
+// given in other containers. Audio that MP4 cannot hold, such as PCM, is
+// transcoded to AAC.
+func MuxSubtitles(ctx context.Context, src, dst string, tracks []SubtitleTrack) error {
+	var audioCodec string
+	if strings.HasSuffix(dst, ".mp4") {
+		// If the media cannot be probed, the audio is copied, and ffmpeg fails
+		// if it does not fit
+		if info, err := Probe(ctx, src); err == nil && info.Audio != nil {
+			audioCodec = info.Audio.Codec
+		}
+	}
+	return Ffmpeg(ctx, muxArgs(src, dst, tracks, audioCodec)...)
+}
+
+// muxArgs returns the arguments of ffmpeg to mux the subtitle tracks, given the
+// codec of the audio of src, if known.
+func muxArgs(src, dst string, tracks []SubtitleTrack, audioCodec string) []string {
+	args := []string{"-i", src}
+	for _, t := range tracks {
+		args = append(args, "-i", t.Path)
+	}
+	args = append(args, "-map", "0:v?", "-map", "0:a?")
+	for i := range tracks {
+		args = append(args, "-map", fmt.Sprintf("%d:0", i+1))
+	}
+	args = append(args, "-c:v", "copy")
+	if strings.HasSuffix(dst, ".mp4") {
+		if audioCodec != "" && !mp4AudioCodecs[audioCodec] {
+			args = append(args, "-c:a", "aac", "-b:a", "192k")
+		} else {
+			args = append(args, "-c:a", "copy")
+		}
+		args = append(args, "-c:s", "mov_text", "-movflags", "+faststart")
+	} else {
+		args = append(args, "-c:a", "copy", "-c:s", "copy")
+	}
+	for i, t := range tracks {
+		stream := fmt.Sprintf("-metadata:s:s:%d", i)
+		args = append(args, stream, "language="+languageCode(t.Language), stream, "title="+t.Title)
+	}
+	return append(args, dst)
+}
+
+// filterPath escapes a path to be used as an option of an ffmpeg filter.
+func filterPath(path string) string {
+	r := strings.NewReplacer(`\`, `\\\\`, `'`, `\\\'`, `:`, `\\:`, `,`, `\,`, `[`, `\[`, `]`, `\]`, `;`, `\;`)
+	return r.Replace(path)
+}
+
+// iso6392 maps the language codes of Whisper to the three-letter codes used in
+// the metadata of containers.
+var iso6392 = map[string]string{
+	"ar": "ara", "ca": "cat", "cs": "ces", "da": "dan", "de": "deu", "el": "ell",
+	"en": "eng", "es": "spa", "eu": "eus", "fa": "fas", "fi": "fin", "fr": "fra",
+	"gl": "glg", "he": "heb", "hi": "hin", "hu": "hun", "id": "ind", "it": "ita",
+	"ja": "jpn", "ko": "kor", "nl": "nld", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "ron", "ru": "rus", "sk": "slk", "sv": "swe", "th": "tha", "tr": "tur",
+	"uk": "ukr", "vi": "vie", "zh": "zho",
+}
+
+// languageCode returns the three-letter code of a language, or the code as it
+// is if it is not known.
+func languageCode(language string) string {
+	if code, ok := iso6392[strings.ToLower(language)]; ok {
+		return code
+	}
+	return language
+}
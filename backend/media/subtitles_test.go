@@ -0,0 +1,32 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMuxArgs(t *testing.T) {
+	tracks := []SubtitleTrack{{Path: "0.srt", Language: "en", Title: "en"}}
+	tests := []struct {
+		dst, codec, audio string
+	}{
+		{"out.mp4", "aac", "-c:a copy"},
+		{"out.mp4", "pcm_s16le", "-c:a aac -b:a 192k"},
+		{"out.mp4", "vorbis", "-c:a aac -b:a 192k"},
+		// The codec is not known: the audio is copied
+		{"out.mp4", "", "-c:a copy"},
+		{"out.mkv", "pcm_s16le", "-c:a copy"},
+	}
+	for _, tt := range tests {
+		args := strings.Join(muxArgs("in", tt.dst, tracks, tt.codec), " ")
+		if !strings.Contains(args, tt.audio) {
+			t.Errorf("%v with %q: %v, want %v", tt.dst, tt.codec, args, tt.audio)
+		}
+	}
+	args := strings.Join(muxArgs("in", "out.mp4", tracks, "aac"), " ")
+	want := "-i in -i 0.srt -map 0:v? -map 0:a? -map 1:0 -c:v copy -c:a copy -c:s mov_text -movflags +faststart " +
+		"-metadata:s:s:0 language=eng -metadata:s:s:0 title=en out.mp4"
+	if args != want {
+		t.Errorf("got %v, want %v", args, want)
+	}
+}
@@ -7,6 +7,15 @@ const (
 	TrannscriptionStatusTranslating = 3
 	TranscriptionStatusError        = -1
 
+	TaskTranscribe = "transcribe"
+	TaskImport     = "import"
+	TaskAlign      = "align"
+	TaskTranslate  = "translate"
+
+	// Where the result of the translate task is stored
+	TranslationOutputTranslation = "translation"
+	TranslationOutputResult      = "result"
+
 	SourceTypeFile = "file"
 	SourceTypeURL  = "url"
 
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ComputeTypes lists the quantizations the ASR can load a model with.
+var ComputeTypes = []string{"int8", "int8_float16", "int8_float32", "int16", "float16", "float32"}
+
+// DecodingOptions are the parameters of the Whisper decoder for a transcription.
+// They are stored with their effective values, so a result can be reproduced.
+type DecodingOptions struct {
+	BeamSize int `bson:"beamSize" json:"beamSize"`
+	// Temperatures are tried in order when decoding a segment fails the
+	// compression ratio or log probability thresholds.
+	Temperatures []float64 `bson:"temperatures" json:"temperatures"`
+	// VadFilter skips the parts without speech, as detected by Silero VAD.
+	VadFilter bool `bson:"vadFilter" json:"vadFilter"`
+	// VadThreshold is the speech probability above which audio is speech.
+	VadThreshold            float64 `bson:"vadThreshold,omitempty" json:"vadThreshold,omitempty"`
+	VadMinSilenceMs         int     `bson:"vadMinSilenceMs,omitempty" json:"vadMinSilenceMs,omitempty"`
+	VadSpeechPadMs          int     `bson:"vadSpeechPadMs,omitempty" json:"vadSpeechPadMs,omitempty"`
+	WordTimestamps          *bool   `bson:"wordTimestamps" json:"wordTimestamps"`
+	ConditionOnPreviousText *bool   `bson:"conditionOnPreviousText" json:"conditionOnPreviousText"`
+	ComputeType             string  `bson:"computeType" json:"computeType"`
+}
+
+// WithDefaults returns the options with the unset values replaced by the
+// defaults of the ASR service.
+func (o DecodingOptions) WithDefaults(device string) DecodingOptions {
+	yes := true
+	if o.BeamSize == 0 {
+		o.BeamSize = 5
+	}
+	if len(o.Temperatures) == 0 {
+		o.Temperatures = []float64{0, 0.2, 0.4, 0.6, 0.8, 1}
+	}
+	if o.VadFilter {
+		if o.VadThreshold == 0 {
+			o.VadThreshold = 0.5
+		}
+		if o.VadMinSilenceMs == 0 {
+			o.VadMinSilenceMs = 2000
+		}
+		if o.VadSpeechPadMs == 0 {
+			o.VadSpeechPadMs = 400
+		}
+	}
+	if o.WordTimestamps == nil {
+		o.WordTimestamps = &yes
+	}
+	if o.ConditionOnPreviousText == nil {
+		o.ConditionOnPreviousText = &yes
+	}
+	if o.ComputeType == "" {
+		o.ComputeType = "int8"
+		if device == "cuda" {
+			o.ComputeType = "float16"
+		}
+	}
+	return o
+}
+
+// Validate checks that the options are within the ranges the ASR accepts.
+func (o DecodingOptions) Validate(device string) error {
+	if o.BeamSize < 0 || o.BeamSize > 20 {
+		return errors.New("beamSize must be between 1 and 20, or 0 for the default")
+	}
+	if len(o.Temperatures) > 10 {
+		return errors.New("at most 10 temperatures can be given")
+	}
+	for i, t := range o.Temperatures {
+		if t < 0 || t > 1 {
+			return errors.New("temperatures must be between 0 and 1")
+		}
+		if i > 0 && t <= o.Temperatures[i-1] {
+			return errors.New("temperatures must be increasing")
+		}
+	}
+	if o.VadThreshold < 0 || o.VadThreshold >= 1 {
+		return errors.New("the VAD threshold must be between 0 and 1")
+	}
+	if o.VadMinSilenceMs < 0 || o.VadSpeechPadMs < 0 {
+		return errors.New("VAD durations can not be negative")
+	}
+	if o.ComputeType != "" {
+		supported := false
+		for _, c := range ComputeTypes {
+			supported = supported || c == o.ComputeType
+		}
+		if !supported {
+			return fmt.Errorf("compute type %v not supported", o.ComputeType)
+		}
+		if device != "cuda" && (o.ComputeType == "float16" || o.ComputeType == "int8_float16") {
+			return fmt.Errorf("compute type %v requires the cuda device", o.ComputeType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package models
+
+// MediaInfo describes the media file of a transcription, as probed when it was
+// uploaded or downloaded.
+type MediaInfo struct {
+	// Duration in seconds.
+	Duration float64 `bson:"duration" json:"duration"`
+	// Container is the format of the file, such as `mov,mp4,m4a` or `matroska,webm`.
+	Container string `bson:"container" json:"container"`
+	Size      int64  `bson:"size" json:"size"`
+	// BitRate of the whole file, in bits per second.
+	BitRate int64 `bson:"bitRate,omitempty" json:"bitRate,omitempty"`
+	// AudioStreams is the number of audio streams. Only the first one is described.
+	AudioStreams int          `bson:"audioStreams" json:"audioStreams"`
+	Audio        *AudioStream `bson:"audio,omitempty" json:"audio,omitempty"`
+	Video        *VideoStream `bson:"video,omitempty" json:"video,omitempty"`
+}
+
+type AudioStream struct {
+	Codec         string `bson:"codec" json:"codec"`
+	SampleRate    int    `bson:"sampleRate" json:"sampleRate"`
+	Channels      int    `bson:"channels" json:"channels"`
+	ChannelLayout string `bson:"channelLayout,omitempty" json:"channelLayout,omitempty"`
+	BitRate       int64  `bson:"bitRate,omitempty" json:"bitRate,omitempty"`
+}
+
+type VideoStream struct {
+	Codec     string  `bson:"codec" json:"codec"`
+	Width     int     `bson:"width" json:"width"`
+	Height    int     `bson:"height" json:"height"`
+	FrameRate float64 `bson:"frameRate,omitempty" json:"frameRate,omitempty"`
+	BitRate   int64   `bson:"bitRate,omitempty" json:"bitRate,omitempty"`
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Channels that can be transcribed on their own.
+const (
+	ChannelMix   = "mix"
+	ChannelLeft  = "left"
+	ChannelRight = "right"
+)
+
+// PreprocessOptions are the steps applied to the audio before it is sent to
+// the ASR. When they are set, or when only a range of the media is transcribed,
+// the media is converted to 16 kHz mono WAV, so the video is never sent.
+type PreprocessOptions struct {
+	// Normalize makes the loudness even, for quiet or uneven recordings.
+	Normalize bool `bson:"normalize" json:"normalize"`
+	// Highpass removes the frequencies below this cutoff in Hz, such as hum or
+	// wind noise. It is off if 0.
+	Highpass int `bson:"highpass,omitempty" json:"highpass,omitempty"`
+	// Denoise reduces steady background noise.
+	Denoise bool `bson:"denoise" json:"denoise"`
+	// Channel is the channel to transcribe, `left` or `right`, or `mix` (the
+	// default) to mix all of them.
+	Channel string `bson:"channel,omitempty" json:"channel,omitempty"`
+	// TrimSilence cuts the silence at the start and the end of the media.
+	// Silences in between are kept, so that timestamps stay in sync.
+	TrimSilence bool `bson:"trimSilence" json:"trimSilence"`
+}
+
+// Validate checks the options against the media they are applied to, if known.
+func (o PreprocessOptions) Validate(media *MediaInfo) error {
+	if o.Highpass < 0 || o.Highpass > 4000 {
+		return errors.New("highpass must be between 0 and 4000 Hz")
+	}
+	switch o.Channel {
+	case "", ChannelMix, ChannelLeft:
+	case ChannelRight:
+		if media != nil && media.Audio != nil && media.Audio.Channels < 2 {
+			return errors.New("the media has a single channel")
+		}
+	default:
+		return fmt.Errorf("unknown channel %v", o.Channel)
+	}
+	return nil
+}
+
+// TimeRange is a part of the media, in seconds. End is 0 for the end of the media.
+type TimeRange struct {
+	Start float64 `bson:"start" json:"start"`
+	End   float64 `bson:"end,omitempty" json:"end,omitempty"`
+}
+
+// Validate checks the range against the media it is taken from, if known.
+func (r TimeRange) Validate(media *MediaInfo) error {
+	if r.Start < 0 || r.End < 0 {
+		return errors.New("the range cannot be negative")
+	}
+	if r.End > 0 && r.End <= r.Start {
+		return errors.New("the range must end after it starts")
+	}
+	if media != nil && media.Duration > 0 {
+		if r.Start >= media.Duration {
+			return fmt.Errorf("the range starts after the end of the media, at %.2f", media.Duration)
+		}
+		if r.End > media.Duration {
+			return fmt.Errorf("the range ends after the end of the media, at %.2f", media.Duration)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import "errors"
+
+// Ways to redact the media.
+const (
+	RedactionSilence = "silence"
+	RedactionBleep   = "bleep"
+)
+
+// Redaction records the personal information removed from a transcription.
+type Redaction struct {
+	Spans []RedactedSpan `bson:"spans" json:"spans"`
+	// Media is how the redacted copy of the media is made, `silence` or `bleep`,
+	// or empty if there is none.
+	Media string `bson:"media,omitempty" json:"media,omitempty"`
+	// Status and FileName of the redacted copy of the media, in the storage.
+	Status   int    `bson:"status,omitempty" json:"status,omitempty"`
+	FileName string `bson:"fileName,omitempty" json:"fileName,omitempty"`
+}
+
+// ErrRedactionNotReady is returned for the media of a transcription whose
+// redacted copy is not made yet, or could not be made.
+var ErrRedactionNotReady = errors.New("the redacted copy of the media is not ready")
+
+// MediaFile returns the media file that copies and clips are made from. Once
+// a redacted copy of the media was asked for, it is the redacted copy, as the
+// original still holds the personal information.
+func (t *Transcription) MediaFile() (string, error) {
+	if t.Redaction == nil || t.Redaction.Media == "" {
+		return t.FileName, nil
+	}
+	if t.Redaction.Status != TranscriptionStatusDone || t.Redaction.FileName == "" {
+		return "", ErrRedactionNotReady
+	}
+	return t.Redaction.FileName, nil
+}
+
+// RedactedSpan is a redacted part of a segment of the result, or of a translation.
+type RedactedSpan struct {
+	Rule        string  `bson:"rule" json:"rule"`
+	Translation string  `bson:"translation,omitempty" json:"translation,omitempty"`
+	SegmentID   string  `bson:"segmentId" json:"segmentId"`
+	Start       float64 `bson:"start" json:"start"`
+	End         float64 `bson:"end" json:"end"`
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ways to add the subtitles to the media.
+const (
+	// RenderBurn draws a single subtitle track on the video.
+	RenderBurn = "burn"
+	// RenderSoft embeds subtitle tracks in the container, to be turned on and
+	// off in the player.
+	RenderSoft = "soft"
+)
+
+// Containers of the rendered media.
+const (
+	ContainerMp4 = "mp4"
+	ContainerMkv = "mkv"
+)
+
+// Render is a job that makes a copy of the media of a transcription with its
+// subtitles.
+type Render struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TranscriptionID primitive.ObjectID `bson:"transcriptionId" json:"transcriptionId"`
+	Mode            string             `bson:"mode" json:"mode"`
+	// Original adds the result as a subtitle track, and Translations add the
+	// translations to these target languages.
+	Original     bool     `bson:"original" json:"original"`
+	Translations []string `bson:"translations" json:"translations"`
+	// Style of the burned-in subtitles.
+	Style SubtitleStyle `bson:"style" json:"style"`
+	// Speakers prefixes every subtitle with its speaker name, if known.
+	Speakers  bool   `bson:"speakers" json:"speakers"`
+	Container string `bson:"container" json:"container"`
+	Status    int    `bson:"status" json:"status"`
+	Error     string `bson:"error,omitempty" json:"error,omitempty"`
+	// FileName of the rendered media, in the storage.
+	FileName  string    `bson:"fileName,omitempty" json:"fileName,omitempty"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// Tracks returns the number of subtitle tracks of the render.
+func (r *Render) Tracks() int {
+	n := len(r.Translations)
+	if r.Original {
+		n++
+	}
+	return n
+}
+
+// SubtitleStyle is the look of burned-in subtitles, as an ASS style. Sizes are
+// in pixels of a 1080p video, and are scaled to the actual video.
+type SubtitleStyle struct {
+	FontName string `bson:"fontName" json:"fontName"`
+	FontSize int    `bson:"fontSize" json:"fontSize"`
+	Bold     bool   `bson:"bold" json:"bold"`
+	Italic   bool   `bson:"italic" json:"italic"`
+	// Colors are given as #RRGGBB, or #RRGGBBAA with an opacity.
+	PrimaryColor string `bson:"primaryColor" json:"primaryColor"`
+	OutlineColor string `bson:"outlineColor" json:"outlineColor"`
+	BackColor    string `bson:"backColor" json:"backColor"`
+	// Box draws a box of the back color behind the text, instead of an outline.
+	// The outline is then the padding of the box.
+	Box     bool    `bson:"box" json:"box"`
+	Outline float64 `bson:"outline" json:"outline"`
+	Shadow  float64 `bson:"shadow" json:"shadow"`
+	// Alignment is the position on the screen, as on a numeric keypad: 2 is
+	// bottom center and 8 is top center.
+	Alignment int `bson:"alignment" json:"alignment"`
+	MarginV   int `bson:"marginV" json:"marginV"`
+}
+
+var colorRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}([0-9a-fA-F]{2})?$`)
+
+// WithDefaults returns the style with the unset values replaced by the defaults:
+// white Arial text with a black outline, at the bottom center.
+func (s SubtitleStyle) WithDefaults() SubtitleStyle {
+	if s.FontName == "" {
+		s.FontName = "Arial"
+	}
+	if s.FontSize == 0 {
+		s.FontSize = 48
+	}
+	if s.PrimaryColor == "" {
+		s.PrimaryColor = "#ffffff"
+	}
+	if s.OutlineColor == "" {
+		s.OutlineColor = "#000000"
+	}
+	if s.BackColor == "" {
+		s.BackColor = "#00000080"
+	}
+	if s.Outline == 0 {
+		s.Outline = 2
+	}
+	if s.Alignment == 0 {
+		s.Alignment = 2
+	}
+	if s.MarginV == 0 {
+		s.MarginV = 60
+	}
+	return s
+}
+
+// Validate checks the values of the style, which may still be unset.
+func (s SubtitleStyle) Validate() error {
+	if strings.ContainsAny(s.FontName, ",\r\n") {
+		return errors.New("invalid fontName")
+	}
+	if s.FontSize < 0 || s.FontSize > 300 {
+		return errors.New("fontSize must be between 1 and 300")
+	}
+	for _, c := range []string{s.PrimaryColor, s.OutlineColor, s.BackColor} {
+		if c != "" && !colorRegexp.MatchString(c) {
+			return fmt.Errorf("invalid color %v, it must be #RRGGBB or #RRGGBBAA", c)
+		}
+	}
+	if s.Outline < 0 || s.Outline > 20 || s.Shadow < 0 || s.Shadow > 20 {
+		return errors.New("outline and shadow must be between 0 and 20")
+	}
+	if s.Alignment < 0 || s.Alignment > 9 {
+		return errors.New("alignment must be between 1 and 9")
+	}
+	if s.MarginV < 0 || s.MarginV > 1000 {
+		return errors.New("marginV must be between 0 and 1000")
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ReplaceOptions select what a find and replace looks for.
+type ReplaceOptions struct {
+	Find    string `json:"find"`
+	Replace string `json:"replace"`
+	// Regex treats Find as a regular expression, and allows $1-style group
+	// references in Replace.
+	Regex bool `json:"regex"`
+	// WholeWord only matches when the match is not part of a longer word.
+	WholeWord     bool `json:"wholeWord"`
+	CaseSensitive bool `json:"caseSensitive"`
+}
+
+// ReplaceMatch is a segment changed by a find and replace.
+type ReplaceMatch struct {
+	Translation string `json:"translation,omitempty"`
+	SegmentID   string `json:"segmentId"`
+	Count       int    `json:"count"`
+	Before      string `json:"before"`
+	After       string `json:"after"`
+}
+
+// Replacer runs a find and replace on the text and the words of results.
+type Replacer struct {
+	re        *regexp.Regexp
+	replace   string
+	regex     bool
+	wholeWord bool
+}
+
+func NewReplacer(o ReplaceOptions) (*Replacer, error) {
+	pattern := o.Find
+	if !o.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if !o.CaseSensitive {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &Replacer{re: re, replace: o.Replace, regex: o.Regex, wholeWord: o.WholeWord}, nil
+}
+
+// matches returns the non-empty matches in text, as submatch indexes.
+func (r *Replacer) matches(text string) [][]int {
+	var matches [][]int
+	for _, m := range r.re.FindAllStringSubmatchIndex(text, -1) {
+		if m[0] == m[1] {
+			continue
+		}
+		if r.wholeWord && (isWordChar(lastRune(text[:m[0]])) || isWordChar(firstRune(text[m[1]:]))) {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// expand returns the replacement for a match in text.
+func (r *Replacer) expand(text string, m []int) string {
+	if !r.regex {
+		return r.replace
+	}
+	return string(r.re.ExpandString(nil, r.replace, text, m))
+}
+
+// ReplaceString replaces all matches in text, and returns the number of matches.
+func (r *Replacer) ReplaceString(text string) (string, int) {
+	matches := r.matches(text)
+	if len(matches) == 0 {
+		return text, 0
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(text[last:m[0]])
+		b.WriteString(r.expand(text, m))
+		last = m[1]
+	}
+	b.WriteString(text[last:])
+	return b.String(), len(matches)
+}
+
+// ReplaceResult replaces all matches in the segments of a result, keeping the
+// text of the segments, their words and the result consistent. It returns the
+// changed segments, labelled with the given translation.
+func (r *Replacer) ReplaceResult(res *WhisperResult, translation string) []ReplaceMatch {
+	var changed []ReplaceMatch
+	for i := range res.Segments {
+		seg := &res.Segments[i]
+		before := seg.Text
+		var count int
+		if len(seg.Words) > 0 && strings.TrimSpace(wordsText(seg.Words)) == strings.TrimSpace(seg.Text) {
+			// The text is made of the words: replace in the words and rebuild it.
+			seg.Words, count = r.replaceWords(seg.Words)
+			seg.Text = wordsText(seg.Words)
+		} else {
+			seg.Text, count = r.ReplaceString(seg.Text)
+			seg.Words, _ = r.replaceWords(seg.Words)
+		}
+		if count > 0 {
+			changed = append(changed, ReplaceMatch{
+				Translation: translation,
+				SegmentID:   seg.ID,
+				Count:       count,
+				Before:      strings.TrimSpace(before),
+				After:       strings.TrimSpace(seg.Text),
+			})
+		}
+	}
+	if len(changed) > 0 {
+		res.Text = TextFromSegments(res.Segments)
+	}
+	return changed
+}
+
+// replaceWords replaces the matches in the text made by joining the words. A
+// match that spans several words merges them into one word, timed from the
+// start of the first to the end of the last. Words left empty are dropped.
+func (r *Replacer) replaceWords(words []Word) ([]Word, int) {
+	joined := wordsText(words)
+	matches := r.matches(joined)
+	if len(matches) == 0 {
+		return words, 0
+	}
+	starts := make([]int, len(words)+1)
+	for i, w := range words {
+		starts[i+1] = starts[i] + len(w.Word)
+	}
+	// wordAt returns the index of the word containing the byte at offset.
+	wordAt := func(offset int) int {
+		for i := range words {
+			if offset < starts[i+1] {
+				return i
+			}
+		}
+		return len(words) - 1
+	}
+
+	result := make([]Word, 0, len(words))
+	next := 0 // first word not yet copied
+	for k := 0; k < len(matches); {
+		first, last := wordAt(matches[k][0]), wordAt(matches[k][1]-1)
+		// Take all the following matches that touch the same words
+		end := k + 1
+		for end < len(matches) && wordAt(matches[end][0]) <= last {
+			last = wordAt(matches[end][1] - 1)
+			end++
+		}
+		result = append(result, words[next:first]...)
+
+		var b strings.Builder
+		pos := starts[first]
+		for _, m := range matches[k:end] {
+			b.WriteString(joined[pos:m[0]])
+			b.WriteString(r.expand(joined, m))
+			pos = m[1]
+		}
+		b.WriteString(joined[pos:starts[last+1]])
+
+		merged := words[first]
+		merged.Word = b.String()
+		merged.End = words[last].End
+		for _, w := range words[first+1 : last+1] {
+			if w.Score < merged.Score {
+				merged.Score = w.Score
+			}
+		}
+		if strings.TrimSpace(merged.Word) != "" {
+			result = append(result, merged)
+		}
+		next = last + 1
+		k = end
+	}
+	result = append(result, words[next:]...)
+	return result, len(matches)
+}
+
+func isWordChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
+
+func firstRune(s string) rune {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r
+}
+
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReplaceString(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ReplaceOptions
+		text    string
+		want    string
+		count   int
+	}{
+		{"plain", ReplaceOptions{Find: "hello", Replace: "hi"}, "Hello HELLO", "hi hi", 2},
+		{"case sensitive", ReplaceOptions{Find: "Hello", Replace: "hi", CaseSensitive: true}, "Hello HELLO", "hi HELLO", 1},
+		{"literal", ReplaceOptions{Find: "a.b", Replace: "$1"}, "a.b axb", "$1 axb", 1},
+		{"regex groups", ReplaceOptions{Find: `(\w+)@(\w+)`, Replace: "$2 at $1", Regex: true},
+			"mail bob@home now", "mail home at bob now", 1},
+		{"named groups", ReplaceOptions{Find: `(?P<n>\d+)%`, Replace: "${n} percent", Regex: true},
+			"up 5% and 10%", "up 5 percent and 10 percent", 2},
+		{"empty matches", ReplaceOptions{Find: "x*", Replace: "y", Regex: true}, "ab", "ab", 0},
+		{"whole word", ReplaceOptions{Find: "cat", Replace: "dog", WholeWord: true},
+			"cat concat cats cat.", "dog concat cats dog.", 2},
+		{"whole word with accents", ReplaceOptions{Find: "caf", Replace: "bar", WholeWord: true},
+			"café caf", "café bar", 1},
+		{"whole word regex", ReplaceOptions{Find: `\d+`, Replace: "N", Regex: true, WholeWord: true},
+			"a1 22 b3", "a1 N b3", 1},
+	}
+	for _, tt := range tests {
+		r, err := NewReplacer(tt.options)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		got, count := r.ReplaceString(tt.text)
+		if got != tt.want || count != tt.count {
+			t.Errorf("%v: got %q (%d), want %q (%d)", tt.name, got, count, tt.want, tt.count)
+		}
+	}
+}
+
+func TestNewReplacerInvalid(t *testing.T) {
+	if _, err := NewReplacer(ReplaceOptions{Find: "(", Regex: true}); err == nil {
+		t.Error("an invalid regex was accepted")
+	}
+	if _, err := NewReplacer(ReplaceOptions{Find: "("}); err != nil {
+		t.Errorf("a literal parenthesis was rejected: %v", err)
+	}
+}
+
+func TestReplaceResultWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ReplaceOptions
+		text    string
+		// words are given as "word start-end score"
+		words []string
+	}{
+		{"in a word", ReplaceOptions{Find: "big", Replace: "small"},
+			" Hello, small world.", []string{" Hello, 0-1 0.9", " small 1-2 0.5", " world. 2-3 0.8"}},
+		{"across words", ReplaceOptions{Find: "big world", Replace: "small planet"},
+			" Hello, small planet.", []string{" Hello, 0-1 0.9", " small planet. 1-3 0.5"}},
+		{"regex groups across words", ReplaceOptions{Find: `(\w+), (\w+)`, Replace: "$2, $1", Regex: true},
+			" big, Hello world.", []string{" big, Hello 0-2 0.5", " world. 2-3 0.8"}},
+		{"several matches in the merged words", ReplaceOptions{Find: `, b|g`, Replace: "_", Regex: true},
+			" Hello_i_ world.", []string{" Hello_i_ 0-2 0.5", " world. 2-3 0.8"}},
+		{"removed word", ReplaceOptions{Find: " big", Replace: ""},
+			" Hello, world.", []string{" Hello, 0-1 0.9", " world. 2-3 0.8"}},
+	}
+	for _, tt := range tests {
+		res := segmentsResult()
+		res.Segments[0].Words[0].Score = 0.9
+		res.Segments[0].Words[1].Score = 0.5
+		res.Segments[0].Words[2].Score = 0.8
+		r, err := NewReplacer(tt.options)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		changed := r.ReplaceResult(res, "fr")
+		seg := res.Segments[0]
+		if seg.Text != tt.text {
+			t.Errorf("%v: got text %q, want %q", tt.name, seg.Text, tt.text)
+		}
+		var words []string
+		for _, w := range seg.Words {
+			words = append(words, fmt.Sprintf("%v %v-%v %v", w.Word, w.Start, w.End, w.Score))
+		}
+		if fmt.Sprintf("%q", words) != fmt.Sprintf("%q", tt.words) {
+			t.Errorf("%v: got words %q, want %q", tt.name, words, tt.words)
+		}
+		if len(changed) != 1 || changed[0].SegmentID != "a" || changed[0].Translation != "fr" ||
+			changed[0].Before != "Hello, big world." || changed[0].After != tt.text[1:] {
+			t.Errorf("%v: changed %+v", tt.name, changed)
+		}
+		if want := tt.text[1:] + " How are you? 你好世界"; res.Text != want {
+			t.Errorf("%v: got result text %q, want %q", tt.name, res.Text, want)
+		}
+	}
+}
+
+// A segment whose text was edited away from its words is replaced in both.
+func TestReplaceResultEditedText(t *testing.T) {
+	res := segmentsResult()
+	res.Segments[0].Text = " Hello, big big world."
+	r, err := NewReplacer(ReplaceOptions{Find: "big", Replace: "small", WholeWord: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := r.ReplaceResult(res, "")
+	if seg := res.Segments[0]; seg.Text != " Hello, small small world." || wordsText(seg.Words) != " Hello, small world." {
+		t.Errorf("got text %q, words %q", seg.Text, wordsText(seg.Words))
+	}
+	if len(changed) != 1 || changed[0].Count != 2 {
+		t.Errorf("changed %+v", changed)
+	}
+}
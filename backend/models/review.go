@@ -0,0 +1,88 @@
+package models
+
+import "strings"
+
+// ReviewItem is a segment, or a word of a segment, recognized with a confidence
+// below the review threshold.
+type ReviewItem struct {
+	SegmentID string `json:"segmentId"`
+	// Word is the index of the word in the segment, or -1 for the whole segment.
+	Word     int     `json:"word"`
+	Text     string  `json:"text"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
+	Score    float64 `json:"score"`
+	Verified bool    `json:"verified"`
+}
+
+// ReviewProgress counts the low confidence items of a transcription, and how
+// many of them were verified.
+type ReviewProgress struct {
+	Total    int     `json:"total"`
+	Verified int     `json:"verified"`
+	Percent  float64 `json:"percent"`
+}
+
+// ReviewItems returns the segments and words of the result with a score below
+// the threshold, in order. A word is only listed on its own if its segment is
+// not listed.
+func (r *WhisperResult) ReviewItems(threshold float64) []ReviewItem {
+	items := []ReviewItem{}
+	for _, seg := range r.Segments {
+		if seg.Score < threshold {
+			items = append(items, ReviewItem{SegmentID: seg.ID, Word: -1,
+				Text: strings.TrimSpace(seg.Text), Start: seg.Start, End: seg.End, Score: seg.Score, Verified: seg.Verified})
+			continue
+		}
+		for k, w := range seg.Words {
+			if w.Score < threshold {
+				items = append(items, ReviewItem{SegmentID: seg.ID, Word: k,
+					Text: strings.TrimSpace(w.Word), Start: w.Start, End: w.End, Score: w.Score, Verified: w.Verified})
+			}
+		}
+	}
+	return items
+}
+
+// ReviewItems returns the low confidence items of the result of the
+// transcription. Translations are not reviewed, as their scores are the ones of
+// the segments they were translated from, and say nothing of the translated text.
+func (t *Transcription) ReviewItems(threshold float64) []ReviewItem {
+	return t.Result.ReviewItems(threshold)
+}
+
+// Progress counts the verified items.
+func Progress(items []ReviewItem) ReviewProgress {
+	p := ReviewProgress{Total: len(items), Percent: 100}
+	for _, item := range items {
+		if item.Verified {
+			p.Verified++
+		}
+	}
+	if p.Total > 0 {
+		p.Percent = float64(p.Verified) * 100 / float64(p.Total)
+	}
+	return p
+}
+
+// SetVerified marks a segment, with all its words, or a single word of it as
+// verified or not.
+func (r *WhisperResult) SetVerified(segmentId string, word int, verified bool) error {
+	i := r.IndexOf(segmentId)
+	if i < 0 {
+		return ErrSegmentNotFound
+	}
+	seg := &r.Segments[i]
+	if word < 0 {
+		seg.Verified = verified
+		for k := range seg.Words {
+			seg.Words[k].Verified = verified
+		}
+		return nil
+	}
+	if word >= len(seg.Words) {
+		return ErrInvalidSegment
+	}
+	seg.Words[word].Verified = verified
+	return nil
+}
@@ -0,0 +1,260 @@
+package models
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// ErrRevisionBaseMissing is returned when the full revision a partial revision
+// is rebuilt from was deleted.
+var ErrRevisionBaseMissing = errors.New("the revision the changes apply to was deleted")
+
+// Revision is a change of the result and translations of a transcription, with
+// the diff from their previous state. Full revisions hold a snapshot of the
+// result and translations, while partial ones only hold the diff, and the result
+// and translations without their segments.
+type Revision struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TranscriptionID primitive.ObjectID `bson:"transcriptionId" json:"transcriptionId"`
+	Author          string             `bson:"author" json:"author"`
+	Action          string             `bson:"action" json:"action"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	Diff            []SegmentDiff      `bson:"diff" json:"diff"`
+	Result          *WhisperResult     `bson:"result,omitempty" json:"result,omitempty"`
+	Translations    []Translation      `bson:"translations,omitempty" json:"translations,omitempty"`
+	// Partial is set for revisions whose segments are rebuilt from the last full
+	// revision before them, by applying the diffs of the revisions since.
+	Partial bool `bson:"partial,omitempty" json:"-"`
+}
+
+// WithoutSegments returns a copy of a result and translations without their
+// segments and text, as stored in partial revisions.
+func WithoutSegments(result WhisperResult, translations []Translation) (WhisperResult, []Translation) {
+	result.Segments, result.Text = nil, ""
+	stripped := make([]Translation, len(translations))
+	for i, tr := range translations {
+		tr.Result.Segments, tr.Result.Text = nil, ""
+		stripped[i] = tr
+	}
+	return result, stripped
+}
+
+// RebuildRevision returns the result and translations of the last of the given
+// revisions, which are sorted from the oldest, and start with a full revision.
+func RebuildRevision(revisions []*Revision) (*WhisperResult, []Translation, error) {
+	if len(revisions) == 0 || revisions[0].Partial || revisions[0].Result == nil {
+		return nil, nil, ErrRevisionBaseMissing
+	}
+	last := revisions[len(revisions)-1]
+	if !last.Partial {
+		result := CopyResult(*last.Result)
+		return &result, CopyTranslations(last.Translations), nil
+	}
+
+	segments := map[string][]Segment{"": CopyResult(*revisions[0].Result).Segments}
+	for _, tr := range revisions[0].Translations {
+		segments[tr.TargetLanguage] = CopyResult(tr.Result).Segments
+	}
+	for _, r := range revisions[1:] {
+		applyDiff(segments, r.Diff)
+	}
+
+	result := WhisperResult{}
+	if last.Result != nil {
+		result = *last.Result
+	}
+	result.Segments = withSegments(segments[""])
+	result.Text = TextFromSegments(result.Segments)
+	translations := make([]Translation, len(last.Translations))
+	for i, tr := range last.Translations {
+		tr.Result.Segments = withSegments(segments[tr.TargetLanguage])
+		tr.Result.Text = TextFromSegments(tr.Result.Segments)
+		translations[i] = tr
+	}
+	return &result, translations, nil
+}
+
+// applyDiff applies the changes of a diff to the segments of a result and its
+// translations, by target language. Added segments are inserted in start time
+// order, and changed ones are replaced in place.
+func applyDiff(segments map[string][]Segment, diff []SegmentDiff) {
+	for _, d := range diff {
+		segs := segments[d.Translation]
+		i := -1
+		for k := range segs {
+			if segs[k].ID == d.SegmentID {
+				i = k
+				break
+			}
+		}
+		switch {
+		case d.Op == DiffRemoved:
+			if i >= 0 {
+				segs = append(segs[:i:i], segs[i+1:]...)
+			}
+		case d.After == nil:
+		case i >= 0:
+			segs[i] = copySegment(*d.After)
+		default:
+			seg := copySegment(*d.After)
+			k := sort.Search(len(segs), func(k int) bool { return segs[k].Start > seg.Start })
+			segs = append(segs[:k:k], append([]Segment{seg}, segs[k:]...)...)
+		}
+		segments[d.Translation] = segs
+	}
+}
+
+func copySegment(seg Segment) Segment {
+	if seg.Words != nil {
+		seg.Words = append([]Word{}, seg.Words...)
+	}
+	return seg
+}
+
+func withSegments(segments []Segment) []Segment {
+	if segments == nil {
+		return []Segment{}
+	}
+	return segments
+}
+
+// SegmentDiff is a change to a segment of the result, or of a translation if
+// Translation is set to its target language.
+type SegmentDiff struct {
+	Op          string   `bson:"op" json:"op"`
+	Translation string   `bson:"translation,omitempty" json:"translation,omitempty"`
+	SegmentID   string   `bson:"segmentId" json:"segmentId"`
+	Before      *Segment `bson:"before,omitempty" json:"before,omitempty"`
+	After       *Segment `bson:"after,omitempty" json:"after,omitempty"`
+}
+
+// DiffTranscription returns the segment changes between two states of the
+// result and translations of a transcription.
+func DiffTranscription(beforeResult *WhisperResult, beforeTranslations []Translation, afterResult *WhisperResult, afterTranslations []Translation) []SegmentDiff {
+	diff := DiffResults("", beforeResult, afterResult)
+	before := make(map[string]*WhisperResult)
+	for i := range beforeTranslations {
+		before[beforeTranslations[i].TargetLanguage] = &beforeTranslations[i].Result
+	}
+	after := make(map[string]bool)
+	for i := range afterTranslations {
+		lang := afterTranslations[i].TargetLanguage
+		after[lang] = true
+		diff = append(diff, DiffResults(lang, before[lang], &afterTranslations[i].Result)...)
+	}
+	for i := range beforeTranslations {
+		lang := beforeTranslations[i].TargetLanguage
+		if !after[lang] {
+			diff = append(diff, DiffResults(lang, &beforeTranslations[i].Result, nil)...)
+		}
+	}
+	return diff
+}
+
+// DiffResults returns the segment changes between two results. Segments are
+// matched by ID; either result may be nil.
+func DiffResults(translation string, before, after *WhisperResult) []SegmentDiff {
+	diff := []SegmentDiff{}
+	old := make(map[string]*Segment)
+	if before != nil {
+		for i := range before.Segments {
+			old[before.Segments[i].ID] = &before.Segments[i]
+		}
+	}
+	seen := make(map[string]bool)
+	if after != nil {
+		for i := range after.Segments {
+			seg := &after.Segments[i]
+			seen[seg.ID] = true
+			prev, ok := old[seg.ID]
+			switch {
+			case !ok:
+				diff = append(diff, SegmentDiff{Op: DiffAdded, Translation: translation, SegmentID: seg.ID, After: seg})
+			case !sameSegment(prev, seg):
+				diff = append(diff, SegmentDiff{Op: DiffChanged, Translation: translation, SegmentID: seg.ID, Before: prev, After: seg})
+			}
+		}
+	}
+	if before != nil {
+		for i := range before.Segments {
+			seg := &before.Segments[i]
+			if !seen[seg.ID] {
+				diff = append(diff, SegmentDiff{Op: DiffRemoved, Translation: translation, SegmentID: seg.ID, Before: seg})
+			}
+		}
+	}
+	return diff
+}
+
+func sameSegment(a, b *Segment) bool {
+	if a.Text != b.Text || a.Start != b.Start || a.End != b.End || a.Speaker != b.Speaker || len(a.Words) != len(b.Words) {
+		return false
+	}
+	for i := range a.Words {
+		if a.Words[i] != b.Words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// CopyResult returns a deep copy of a result, so that it is not modified by
+// later edits of the original.
+func CopyResult(r WhisperResult) WhisperResult {
+	segments := make([]Segment, len(r.Segments))
+	for i, seg := range r.Segments {
+		segments[i] = copySegment(seg)
+	}
+	r.Segments = segments
+	return r
+}
+
+// CopyTranslations returns a deep copy of the translations.
+func CopyTranslations(translations []Translation) []Translation {
+	copied := make([]Translation, len(translations))
+	for i, tr := range translations {
+		tr.Result = CopyResult(tr.Result)
+		copied[i] = tr
+	}
+	return copied
+}
+
+// Copy returns a copy of the transcription whose result and translations can
+// be kept as they are while the original is edited.
+func (t *Transcription) Copy() *Transcription {
+	c := *t
+	c.Result = CopyResult(t.Result)
+	c.Translations = CopyTranslations(t.Translations)
+	c.Speakers = append([]Speaker(nil), t.Speakers...)
+	if t.Preprocess != nil {
+		p := *t.Preprocess
+		c.Preprocess = &p
+	}
+	if t.Range != nil {
+		r := *t.Range
+		c.Range = &r
+	}
+	if t.Watch != nil {
+		w := *t.Watch
+		c.Watch = &w
+	}
+	if t.Media != nil {
+		m := *t.Media
+		c.Media = &m
+	}
+	if t.Redaction != nil {
+		r := *t.Redaction
+		r.Spans = append([]RedactedSpan(nil), t.Redaction.Spans...)
+		c.Redaction = &r
+	}
+	return &c
+}
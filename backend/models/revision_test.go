@@ -0,0 +1,116 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// revisionHistory applies a series of edits to a transcription, and returns the
+// revisions recording them, the first one full and the others partial, with the
+// states after each of them.
+func revisionHistory() ([]*Revision, []*Transcription) {
+	t := &Transcription{
+		Result: WhisperResult{Language: "en", Segments: []Segment{
+			{ID: "a", Start: 0, End: 1, Text: " One.", Words: []Word{{Word: " One.", Start: 0, End: 1}}},
+			{ID: "b", Start: 1, End: 2, Text: " Two and two."},
+			{ID: "c", Start: 2, End: 3, Text: " Three."},
+		}},
+		Translations: []Translation{},
+	}
+	t.Result.Text = TextFromSegments(t.Result.Segments)
+	edits := []func(t *Transcription){
+		func(t *Transcription) { t.Result.EditSegmentText("a", "Uno.") },
+		func(t *Transcription) { t.Result.SplitSegment("b", 1.5, -1) },
+		func(t *Transcription) { t.Result.DeleteSegment("c") },
+		func(t *Transcription) {
+			t.Result.InsertSegment(Segment{Start: 0.5, End: 0.8, Text: " Half."})
+		},
+		func(t *Transcription) {
+			t.Translations = append(t.Translations, Translation{SourceLanguage: "en", TargetLanguage: "es",
+				Result: WhisperResult{Language: "es", Text: "Uno.", Segments: []Segment{{ID: "x", Start: 0, End: 1, Text: " Uno."}}}})
+		},
+		func(t *Transcription) {
+			t.Result.MergeSegments([]string{t.Result.Segments[0].ID, t.Result.Segments[1].ID})
+		},
+		func(t *Transcription) { t.Translations[0].Result.EditSegmentText("x", "Uno!") },
+		func(t *Transcription) { t.Translations = []Translation{} },
+	}
+
+	result := CopyResult(t.Result)
+	revisions := []*Revision{{Result: &result, Translations: CopyTranslations(t.Translations)}}
+	states := []*Transcription{t.Copy()}
+	for _, edit := range edits {
+		before := t.Copy()
+		edit(t)
+		after := t.Copy()
+		result, translations := WithoutSegments(after.Result, after.Translations)
+		revisions = append(revisions, &Revision{
+			Diff:         DiffTranscription(&before.Result, before.Translations, &after.Result, after.Translations),
+			Result:       &result,
+			Translations: translations,
+			Partial:      true,
+		})
+		states = append(states, t.Copy())
+	}
+	return revisions, states
+}
+
+func TestRebuildRevision(t *testing.T) {
+	revisions, states := revisionHistory()
+	for i := range revisions {
+		result, translations, err := RebuildRevision(revisions[:i+1])
+		if err != nil {
+			t.Fatalf("revision %d: %v", i, err)
+		}
+		want := states[i]
+		if got, want := fmt.Sprintf("%+v", result.Segments), fmt.Sprintf("%+v", want.Result.Segments); got != want {
+			t.Errorf("revision %d: got segments\n%v\nwant\n%v", i, got, want)
+		}
+		if result.Text != want.Result.Text || result.Language != "en" {
+			t.Errorf("revision %d: got text %q, want %q", i, result.Text, want.Result.Text)
+		}
+		if got, want := fmt.Sprintf("%+v", translations), fmt.Sprintf("%+v", want.Translations); got != want {
+			t.Errorf("revision %d: got translations\n%v\nwant\n%v", i, got, want)
+		}
+	}
+}
+
+func TestRebuildRevisionFromLaterSnapshot(t *testing.T) {
+	revisions, states := revisionHistory()
+	// A full revision in the middle of the history
+	full := revisions[4]
+	full.Result = &states[4].Result
+	full.Translations = states[4].Translations
+	full.Partial = false
+	result, _, err := RebuildRevision(revisions[4:6])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprintf("%+v", result.Segments), fmt.Sprintf("%+v", states[5].Result.Segments); got != want {
+		t.Errorf("got segments\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestRebuildRevisionWithoutBase(t *testing.T) {
+	revisions, _ := revisionHistory()
+	if _, _, err := RebuildRevision(revisions[1:3]); !errors.Is(err, ErrRevisionBaseMissing) {
+		t.Errorf("got %v", err)
+	}
+	if _, _, err := RebuildRevision(nil); !errors.Is(err, ErrRevisionBaseMissing) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRebuildRevisionDoesNotChangeHistory(t *testing.T) {
+	revisions, _ := revisionHistory()
+	before := fmt.Sprintf("%+v", *revisions[0].Result)
+	result, _, err := RebuildRevision(revisions[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.Segments[0].Text = " Changed"
+	if after := fmt.Sprintf("%+v", *revisions[0].Result); after != before {
+		t.Errorf("the snapshot was changed:\n%v\n%v", before, after)
+	}
+}
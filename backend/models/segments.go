@@ -0,0 +1,289 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrSegmentNotFound = errors.New("segment not found")
+	ErrInvalidSegment  = errors.New("invalid segment")
+	ErrSegmentOverlap  = errors.New("the segment would overlap its neighbours")
+)
+
+// IndexOf returns the position of the segment with the given ID, or -1.
+func (r *WhisperResult) IndexOf(id string) int {
+	for i, seg := range r.Segments {
+		if seg.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// SplitSegment splits a segment in two. If the segment has word data, it is split
+// before the word with the given index, or before the first word starting at or
+// after the given time if word is negative, and each half keeps its words and
+// their timings. Otherwise the text is split in proportion to the segment
+// duration: at the word boundary closest to the time, or at a character for
+// text without spaces such as Chinese or Thai, where word is the index of the
+// character. It returns the two resulting segments.
+func (r *WhisperResult) SplitSegment(id string, at float64, word int) ([]Segment, error) {
+	i := r.IndexOf(id)
+	if i < 0 {
+		return nil, ErrSegmentNotFound
+	}
+	seg := r.Segments[i]
+	left, right := seg, seg
+	right.ID = NewSegmentID()
+
+	if len(seg.Words) > 0 {
+		if word < 0 {
+			word = len(seg.Words)
+			for k, w := range seg.Words {
+				if w.Start >= at {
+					word = k
+					break
+				}
+			}
+		}
+		if word <= 0 || word >= len(seg.Words) {
+			return nil, ErrInvalidSegment
+		}
+		words := append([]Word{}, seg.Words...)
+		if tokens := strings.Fields(seg.Text); len(tokens) == len(words) && strings.TrimSpace(wordsText(words)) != strings.TrimSpace(seg.Text) {
+			// The text was edited word for word: the words take its spelling
+			for k := range words {
+				words[k].Word = " " + tokens[k]
+			}
+		}
+		left.Words = words[:word:word]
+		right.Words = words[word:]
+		left.Text = wordsText(left.Words)
+		right.Text = wordsText(right.Words)
+		left.End = left.Words[len(left.Words)-1].End
+		right.Start = right.Words[0].Start
+	} else {
+		if at <= seg.Start || at >= seg.End {
+			return nil, ErrInvalidSegment
+		}
+		tokens := strings.Fields(seg.Text)
+		sep := " "
+		if len(tokens) == 1 && withoutSpaces(tokens[0]) {
+			tokens = characters(tokens[0])
+			sep = ""
+		}
+		if word < 0 {
+			word = int(float64(len(tokens))*(at-seg.Start)/(seg.End-seg.Start) + 0.5)
+		}
+		if word <= 0 || word >= len(tokens) {
+			return nil, ErrInvalidSegment
+		}
+		left.Text = " " + strings.Join(tokens[:word], sep)
+		right.Text = " " + strings.Join(tokens[word:], sep)
+		left.Words = []Word{}
+		right.Words = []Word{}
+		left.End = at
+		right.Start = at
+	}
+
+	r.Segments = append(r.Segments[:i+1], r.Segments[i:]...)
+	r.Segments[i] = left
+	r.Segments[i+1] = right
+	r.Text = TextFromSegments(r.Segments)
+	return []Segment{left, right}, nil
+}
+
+// MergeSegments merges adjacent segments into the first one. It returns the
+// merged segment, and the IDs of the removed ones.
+func (r *WhisperResult) MergeSegments(ids []string) (*Segment, []string, error) {
+	if len(ids) < 2 {
+		return nil, nil, ErrInvalidSegment
+	}
+	positions := make([]int, len(ids))
+	for k, id := range ids {
+		positions[k] = r.IndexOf(id)
+		if positions[k] < 0 {
+			return nil, nil, ErrSegmentNotFound
+		}
+	}
+	sort.Ints(positions)
+	for k := 1; k < len(positions); k++ {
+		if positions[k] != positions[k-1]+1 {
+			return nil, nil, ErrInvalidSegment
+		}
+	}
+
+	first, last := positions[0], positions[len(positions)-1]
+	merged := r.Segments[first]
+	merged.Words = append([]Word{}, merged.Words...)
+	var removed []string
+	var score float64
+	texts := []string{}
+	for _, seg := range r.Segments[first : last+1] {
+		if text := strings.TrimSpace(seg.Text); text != "" {
+			texts = append(texts, text)
+		}
+		if seg.End > merged.End {
+			merged.End = seg.End
+		}
+		score += seg.Score
+		if seg.ID != merged.ID {
+			merged.Words = append(merged.Words, seg.Words...)
+			removed = append(removed, seg.ID)
+		}
+	}
+	merged.Text = " " + strings.Join(texts, " ")
+	merged.Score = score / float64(len(positions))
+
+	r.Segments[first] = merged
+	r.Segments = append(r.Segments[:first+1], r.Segments[last+1:]...)
+	r.Text = TextFromSegments(r.Segments)
+	return &merged, removed, nil
+}
+
+// InsertSegment adds a new segment, keeping the segments sorted by start time.
+func (r *WhisperResult) InsertSegment(seg Segment) (*Segment, error) {
+	if seg.End <= seg.Start || seg.Start < 0 {
+		return nil, ErrInvalidSegment
+	}
+	seg.ID = NewSegmentID()
+	if seg.Words == nil {
+		seg.Words = []Word{}
+	}
+	i := sort.Search(len(r.Segments), func(k int) bool { return r.Segments[k].Start > seg.Start })
+	r.Segments = append(r.Segments, Segment{})
+	copy(r.Segments[i+1:], r.Segments[i:])
+	r.Segments[i] = seg
+	r.Text = TextFromSegments(r.Segments)
+	return &r.Segments[i], nil
+}
+
+// DeleteSegment removes a segment.
+func (r *WhisperResult) DeleteSegment(id string) error {
+	i := r.IndexOf(id)
+	if i < 0 {
+		return ErrSegmentNotFound
+	}
+	r.Segments = append(r.Segments[:i], r.Segments[i+1:]...)
+	r.Text = TextFromSegments(r.Segments)
+	return nil
+}
+
+// RetimeSegment changes the start and/or end time of a segment. Words are
+// clamped to the new boundaries. The segment cannot be moved over its
+// neighbours, so that the segments stay in order; an overlap they already had
+// may only be reduced.
+func (r *WhisperResult) RetimeSegment(id string, start, end *float64) (*Segment, error) {
+	i := r.IndexOf(id)
+	if i < 0 {
+		return nil, ErrSegmentNotFound
+	}
+	seg := &r.Segments[i]
+	newStart, newEnd := seg.Start, seg.End
+	if start != nil {
+		newStart = *start
+	}
+	if end != nil {
+		newEnd = *end
+	}
+	if newEnd <= newStart || newStart < 0 {
+		return nil, ErrInvalidSegment
+	}
+	if i > 0 && newStart < math.Min(r.Segments[i-1].End, seg.Start) {
+		return nil, ErrSegmentOverlap
+	}
+	if i < len(r.Segments)-1 && newEnd > math.Max(r.Segments[i+1].Start, seg.End) {
+		return nil, ErrSegmentOverlap
+	}
+	seg.Start, seg.End = newStart, newEnd
+	for k := range seg.Words {
+		w := &seg.Words[k]
+		w.Start = clamp(w.Start, newStart, newEnd)
+		w.End = clamp(w.End, newStart, newEnd)
+	}
+	return seg, nil
+}
+
+// EditSegmentText replaces the text of a segment. If the new text has as many words
+// as the word data, the words keep their timings and take the new spelling.
+// Otherwise the word data no longer matches the text, and is dropped.
+func (r *WhisperResult) EditSegmentText(id, text string) (*Segment, error) {
+	i := r.IndexOf(id)
+	if i < 0 {
+		return nil, ErrSegmentNotFound
+	}
+	seg := &r.Segments[i]
+	fields := strings.Fields(text)
+	seg.Text = " " + strings.Join(fields, " ")
+	if len(fields) == len(seg.Words) {
+		for k := range seg.Words {
+			seg.Words[k].Word = " " + fields[k]
+		}
+	} else {
+		seg.Words = []Word{}
+	}
+	r.Text = TextFromSegments(r.Segments)
+	return seg, nil
+}
+
+// withoutSpaces tells if a text is written in a script that does not separate
+// words with spaces, such as Chinese, Japanese or Thai.
+func withoutSpaces(text string) bool {
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai, unicode.Lao,
+			unicode.Khmer, unicode.Myanmar, unicode.Tibetan) {
+			return true
+		}
+	}
+	return false
+}
+
+// characters splits a text between its characters, keeping combining marks
+// with the character they follow.
+func characters(text string) []string {
+	var chars []string
+	for _, r := range text {
+		if len(chars) > 0 && unicode.In(r, unicode.Mn, unicode.Mc) {
+			chars[len(chars)-1] += string(r)
+			continue
+		}
+		chars = append(chars, string(r))
+	}
+	return chars
+}
+
+func wordsText(words []Word) string {
+	var b strings.Builder
+	for _, w := range words {
+		b.WriteString(w.Word)
+	}
+	return b.String()
+}
+
+func clamp(v, min, max float64) float64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Shift moves all the timestamps of a result by an offset in seconds, for
+// results of a part of the media.
+func (r *WhisperResult) Shift(offset float64) {
+	for i := range r.Segments {
+		seg := &r.Segments[i]
+		seg.Start += offset
+		seg.End += offset
+		for j := range seg.Words {
+			seg.Words[j].Start += offset
+			seg.Words[j].End += offset
+		}
+	}
+}
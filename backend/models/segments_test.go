@@ -0,0 +1,264 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func segmentsResult() *WhisperResult {
+	return &WhisperResult{Segments: []Segment{
+		{ID: "a", Start: 0, End: 3, Score: 1, Text: " Hello, big world.", Words: []Word{
+			{Word: " Hello,", Start: 0, End: 1},
+			{Word: " big", Start: 1, End: 2},
+			{Word: " world.", Start: 2, End: 3},
+		}},
+		{ID: "b", Start: 4, End: 6, Score: 0.5, Text: " How are you?", Words: []Word{}},
+		{ID: "c", Start: 6, End: 8, Score: 0, Text: " 你好世界", Words: []Word{}},
+	}}
+}
+
+// unspacedResult adds segments without words to the result, one in Thai and one
+// with a single Latin word.
+func unspacedResult() *WhisperResult {
+	r := segmentsResult()
+	r.Segments = append(r.Segments,
+		Segment{ID: "d", Start: 8, End: 10, Text: " สวัสดีครับ", Words: []Word{}},
+		Segment{ID: "e", Start: 10, End: 12, Text: " Two.", Words: []Word{}},
+	)
+	return r
+}
+
+func segmentTexts(r *WhisperResult) []string {
+	texts := []string{}
+	for _, seg := range r.Segments {
+		texts = append(texts, seg.Text)
+	}
+	return texts
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSplitSegmentWords(t *testing.T) {
+	tests := []struct {
+		name        string
+		text        string
+		at          float64
+		word        int
+		left, right string
+		end, start  float64
+	}{
+		{"at a word", " Hello, big world.", 0, 2, " Hello, big", " world.", 2, 2},
+		{"at a time", " Hello, big world.", 0.5, -1, " Hello,", " big world.", 1, 1},
+		{"edited text", " Hi, small world.", 0, 1, " Hi,", " small world.", 1, 1},
+		// The text does not match the words: the words are split anyway
+		{"other text", " Hello big-world", 0, 1, " Hello,", " big world.", 1, 1},
+	}
+	for _, tt := range tests {
+		r := segmentsResult()
+		r.Segments[0].Text = tt.text
+		segs, err := r.SplitSegment("a", tt.at, tt.word)
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		left, right := segs[0], segs[1]
+		if left.Text != tt.left || right.Text != tt.right {
+			t.Errorf("%v: split into %q and %q", tt.name, left.Text, right.Text)
+		}
+		if left.End != tt.end || right.Start != tt.start || left.Start != 0 || right.End != 3 {
+			t.Errorf("%v: split into %v-%v and %v-%v", tt.name, left.Start, left.End, right.Start, right.End)
+		}
+		if len(left.Words)+len(right.Words) != 3 || wordsText(left.Words) != left.Text {
+			t.Errorf("%v: words %+v and %+v", tt.name, left.Words, right.Words)
+		}
+		if left.ID != "a" || right.ID == "a" || r.Segments[1].ID != right.ID || len(r.Segments) != 4 {
+			t.Errorf("%v: segments %+v", tt.name, r.Segments)
+		}
+	}
+}
+
+func TestSplitSegmentCJKWords(t *testing.T) {
+	r := &WhisperResult{Segments: []Segment{{ID: "a", Start: 0, End: 2, Text: "你好世界", Words: []Word{
+		{Word: "你好", Start: 0, End: 1},
+		{Word: "世界", Start: 1, End: 2},
+	}}}}
+	segs, err := r.SplitSegment("a", 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if segs[0].Text != "你好" || segs[1].Text != "世界" || len(segs[0].Words) != 1 || len(segs[1].Words) != 1 {
+		t.Errorf("split into %+v", segs)
+	}
+}
+
+func TestSplitSegmentText(t *testing.T) {
+	tests := []struct {
+		id          string
+		at          float64
+		word        int
+		left, right string
+	}{
+		{"b", 4.5, -1, " How", " are you?"},
+		{"b", 5.5, -1, " How are", " you?"},
+		{"b", 5, 2, " How are", " you?"},
+		{"c", 7, -1, " 你好", " 世界"},
+		{"c", 6.5, -1, " 你", " 好世界"},
+		{"d", 9, -1, " สวัสดี", " ครับ"},
+	}
+	for _, tt := range tests {
+		r := unspacedResult()
+		segs, err := r.SplitSegment(tt.id, tt.at, tt.word)
+		if err != nil {
+			t.Errorf("split %v at %v: %v", tt.id, tt.at, err)
+			continue
+		}
+		if segs[0].Text != tt.left || segs[1].Text != tt.right {
+			t.Errorf("split %v at %v into %q and %q", tt.id, tt.at, segs[0].Text, segs[1].Text)
+		}
+		if segs[0].End != tt.at || segs[1].Start != tt.at {
+			t.Errorf("split %v at %v: %+v", tt.id, tt.at, segs)
+		}
+	}
+}
+
+func TestSplitSegmentInvalid(t *testing.T) {
+	tests := []struct {
+		id   string
+		at   float64
+		word int
+		err  error
+	}{
+		{"zzz", 1, -1, ErrSegmentNotFound},
+		{"a", 0, 0, ErrInvalidSegment},
+		{"a", 0, 3, ErrInvalidSegment},
+		{"a", 2.5, -1, ErrInvalidSegment},
+		{"b", 4, -1, ErrInvalidSegment},
+		{"b", 6, -1, ErrInvalidSegment},
+		{"b", 5, 3, ErrInvalidSegment},
+		// A single word is not split between its letters
+		{"e", 11, -1, ErrInvalidSegment},
+	}
+	for _, tt := range tests {
+		r := unspacedResult()
+		if _, err := r.SplitSegment(tt.id, tt.at, tt.word); !errors.Is(err, tt.err) {
+			t.Errorf("split %v at %v, %v: got %v, want %v", tt.id, tt.at, tt.word, err, tt.err)
+		}
+		if len(r.Segments) != 5 {
+			t.Errorf("split %v at %v, %v: segments changed", tt.id, tt.at, tt.word)
+		}
+	}
+}
+
+func TestMergeSegments(t *testing.T) {
+	r := segmentsResult()
+	merged, removed, err := r.MergeSegments([]string{"b", "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != "a" || merged.Text != " Hello, big world. How are you?" || merged.Start != 0 || merged.End != 6 {
+		t.Errorf("merged into %+v", merged)
+	}
+	if merged.Score != 0.75 || len(merged.Words) != 3 {
+		t.Errorf("merged into %+v", merged)
+	}
+	if !equalStrings(removed, []string{"b"}) || len(r.Segments) != 2 || r.Segments[1].ID != "c" {
+		t.Errorf("removed %v, segments %+v", removed, r.Segments)
+	}
+	if r.Text != "Hello, big world. How are you? 你好世界" {
+		t.Errorf("text %q", r.Text)
+	}
+
+	for _, ids := range [][]string{{"a"}, {"a", "c"}, {"a", "zzz"}} {
+		r := segmentsResult()
+		if _, _, err := r.MergeSegments(ids); err == nil || len(r.Segments) != 3 {
+			t.Errorf("merged %v", ids)
+		}
+	}
+}
+
+func TestInsertSegment(t *testing.T) {
+	r := segmentsResult()
+	seg, err := r.InsertSegment(Segment{ID: "x", Start: 3, End: 4, Text: " Well."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.ID == "x" || seg.ID == "" || seg.Words == nil || r.Segments[1].ID != seg.ID {
+		t.Errorf("inserted %+v", seg)
+	}
+	want := []string{" Hello, big world.", " Well.", " How are you?", " 你好世界"}
+	if got := segmentTexts(r); !equalStrings(got, want) {
+		t.Errorf("segments %q", got)
+	}
+
+	for _, seg := range []Segment{{Start: 2, End: 2}, {Start: 2, End: 1}, {Start: -1, End: 1}} {
+		if _, err := r.InsertSegment(seg); !errors.Is(err, ErrInvalidSegment) {
+			t.Errorf("inserted %+v: %v", seg, err)
+		}
+	}
+}
+
+func TestDeleteSegment(t *testing.T) {
+	r := segmentsResult()
+	if err := r.DeleteSegment("b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := segmentTexts(r); !equalStrings(got, []string{" Hello, big world.", " 你好世界"}) {
+		t.Errorf("segments %q", got)
+	}
+	if r.Text != "Hello, big world. 你好世界" {
+		t.Errorf("text %q", r.Text)
+	}
+	if err := r.DeleteSegment("b"); !errors.Is(err, ErrSegmentNotFound) {
+		t.Errorf("deleted twice: %v", err)
+	}
+}
+
+func TestRetimeSegment(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name       string
+		id         string
+		start, end *float64
+		err        error
+	}{
+		{"start", "a", f(0.5), nil, nil},
+		{"end", "a", nil, f(4), nil},
+		{"both", "b", f(3.5), f(5.5), nil},
+		{"up to the next", "b", nil, f(6), nil},
+		{"empty", "a", f(2), f(2), ErrInvalidSegment},
+		{"reversed", "a", f(2), f(1), ErrInvalidSegment},
+		{"negative", "a", f(-1), nil, ErrInvalidSegment},
+		{"over the previous", "b", f(2.5), nil, ErrSegmentOverlap},
+		{"over the next", "b", nil, f(6.5), ErrSegmentOverlap},
+		{"unknown", "zzz", f(1), nil, ErrSegmentNotFound},
+	}
+	for _, tt := range tests {
+		r := segmentsResult()
+		seg, err := r.RetimeSegment(tt.id, tt.start, tt.end)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: got %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tt.start != nil && seg.Start != *tt.start || tt.end != nil && seg.End != *tt.end {
+			t.Errorf("%v: retimed to %v-%v", tt.name, seg.Start, seg.End)
+		}
+		for _, w := range seg.Words {
+			if w.Start < seg.Start || w.End > seg.End {
+				t.Errorf("%v: word %+v outside of %v-%v", tt.name, w, seg.Start, seg.End)
+			}
+		}
+	}
+}
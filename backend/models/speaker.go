@@ -0,0 +1,147 @@
+package models
+
+import "fmt"
+
+// Colors given to new speakers, in order of appearance.
+var SpeakerColors = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// Speaker holds the display settings of a speaker label found by diarization.
+// Segments and words reference speakers by their ID.
+type Speaker struct {
+	ID    string `bson:"id" json:"id"`
+	Name  string `bson:"name" json:"name"`
+	Color string `bson:"color" json:"color"`
+}
+
+// SyncSpeakers adds an entry to the speaker table for every label used in the
+// result that is not in it yet. Segments without a speaker but with labelled
+// words take the label of most of their words.
+func (t *Transcription) SyncSpeakers() {
+	for i := range t.Result.Segments {
+		seg := &t.Result.Segments[i]
+		if seg.Speaker == "" {
+			seg.Speaker = majoritySpeaker(seg.Words)
+		}
+	}
+
+	known := make(map[string]bool)
+	for _, sp := range t.Speakers {
+		known[sp.ID] = true
+	}
+	for _, seg := range t.Result.Segments {
+		if seg.Speaker == "" || known[seg.Speaker] {
+			continue
+		}
+		known[seg.Speaker] = true
+		t.Speakers = append(t.Speakers, Speaker{
+			ID:    seg.Speaker,
+			Name:  fmt.Sprintf("Speaker %d", len(t.Speakers)+1),
+			Color: SpeakerColors[len(t.Speakers)%len(SpeakerColors)],
+		})
+	}
+}
+
+// GetSpeaker returns the speaker with the given ID, or nil if there is none.
+func (t *Transcription) GetSpeaker(id string) *Speaker {
+	for i := range t.Speakers {
+		if t.Speakers[i].ID == id {
+			return &t.Speakers[i]
+		}
+	}
+	return nil
+}
+
+// SpeakerName returns the display name of a speaker ID, or the ID itself if
+// it is not in the speaker table.
+func (t *Transcription) SpeakerName(id string) string {
+	if sp := t.GetSpeaker(id); sp != nil && sp.Name != "" {
+		return sp.Name
+	}
+	return id
+}
+
+// AssignSpeaker sets the speaker of the segments with the given IDs, and of
+// their words, in the result and in all translations. It returns the number of
+// segments of the result that were found.
+func (t *Transcription) AssignSpeaker(segmentIds []string, speaker string) int {
+	ids := make(map[string]bool)
+	for _, id := range segmentIds {
+		ids[id] = true
+	}
+	t.forEachSegment(func(seg *Segment) {
+		if ids[seg.ID] {
+			setSegmentSpeaker(seg, speaker)
+		}
+	})
+	found := 0
+	for _, seg := range t.Result.Segments {
+		if ids[seg.ID] {
+			found++
+		}
+	}
+	return found
+}
+
+// MergeSpeakers relabels every segment and word of the given speakers as the
+// target speaker, and removes the merged speakers from the table.
+func (t *Transcription) MergeSpeakers(speakers []string, into string) {
+	merged := make(map[string]bool)
+	for _, id := range speakers {
+		if id != into {
+			merged[id] = true
+		}
+	}
+	t.forEachSegment(func(seg *Segment) {
+		if merged[seg.Speaker] {
+			seg.Speaker = into
+		}
+		for i := range seg.Words {
+			if merged[seg.Words[i].Speaker] {
+				seg.Words[i].Speaker = into
+			}
+		}
+	})
+	kept := make([]Speaker, 0, len(t.Speakers))
+	for _, sp := range t.Speakers {
+		if !merged[sp.ID] {
+			kept = append(kept, sp)
+		}
+	}
+	t.Speakers = kept
+}
+
+func (t *Transcription) forEachSegment(f func(seg *Segment)) {
+	for i := range t.Result.Segments {
+		f(&t.Result.Segments[i])
+	}
+	for i := range t.Translations {
+		for j := range t.Translations[i].Result.Segments {
+			f(&t.Translations[i].Result.Segments[j])
+		}
+	}
+}
+
+func setSegmentSpeaker(seg *Segment, speaker string) {
+	seg.Speaker = speaker
+	for i := range seg.Words {
+		seg.Words[i].Speaker = speaker
+	}
+}
+
+func majoritySpeaker(words []Word) string {
+	count := make(map[string]int)
+	var best string
+	for _, w := range words {
+		if w.Speaker == "" {
+			continue
+		}
+		count[w.Speaker]++
+		if count[w.Speaker] > count[best] {
+			best = w.Speaker
+		}
+	}
+	return best
+}
@@ -0,0 +1,53 @@
+package models
+
+import "testing"
+
+func speakerTranscription() *Transcription {
+	return &Transcription{Result: WhisperResult{Segments: []Segment{
+		{ID: "a", Text: " Hello", Speaker: "SPK_1", Words: []Word{{Word: " Hello", Speaker: "SPK_1"}}},
+		{ID: "b", Text: " there", Words: []Word{{Word: " there"}}},
+	}}}
+}
+
+func TestAssignSpeaker(t *testing.T) {
+	tr := speakerTranscription()
+	tr.SyncSpeakers()
+	if n := tr.AssignSpeaker([]string{"b", "zzz"}, "SPK_2"); n != 1 {
+		t.Errorf("found %d segments, want 1", n)
+	}
+	tr.SyncSpeakers()
+	seg := tr.Result.Segments[1]
+	if seg.Speaker != "SPK_2" || seg.Words[0].Speaker != "SPK_2" {
+		t.Errorf("segment not assigned: %+v", seg)
+	}
+	if tr.GetSpeaker("SPK_2") == nil {
+		t.Errorf("speaker not added to the table: %+v", tr.Speakers)
+	}
+}
+
+func TestAssignSpeakerUnknownSegment(t *testing.T) {
+	tr := speakerTranscription()
+	tr.SyncSpeakers()
+	if n := tr.AssignSpeaker([]string{"zzz"}, "SPK_9"); n != 0 {
+		t.Errorf("found %d segments, want 0", n)
+	}
+	tr.SyncSpeakers()
+	if tr.GetSpeaker("SPK_9") != nil {
+		t.Errorf("unused speaker added to the table: %+v", tr.Speakers)
+	}
+}
+
+func TestMergeSpeakers(t *testing.T) {
+	tr := speakerTranscription()
+	tr.AssignSpeaker([]string{"b"}, "SPK_2")
+	tr.SyncSpeakers()
+	tr.MergeSpeakers([]string{"SPK_2"}, "SPK_1")
+	for _, seg := range tr.Result.Segments {
+		if seg.Speaker != "SPK_1" || seg.Words[0].Speaker != "SPK_1" {
+			t.Errorf("segment not merged: %+v", seg)
+		}
+	}
+	if len(tr.Speakers) != 1 || tr.Speakers[0].ID != "SPK_1" {
+		t.Errorf("speakers = %+v", tr.Speakers)
+	}
+}
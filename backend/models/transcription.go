@@ -3,6 +3,9 @@ package models
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	ltr "github.com/snakesel/libretranslate"
@@ -20,6 +23,104 @@ type Transcription struct {
 	SourceUrl    string             `bson:"sourceUrl" json:"sourceUrl"`
 	Result       WhisperResult      `bson:"result" json:"result"`
 	Translations []Translation      `bson:"translations" json:"translations"`
+	// Script is the text to align to the media, for the align task.
+	Script string `bson:"script,omitempty" json:"script,omitempty"`
+	// TranslationOutput tells if the English text of the translate task is stored
+	// as a translation of the transcription, or as the result itself.
+	TranslationOutput string `bson:"translationOutput,omitempty" json:"translationOutput,omitempty"`
+	// Diarize asks the ASR service to label the speakers, if it supports it.
+	Diarize     bool      `bson:"diarize" json:"diarize"`
+	NumSpeakers int       `bson:"numSpeakers,omitempty" json:"numSpeakers,omitempty"`
+	Speakers    []Speaker `bson:"speakers" json:"speakers"`
+	// InitialPrompt is given to the ASR as the text preceding the media, to
+	// guide its spelling and style.
+	InitialPrompt string `bson:"initialPrompt,omitempty" json:"initialPrompt,omitempty"`
+	// Hotwords are terms the ASR should favour, such as names or jargon.
+	Hotwords []string `bson:"hotwords,omitempty" json:"hotwords,omitempty"`
+	// Options are the decoding parameters given to the ASR.
+	Options DecodingOptions `bson:"options" json:"options"`
+	// Preprocess are the steps applied to the audio before it is sent to the ASR,
+	// or nil to send the media as it is.
+	Preprocess *PreprocessOptions `bson:"preprocess,omitempty" json:"preprocess,omitempty"`
+	// Range is the part of the media that is transcribed, or nil for all of it.
+	// The timestamps of the result are relative to the start of the media.
+	Range *TimeRange `bson:"range,omitempty" json:"range,omitempty"`
+	// Media describes the media file. It is probed when the file is uploaded or
+	// downloaded, and is nil if it could not be probed.
+	Media *MediaInfo `bson:"media,omitempty" json:"media,omitempty"`
+	// Hash is the SHA-256 of the media file, in hex. Transcriptions of the same
+	// media share a single copy of the file.
+	Hash string `bson:"hash,omitempty" json:"hash,omitempty"`
+	// Reuse asks to copy the result of a done transcription of the same media with
+	// the same settings, instead of running the ASR. ReusedFrom is the id of the
+	// transcription it was copied from.
+	Reuse      bool   `bson:"reuse,omitempty" json:"reuse,omitempty"`
+	ReusedFrom string `bson:"reusedFrom,omitempty" json:"reusedFrom,omitempty"`
+	// Watch is set for the transcriptions of media found in a watched folder.
+	Watch *WatchSource `bson:"watch,omitempty" json:"watch,omitempty"`
+	// Redaction is set once personal information was redacted.
+	Redaction *Redaction `bson:"redaction,omitempty" json:"redaction,omitempty"`
+	// Version is increased by every update. Updates must give the version they
+	// were made on, so that concurrent changes are not overwritten.
+	Version int64 `bson:"version" json:"version"`
+}
+
+// WatchSource is the file of a watched folder a transcription was made from.
+type WatchSource struct {
+	Folder string `bson:"folder" json:"folder"`
+	Path   string `bson:"path" json:"path"`
+	// Size and ModTime of the file when it was taken, which tell it apart from
+	// a later file with the same path.
+	Size    int64     `bson:"size,omitempty" json:"size,omitempty"`
+	ModTime time.Time `bson:"modTime,omitempty" json:"modTime,omitempty"`
+	// Processed is set once the translations and exports of the folder are done,
+	// and the file was moved if the folder asks for it.
+	Processed bool `bson:"processed" json:"processed"`
+}
+
+// WatchRejection is a file of a watched folder that is not valid media, so that
+// it is not probed again.
+type WatchRejection struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Folder    string             `bson:"folder" json:"folder"`
+	Path      string             `bson:"path" json:"path"`
+	Size      int64              `bson:"size" json:"size"`
+	ModTime   time.Time          `bson:"modTime" json:"modTime"`
+	Reason    string             `bson:"reason" json:"reason"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// DisplayName returns the original name of the media file, without the
+// timestamp or id prefix added when it was stored.
+func (t *Transcription) DisplayName() string {
+	name := t.FileName
+	if i := strings.Index(name, FileNameSeparator); i >= 0 {
+		name = name[i+len(FileNameSeparator):]
+	}
+	return name
+}
+
+// SameSettings tells if two transcriptions were made with the settings that
+// change the result, so that one can reuse the result of the other.
+func (t *Transcription) SameSettings(o *Transcription) bool {
+	return reflect.DeepEqual(t.settings(), o.settings())
+}
+
+func (t *Transcription) settings() []interface{} {
+	var hotwords []string
+	if len(t.Hotwords) > 0 {
+		hotwords = t.Hotwords
+	}
+	return []interface{}{
+		t.Language, t.ModelSize, t.Task, t.TranslationOutput, t.Script, t.Diarize, t.NumSpeakers,
+		t.InitialPrompt, hotwords, t.Options, t.Preprocess, t.Range,
+	}
+}
+
+// HasMedia tells if the transcription has a media file in the storage.
+// Imported subtitles may have none.
+func (t *Transcription) HasMedia() bool {
+	return t.FileName != "" && !strings.HasPrefix(t.FileName, FileNameSeparator)
 }
 
 func (t *Transcription) Translate(target string) error {
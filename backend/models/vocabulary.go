@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Vocabulary is a named list of terms, such as names or jargon, that can be
+// given to the ASR as hotwords when creating a transcription.
+type Vocabulary struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	Words     []string           `bson:"words" json:"words"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// ParseHotwords splits a list of hotwords separated by commas or new lines.
+func ParseHotwords(list string) []string {
+	return MergeHotwords(strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	}))
+}
+
+// MergeHotwords joins lists of hotwords, trimming them and dropping empty and
+// repeated ones.
+func MergeHotwords(lists ...[]string) []string {
+	seen := make(map[string]bool)
+	words := []string{}
+	for _, list := range lists {
+		for _, w := range list {
+			w = strings.TrimSpace(w)
+			if w == "" || seen[strings.ToLower(w)] {
+				continue
+			}
+			seen[strings.ToLower(w)] = true
+			words = append(words, w)
+		}
+	}
+	return words
+}
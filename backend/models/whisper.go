@@ -1,5 +1,11 @@
 package models
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
 type WhisperResult struct {
 	Language string    `json:"language"`
 	Duration float64   `json:"duration"`
@@ -8,17 +14,41 @@ type WhisperResult struct {
 }
 
 type Segment struct {
-	End   float64 `json:"end"`
-	ID    string  `json:"id"`
-	Start float64 `json:"start"`
-	Score float64 `json:"score"`
-	Text  string  `json:"text"`
-	Words []Word  `json:"words"`
+	End     float64 `json:"end"`
+	ID      string  `json:"id"`
+	Start   float64 `json:"start"`
+	Score   float64 `json:"score"`
+	Text    string  `json:"text"`
+	Speaker string  `json:"speaker,omitempty"`
+	Words   []Word  `json:"words"`
+	// Verified is set when a reviewer confirmed a low confidence segment.
+	Verified bool `json:"verified,omitempty"`
 }
 
 type Word struct {
-	End   float64 `json:"end"`
-	Start float64 `json:"start"`
-	Word  string  `json:"word"`
-	Score float64 `json:"score"`
+	End      float64 `json:"end"`
+	Start    float64 `json:"start"`
+	Word     string  `json:"word"`
+	Score    float64 `json:"score"`
+	Speaker  string  `json:"speaker,omitempty"`
+	Verified bool    `json:"verified,omitempty"`
+}
+
+// NewSegmentID returns a random identifier for a segment, in the same format
+// as the ones generated by the ASR service.
+func NewSegmentID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TextFromSegments joins the text of all segments.
+func TextFromSegments(segments []Segment) string {
+	texts := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		if text := strings.TrimSpace(seg.Text); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, " ")
 }
@@ -2,14 +2,20 @@ package monitor
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
+	"codeberg.org/pluja/whishper/align"
 	"codeberg.org/pluja/whishper/api"
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/media"
 	"codeberg.org/pluja/whishper/models"
 	"codeberg.org/pluja/whishper/utils"
 )
@@ -29,12 +35,13 @@ func StartMonitor(s *api.Server) {
 					err := transcribe(s, pt)
 					if err != nil {
 						log.Error().Err(err).Msg("Error transcribing")
-						pt.Status = models.TranscriptionStatusError
-						ut, err := s.Db.UpdateTranscription(pt)
+						err = database.UpdateWithRetry(s.Db, pt, func(t *models.Transcription) {
+							t.Status = models.TranscriptionStatusError
+						})
 						if err != nil {
 							log.Error().Err(err).Msg("Error updating transcription")
 						}
-						s.BroadcastTranscription(ut)
+						s.BroadcastTranscription(pt)
 						continue
 					}
 				}
@@ -45,9 +52,10 @@ func StartMonitor(s *api.Server) {
 
 func transcribe(s *api.Server, t *models.Transcription) error {
 	// Update transcription status
-	t.Status = models.TranscriptionStatusRunning
 	log.Debug().Msgf("Updating transcription %v", t)
-	_, err := s.Db.UpdateTranscription(t)
+	err := database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+		t.Status = models.TranscriptionStatusRunning
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("Error updating transcription")
 		return err
@@ -55,54 +63,234 @@ func transcribe(s *api.Server, t *models.Transcription) error {
 	s.BroadcastTranscription(t)
 
 	if t.SourceUrl != "" {
-		// Download media
-		fn, err := utils.DownloadMedia(t)
+		// Download media, which is checked before it is stored
+		dir, err := os.MkdirTemp("", "whishper-download-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		fn, err := utils.DownloadMedia(t, dir)
 		if err != nil {
 			log.Error().Err(err).Msg("Error downloading media")
 			return err
 		}
-		t.FileName = fn
+		info, err := probeDownload(filepath.Join(dir, fn))
+		if err != nil {
+			return err
+		}
+		if t.Range != nil {
+			if err := t.Range.Validate(info); err != nil {
+				return fmt.Errorf("invalid range: %w", err)
+			}
+		}
+		if err := s.Storage.SaveFile(context.Background(), fn, filepath.Join(dir, fn)); err != nil {
+			log.Error().Err(err).Msg("Error storing media")
+			return err
+		}
+		// The download may be a media file that is already stored
+		d := t.Copy()
+		d.FileName = fn
+		d.Media = info
+		reused := s.Deduplicate(d)
+		err = database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+			t.FileName = d.FileName
+			t.Media = d.Media
+			t.Hash = d.Hash
+			if reused {
+				t.Result = d.Result
+				t.Speakers = d.Speakers
+				t.Translations = d.Translations
+				t.ReusedFrom = d.ReusedFrom
+				t.Status = models.TranscriptionStatusDone
+			}
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Error updating transcription")
+			return err
+		}
+		if d.FileName == fn {
+			s.GenerateWaveform(fn)
+		}
+		if reused {
+			s.RecordRevision(nil, t, "whishper", "reuse")
+			s.BroadcastTranscription(t)
+			return nil
+		}
 		s.BroadcastTranscription(t)
 	}
 
-	// Prepare multipart form data
-	body, writer, err := prepareMultipartFormData(t)
+	// The ASR gets the media as it is, or its pre-processed audio
+	src, release, err := s.Storage.Local(context.Background(), t.FileName)
 	if err != nil {
-		log.Error().Err(err).Msg("Error preparing multipart form data")
+		log.Error().Err(err).Msg("Error reading media")
 		return err
 	}
+	defer release()
+	audio := asrAudio{path: src}
+	if t.Preprocess != nil || t.Range != nil {
+		audio, err = preprocess(t, src)
+		if err != nil {
+			log.Error().Err(err).Msg("Error pre-processing media")
+			return err
+		}
+		defer os.Remove(audio.path)
+	}
 
-	// Send transcription request to transcription service
-	res, err := utils.SendTranscriptionRequest(t, body, writer)
-	if err != nil {
-		log.Error().Err(err).Msg("Error sending transcription request")
-		return err
+	var res *models.WhisperResult
+	var translation *models.Translation
+	switch t.Task {
+	case models.TaskTranslate:
+		if t.TranslationOutput == models.TranslationOutputResult {
+			res, err = runAsr(t, models.TaskTranslate, audio)
+			if err != nil {
+				return err
+			}
+			break
+		}
+		res, err = runAsr(t, models.TaskTranscribe, audio)
+		if err != nil {
+			return err
+		}
+		translated, err := runAsr(t, models.TaskTranslate, audio)
+		if err != nil {
+			return err
+		}
+		translation = &models.Translation{
+			SourceLanguage: res.Language,
+			TargetLanguage: translated.Language,
+			Status:         models.TranscriptionStatusDone,
+			Result:         *translated,
+		}
+	case models.TaskAlign:
+		// Alignment needs a regular transcription to align the script against
+		res, err = runAsr(t, models.TaskTranscribe, audio)
+		if err != nil {
+			return err
+		}
+		var window models.TimeRange
+		if t.Range != nil {
+			window = *t.Range
+		}
+		res = align.Align(t.Script, res, window)
+	default:
+		res, err = runAsr(t, models.TaskTranscribe, audio)
+		if err != nil {
+			return err
+		}
 	}
 
-	t.Result = *res
-	t.Translations = []models.Translation{}
-	t.Status = models.TranscriptionStatusDone
-	_, err = s.Db.UpdateTranscription(t)
+	// The transcription may have been changed while the ASR was running, so the
+	// result is applied on top of the latest version.
+	fileName := t.FileName
+	err = database.UpdateWithRetry(s.Db, t, func(t *models.Transcription) {
+		t.FileName = fileName
+		t.Result = *res
+		t.SyncSpeakers()
+		t.Translations = []models.Translation{}
+		if translation != nil {
+			t.Translations = append(t.Translations, *translation)
+		}
+		t.Status = models.TranscriptionStatusDone
+	})
 	if err != nil {
 		log.Error().Err(err).Msg("Error updating transcription")
 		return err
 	}
+	s.RecordRevision(nil, t, "whishper", t.Task)
 	s.BroadcastTranscription(t)
 	return nil
 }
 
-func prepareMultipartFormData(t *models.Transcription) (*bytes.Buffer, *multipart.Writer, error) {
+// probeDownload describes a downloaded media file. Files that cannot be
+// transcribed fail the transcription, and are not stored.
+func probeDownload(path string) (*models.MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	info, err := media.Probe(ctx, path)
+	if err == nil {
+		return info, nil
+	}
+	if media.IsInvalid(err) {
+		return nil, fmt.Errorf("downloaded media rejected: %w", err)
+	}
+	log.Warn().Err(err).Msgf("Could not probe download %v", filepath.Base(path))
+	return nil, nil
+}
+
+// asrAudio is the file sent to the ASR, which starts at offset seconds of the media.
+type asrAudio struct {
+	path   string
+	offset float64
+}
+
+// preprocess converts src, the media of the transcription, to a temporary audio
+// file, with its pre-processing steps and only its range.
+func preprocess(t *models.Transcription, src string) (asrAudio, error) {
+	f, err := os.CreateTemp("", "whishper-*.wav")
+	if err != nil {
+		return asrAudio{}, err
+	}
+	f.Close()
+	var duration float64
+	if t.Media != nil {
+		duration = t.Media.Duration
+	}
+	var options models.PreprocessOptions
+	if t.Preprocess != nil {
+		options = *t.Preprocess
+	}
+	var r models.TimeRange
+	if t.Range != nil {
+		r = *t.Range
+	}
+	offset, err := media.Preprocess(context.Background(), src, f.Name(), options, r, duration)
+	if err != nil {
+		os.Remove(f.Name())
+		return asrAudio{}, err
+	}
+	return asrAudio{path: f.Name(), offset: offset}, nil
+}
+
+// runAsr sends the audio of the transcription to the ASR service, to run the given task.
+func runAsr(t *models.Transcription, task string, audio asrAudio) (*models.WhisperResult, error) {
+	// Prepare multipart form data
+	body, writer, err := prepareMultipartFormData(audio.path)
+	if err != nil {
+		log.Error().Err(err).Msg("Error preparing multipart form data")
+		return nil, err
+	}
+
+	// Send transcription request to transcription service
+	res, err := utils.SendTranscriptionRequest(t, task, body, writer)
+	if err != nil {
+		log.Error().Err(err).Msg("Error sending transcription request")
+		return nil, err
+	}
+	if task == models.TaskTranslate {
+		// Whisper always translates to English
+		res.Language = "en"
+	}
+	if audio.offset > 0 {
+		res.Shift(audio.offset)
+	}
+	if (t.Preprocess != nil || t.Range != nil) && t.Media != nil {
+		// The pre-processed audio may be shorter than the media
+		res.Duration = t.Media.Duration
+	}
+	return res, nil
+}
+
+func prepareMultipartFormData(filePath string) (*bytes.Buffer, *multipart.Writer, error) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
-	part, err := writer.CreateFormFile("file", t.FileName)
+	part, err := writer.CreateFormFile("file", filepath.Base(filePath))
 	if err != nil {
 		log.Error().Err(err).Msg("Error creating form file")
 		return nil, nil, err
 	}
 
 	// Read file from disk
-	filePath := filepath.Join(os.Getenv("UPLOAD_DIR"), t.FileName)
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.Error().Err(err).Msg("Error opening file")
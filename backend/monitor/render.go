@@ -0,0 +1,138 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/api"
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/media"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// StartRenderer runs the render jobs, one at a time, as they are created.
+func StartRenderer(s *api.Server) {
+	log.Info().Msg("Starting renderer!")
+	go func() {
+		for {
+			<-s.NewRenderCh
+			for _, r := range s.Db.GetPendingRenders() {
+				log.Debug().Msgf("Taking pending render %v", r.ID.Hex())
+				if err := render(s, r); err != nil {
+					log.Error().Err(err).Msgf("Error rendering %v", r.ID.Hex())
+					setRenderStatus(s, r, models.TranscriptionStatusError, err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func setRenderStatus(s *api.Server, r *models.Render, status int, message string) error {
+	r.Status = status
+	r.Error = message
+	r.UpdatedAt = time.Now()
+	_, err := s.Db.UpdateRender(r)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error updating render %v", r.ID.Hex())
+	}
+	return err
+}
+
+func render(s *api.Server, r *models.Render) error {
+	t := s.Db.GetTranscription(r.TranscriptionID.Hex())
+	if t == nil {
+		return fmt.Errorf("transcription %v not found", r.TranscriptionID.Hex())
+	}
+	if err := setRenderStatus(s, r, models.TranscriptionStatusRunning, ""); err != nil {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "whishper-render-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Every track is written to a subtitle file
+	opts := export.DefaultOptions()
+	opts.Speakers = r.Speakers
+	opts.Style = r.Style
+	format := export.FormatSrt
+	if r.Mode == models.RenderBurn {
+		format = export.FormatAss
+	}
+	var tracks []media.SubtitleTrack
+	addTrack := func(res *models.WhisperResult, language, title string) error {
+		path := filepath.Join(dir, fmt.Sprintf("%d.%v", len(tracks), format))
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := export.Write(format, t, res, opts, f); err != nil {
+			return err
+		}
+		tracks = append(tracks, media.SubtitleTrack{Path: path, Language: language, Title: title})
+		return nil
+	}
+	if r.Original {
+		if err := addTrack(&t.Result, t.Result.Language, t.Result.Language); err != nil {
+			return err
+		}
+	}
+	for _, language := range r.Translations {
+		var res *models.WhisperResult
+		for i := range t.Translations {
+			if t.Translations[i].TargetLanguage == language {
+				res = &t.Translations[i].Result
+			}
+		}
+		if res == nil {
+			return fmt.Errorf("translation to %v not found", language)
+		}
+		if err := addTrack(res, language, language+" (translation)"); err != nil {
+			return err
+		}
+	}
+
+	// Renders of a redacted transcription are made from its redacted media
+	mediaFile, err := t.MediaFile()
+	if err != nil {
+		return err
+	}
+	ext := filepath.Ext(t.FileName)
+	fileName := fmt.Sprintf("%v.render-%v.%v", strings.TrimSuffix(t.FileName, ext), r.ID.Hex(), r.Container)
+	ctx := context.Background()
+	src, release, err := s.Storage.Local(ctx, mediaFile)
+	if err != nil {
+		return err
+	}
+	defer release()
+	dst := filepath.Join(dir, "render."+r.Container)
+	if r.Mode == models.RenderBurn {
+		err = media.BurnSubtitles(ctx, src, tracks[0].Path, dst)
+	} else {
+		err = media.MuxSubtitles(ctx, src, dst, tracks)
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.SaveFile(ctx, fileName, dst); err != nil {
+		return err
+	}
+
+	r.FileName = fileName
+	if err := setRenderStatus(s, r, models.TranscriptionStatusDone, ""); err != nil {
+		// The render was deleted in the meantime
+		s.Storage.Delete(ctx, fileName)
+		return nil
+	}
+	log.Info().Msgf("Rendered %v", fileName)
+	return nil
+}
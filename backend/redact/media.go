@@ -0,0 +1,72 @@
+package redact
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"codeberg.org/pluja/whishper/media"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Padding added around every redacted span of the media, in seconds, as word
+// timings are not exact.
+const mediaPadding = 0.1
+
+// Media writes a copy of the media at src to dst, where the time ranges of the
+// spans of the original result are silenced, or covered by a beep. Video
+// streams are copied unchanged.
+func Media(ctx context.Context, src, dst string, spans []models.RedactedSpan, mode string) error {
+	ranges := mergeRanges(spans)
+	if len(ranges) == 0 {
+		return fmt.Errorf("nothing to redact")
+	}
+	conditions := make([]string, len(ranges))
+	for i, r := range ranges {
+		conditions[i] = fmt.Sprintf("between(t,%.3f,%.3f)", r[0], r[1])
+	}
+	enable := strings.Join(conditions, "+")
+
+	var filter string
+	switch mode {
+	case models.RedactionSilence:
+		filter = fmt.Sprintf("[0:a]volume=0:enable='%s'[a]", enable)
+	case models.RedactionBleep:
+		filter = fmt.Sprintf("[0:a]volume=0:enable='%s'[s];"+
+			"sine=frequency=1000:sample_rate=48000,volume=0.3,volume=0:enable='not(%s)'[b];"+
+			"[s][b]amix=inputs=2:duration=first:normalize=0[a]", enable, enable)
+	default:
+		return fmt.Errorf("unsupported redaction mode %q", mode)
+	}
+	return media.Ffmpeg(ctx, "-i", src, "-filter_complex", filter,
+		"-map", "0:v?", "-c:v", "copy", "-map", "[a]", dst)
+}
+
+// mergeRanges returns the padded time ranges of the spans of the original
+// result, sorted and without overlaps.
+func mergeRanges(spans []models.RedactedSpan) [][2]float64 {
+	var ranges [][2]float64
+	for _, s := range spans {
+		if s.Translation != "" {
+			continue
+		}
+		start := s.Start - mediaPadding
+		if start < 0 {
+			start = 0
+		}
+		ranges = append(ranges, [2]float64{start, s.End + mediaPadding})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var merged [][2]float64
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
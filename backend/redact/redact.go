@@ -0,0 +1,157 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	RuleRegex      = "regex"
+	RuleDictionary = "dictionary"
+)
+
+// Rule detects one kind of personal information.
+type Rule struct {
+	Name string `json:"name"`
+	// Type is `regex` to match Pattern, or `dictionary` to match any of Words
+	// as whole words, ignoring case.
+	Type    string   `json:"type"`
+	Pattern string   `json:"pattern,omitempty"`
+	Words   []string `json:"words,omitempty"`
+	// WholeWord only matches regexes that are not part of a longer word.
+	WholeWord bool `json:"wholeWord,omitempty"`
+	// Placeholder replaces the matches. It defaults to the upper-cased name in
+	// brackets, such as [EMAIL].
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+// DefaultRules detect emails, phone numbers and payment card numbers.
+var DefaultRules = []Rule{
+	{Name: "email", Type: RuleRegex, Pattern: `[\w.+-]+@[\w-]+(\.[\w-]+)+`, WholeWord: true},
+	{Name: "card", Type: RuleRegex, Pattern: `\d{4}([ -]?\d{4}){2}[ -]?\d{1,7}`, WholeWord: true},
+	{Name: "phone", Type: RuleRegex, Pattern: phonePattern, WholeWord: true},
+}
+
+// phonePattern only matches numbers shaped like phone numbers, so that runs of
+// plain numbers such as years or amounts are kept: international numbers with
+// a leading +, national numbers with a leading 0 and North American numbers.
+var phonePattern = strings.Join([]string{
+	`\+\d{1,3}[ .-]?(\(\d{1,4}\)[ .-]?)?\d{1,4}([ .-]?\d{2,4}){1,4}`,
+	`\(?0\d{1,4}\)?[ .-]?\d{3,4}[ .-]?\d{3,4}`,
+	`0\d([ .-]?\d{2}){4}`,
+	`\(\d{3}\)[ .-]?\d{3}[ .-]?\d{4}`,
+	`\d{3}[.-]\d{3}[.-]\d{4}`,
+}, "|")
+
+// ConfiguredRules returns the default rules, followed by the ones in the JSON file
+// given by the REDACTION_RULES environment variable, if any.
+func ConfiguredRules() ([]Rule, error) {
+	rules := append([]Rule{}, DefaultRules...)
+	path := os.Getenv("REDACTION_RULES")
+	if path == "" {
+		return rules, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var custom []Rule
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("invalid redaction rules in %v: %w", path, err)
+	}
+	return append(rules, custom...), nil
+}
+
+// Redactor replaces the matches of a set of rules.
+type Redactor struct {
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	name        string
+	placeholder string
+	replacer    *models.Replacer
+}
+
+func New(rules []Rule) (*Redactor, error) {
+	r := &Redactor{}
+	for _, rule := range rules {
+		opts := models.ReplaceOptions{Regex: true, CaseSensitive: true}
+		switch rule.Type {
+		case RuleRegex:
+			opts.Find = rule.Pattern
+			opts.WholeWord = rule.WholeWord
+		case RuleDictionary:
+			words := make([]string, 0, len(rule.Words))
+			for _, w := range rule.Words {
+				if w = strings.TrimSpace(w); w != "" {
+					words = append(words, regexp.QuoteMeta(w))
+				}
+			}
+			if len(words) == 0 {
+				continue
+			}
+			// Longest first, so that a word does not hide a longer one starting with it
+			sort.Slice(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+			opts.Find = strings.Join(words, "|")
+			opts.WholeWord = true
+			opts.CaseSensitive = false
+		default:
+			return nil, fmt.Errorf("rule %q has an unknown type %q", rule.Name, rule.Type)
+		}
+		if opts.Find == "" {
+			return nil, fmt.Errorf("rule %q has no pattern", rule.Name)
+		}
+		placeholder := rule.Placeholder
+		if placeholder == "" {
+			placeholder = "[" + strings.ToUpper(rule.Name) + "]"
+		}
+		// The placeholder is literal, so group references are escaped
+		opts.Replace = strings.ReplaceAll(placeholder, "$", "$$")
+		replacer, err := models.NewReplacer(opts)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		r.rules = append(r.rules, compiledRule{name: rule.Name, placeholder: placeholder, replacer: replacer})
+	}
+	return r, nil
+}
+
+// Result redacts a result in place, and returns the redacted spans. Spans are
+// timed with the words holding a placeholder, or with the whole segment when
+// it has no word data.
+func (r *Redactor) Result(res *models.WhisperResult, translation string) []models.RedactedSpan {
+	var spans []models.RedactedSpan
+	for _, rule := range r.rules {
+		for _, m := range rule.replacer.ReplaceResult(res, translation) {
+			seg := &res.Segments[res.IndexOf(m.SegmentID)]
+			found := false
+			for _, w := range seg.Words {
+				if strings.Contains(w.Word, rule.placeholder) {
+					spans = append(spans, models.RedactedSpan{Rule: rule.name, Translation: translation, SegmentID: seg.ID, Start: w.Start, End: w.End})
+					found = true
+				}
+			}
+			if !found {
+				spans = append(spans, models.RedactedSpan{Rule: rule.name, Translation: translation, SegmentID: seg.ID, Start: seg.Start, End: seg.End})
+			}
+		}
+	}
+	return spans
+}
+
+// Transcription redacts the result and all the translations of a transcription
+// in place, and returns the redacted spans.
+func (r *Redactor) Transcription(t *models.Transcription) []models.RedactedSpan {
+	spans := r.Result(&t.Result, "")
+	for i := range t.Translations {
+		spans = append(spans, r.Result(&t.Translations[i].Result, t.Translations[i].TargetLanguage)...)
+	}
+	return spans
+}
@@ -0,0 +1,42 @@
+package redact
+
+import (
+	"testing"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+func TestPhoneRule(t *testing.T) {
+	r, err := New([]Rule{DefaultRules[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"call +1 555 123 4567 now", "call [PHONE] now"},
+		{"call +44 20 7946 0958 now", "call [PHONE] now"},
+		{"call +33 6 12 34 56 78 now", "call [PHONE] now"},
+		{"call +34612345678 now", "call [PHONE] now"},
+		{"call 020 7946 0958 now", "call [PHONE] now"},
+		{"call 0612345678 now", "call [PHONE] now"},
+		{"call 06 12 34 56 78 now", "call [PHONE] now"},
+		{"call (555) 123-4567 now", "call [PHONE] now"},
+		{"call 555-123-4567 now", "call [PHONE] now"},
+		{"in 1990 2000 2010 it grew", "in 1990 2000 2010 it grew"},
+		{"it cost 123456789 dollars", "it cost 123456789 dollars"},
+		{"from 1000 2000 3000 people", "from 1000 2000 3000 people"},
+		{"on 05.03.2021 at noon", "on 05.03.2021 at noon"},
+		{"on 01 02 2020 at noon", "on 01 02 2020 at noon"},
+		{"on 2020-01-15 at noon", "on 2020-01-15 at noon"},
+		{"about 10.000 20.000 people", "about 10.000 20.000 people"},
+	}
+	for _, tt := range tests {
+		res := &models.WhisperResult{Segments: []models.Segment{{ID: "1", Text: tt.text}}}
+		r.Result(res, "")
+		if got := res.Segments[0].Text; got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
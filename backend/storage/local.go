@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Disk stores the files in a directory of the local disk.
+type Disk struct {
+	Dir string
+}
+
+func NewDisk(dir string) *Disk {
+	return &Disk{Dir: dir}
+}
+
+func (d *Disk) path(name string) (string, error) {
+	name, err := cleanName(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d.Dir, filepath.FromSlash(name)), nil
+}
+
+func (d *Disk) Save(ctx context.Context, name string, r io.Reader) error {
+	dst, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// The file is written next to its destination, and renamed once complete
+	f, err := tempFile(filepath.Dir(dst), name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (d *Disk) SaveFile(ctx context.Context, name, path string) error {
+	dst, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return moveFile(path, dst)
+}
+
+func (d *Disk) Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return limitReadCloser{io.LimitReader(f, length), f}, nil
+}
+
+func (d *Disk) Stat(ctx context.Context, name string) (Info, error) {
+	path, err := d.path(name)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (d *Disk) Delete(ctx context.Context, name string) error {
+	path, err := d.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Local returns the path of the stored file itself.
+func (d *Disk) Local(ctx context.Context, name string) (string, func(), error) {
+	path, err := d.path(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", nil, err
+	}
+	return path, func() {}, nil
+}
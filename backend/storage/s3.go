@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Files larger than this are uploaded in parts of this size, as a single
+// upload is limited to 5 GiB.
+const defaultPartSize = 64 * 1024 * 1024
+
+// S3 stores the files in a bucket of an S3-compatible service, such as AWS S3 or
+// MinIO. Requests are signed with the AWS Signature Version 4, and the bucket is
+// addressed in the path of the endpoint.
+type S3 struct {
+	Endpoint  *url.URL
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to the names of the files, to share a bucket.
+	Prefix string
+	// PartSize is the size of the parts of large uploads, 64 MiB if 0.
+	PartSize int64
+	// Client sends the requests, http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// s3Error is the error returned by S3 for failed requests.
+type s3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3: %v %v: %v", e.Status, e.Code, e.Message)
+}
+
+func (e *s3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Status == http.StatusNotFound
+}
+
+// request sends a signed request for a file, or for the bucket if name is empty.
+// The body is not signed, unless it is given as bytes. Responses with an error
+// status are returned as an error.
+func (s *S3) request(ctx context.Context, method, name string, query url.Values, header http.Header, body io.Reader, size int64) (*http.Response, error) {
+	u := *s.Endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket
+	if name != "" {
+		u.Path += "/" + s.Prefix + name
+	}
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	payloadHash := emptyPayloadHash
+	if b, ok := body.(*bytes.Reader); ok {
+		data := make([]byte, b.Len())
+		b.Read(data)
+		b.Seek(0, io.SeekStart)
+		payloadHash = sha256Hex(data)
+	} else if body != nil {
+		payloadHash = unsignedPayload
+		req.ContentLength = size
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, s.AccessKey, s.SecretKey, s.Region, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		defer res.Body.Close()
+		e := &s3Error{Status: res.StatusCode}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+		if xml.Unmarshal(data, e) != nil || e.Code == "" {
+			e.Code = http.StatusText(res.StatusCode)
+		}
+		return nil, e
+	}
+	return res, nil
+}
+
+func (s *S3) Save(ctx context.Context, name string, r io.Reader) error {
+	if _, err := cleanName(name); err != nil {
+		return err
+	}
+	// S3 needs the length of the content before it is sent
+	f, err := tempFile("", name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.SaveFile(ctx, name, f.Name())
+}
+
+func (s *S3) SaveFile(ctx context.Context, name, path string) error {
+	if _, err := cleanName(name); err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	partSize := s.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	if stat.Size() > partSize {
+		err = s.uploadParts(ctx, name, f, stat.Size(), partSize)
+	} else {
+		var res *http.Response
+		res, err = s.request(ctx, http.MethodPut, name, nil, nil, f, stat.Size())
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(path)
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts uploads a large file with a multipart upload, which is aborted if
+// a part fails.
+func (s *S3) uploadParts(ctx context.Context, name string, f *os.File, size, partSize int64) error {
+	res, err := s.request(ctx, http.MethodPost, name, url.Values{"uploads": {""}}, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(res.Body).Decode(&initiated)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	complete := completeMultipartUpload{}
+	for offset, part := int64(0), 1; offset < size; offset, part = offset+partSize, part+1 {
+		length := partSize
+		if size-offset < length {
+			length = size - offset
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {initiated.UploadID}}
+		res, err := s.request(ctx, http.MethodPut, name, query, nil, io.NewSectionReader(f, offset, length), length)
+		if err != nil {
+			s.abortUpload(name, initiated.UploadID)
+			return err
+		}
+		res.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: part, ETag: res.Header.Get("ETag")})
+	}
+
+	data, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	res, err = s.request(ctx, http.MethodPost, name, url.Values{"uploadId": {initiated.UploadID}}, nil, bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		s.abortUpload(name, initiated.UploadID)
+		return err
+	}
+	defer res.Body.Close()
+	// The completion may fail after a 200 OK, with an error in the body
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	e := &s3Error{Status: res.StatusCode}
+	if xml.Unmarshal(body, e) == nil && e.Code != "" {
+		return e
+	}
+	return nil
+}
+
+func (s *S3) abortUpload(name, uploadID string) {
+	res, err := s.request(context.Background(), http.MethodDelete, name, url.Values{"uploadId": {uploadID}}, nil, nil, 0)
+	if err == nil {
+		res.Body.Close()
+	}
+}
+
+func (s *S3) Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	if _, err := cleanName(name); err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if length >= 0 {
+		if length == 0 {
+			return io.NopCloser(strings.NewReader("")), nil
+		}
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := s.request(ctx, http.MethodGet, name, nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, name string) (Info, error) {
+	if _, err := cleanName(name); err != nil {
+		return Info{}, err
+	}
+	res, err := s.request(ctx, http.MethodHead, name, nil, nil, nil, 0)
+	if err != nil {
+		return Info{}, err
+	}
+	res.Body.Close()
+	modTime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return Info{Size: res.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if _, err := cleanName(name); err != nil {
+		return err
+	}
+	res, err := s.request(ctx, http.MethodDelete, name, nil, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// Local downloads the file to a temporary file, which is deleted on release.
+func (s *S3) Local(ctx context.Context, name string) (string, func(), error) {
+	r, err := s.Open(ctx, name, 0, -1)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	f, err := tempFile("", name)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", nil, err
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }, nil
+}
@@ -0,0 +1,334 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a stand-in for an S3 service, with a single bucket. It checks the
+// signature of every request, and supports the requests made by S3.
+type fakeS3 struct {
+	t         *testing.T
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	uploads  map[string]map[int][]byte
+	requests []string
+	// failPart is the number of a part whose upload fails.
+	failPart int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *S3) {
+	f := &fakeS3{
+		t:         t,
+		bucket:    "media",
+		accessKey: exampleAccessKey,
+		secretKey: exampleSecretKey,
+		region:    "eu-west-1",
+		objects:   map[string][]byte{},
+		uploads:   map[string]map[int][]byte{},
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	endpoint, _ := url.Parse(server.URL)
+	return f, &S3{
+		Endpoint:  endpoint,
+		Bucket:    f.bucket,
+		Region:    f.region,
+		AccessKey: f.accessKey,
+		SecretKey: f.secretKey,
+		Client:    server.Client(),
+	}
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%v</Code><Message>%v</Message></Error>", code, code)
+}
+
+// verify checks that the request is signed as S3 expects: the path is sent in
+// its canonical encoding, and the signature is the one of the secret key.
+func (f *fakeS3) verify(r *http.Request) bool {
+	if r.URL.EscapedPath() != uriEncode(r.URL.Path, false) {
+		f.t.Errorf("path %v is not in its canonical encoding", r.URL.EscapedPath())
+		return false
+	}
+	date, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest(r.Method, "http://"+r.Host+r.RequestURI, nil)
+	if err != nil {
+		return false
+	}
+	for key, values := range r.Header {
+		if key != "Authorization" {
+			req.Header[key] = values
+		}
+	}
+	signV4(req, f.accessKey, f.secretKey, f.region, date)
+	return req.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.verify(r) {
+		f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		f.fail(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); hash != unsignedPayload && hash != sha256Hex(body) {
+		f.fail(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/") {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RawQuery)
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%v</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		if n == f.failPart {
+			f.fail(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%v"`, sha256Hex(body)))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		var complete completeMultipartUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			f.fail(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"%v"`, sha256Hex(parts[part.PartNumber])) {
+				f.fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, parts[part.PartNumber]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		http.ServeContent(w, r, key, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func readAll(t *testing.T, r io.ReadCloser, err error) string {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestS3SaveOpen(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.Prefix = "whishper/"
+	ctx := context.Background()
+	name := "2024/a b+c é.mp4"
+	content := "0123456789abcdefghij"
+	if err := s.Save(ctx, name, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.objects["whishper/"+name]) != content {
+		t.Fatalf("stored %q", f.objects["whishper/"+name])
+	}
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, content},
+		{5, -1, content[5:]},
+		{5, 3, "567"},
+		{0, 1, "0"},
+		{19, 10, "j"},
+		{3, 0, ""},
+	}
+	for _, tt := range tests {
+		r, err := s.Open(ctx, name, tt.offset, tt.length)
+		if got := readAll(t, r, err); got != tt.want {
+			t.Errorf("Open(%d, %d) = %q, want %q", tt.offset, tt.length, got, tt.want)
+		}
+	}
+
+	info, err := s.Stat(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(content)) || info.ModTime.IsZero() {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	if err := s.Delete(ctx, name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(ctx, name); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat of a deleted file: %v", err)
+	}
+	if _, err := s.Open(ctx, name, 0, -1); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open of a deleted file: %v", err)
+	}
+	if err := s.Delete(ctx, name); err != nil {
+		t.Errorf("Delete of a deleted file: %v", err)
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.PartSize = 10
+	ctx := context.Background()
+	content := strings.Repeat("0123456789", 2) + "abcde"
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveFile(ctx, "big.mp4", path); err != nil {
+		t.Fatal(err)
+	}
+	if string(f.objects["big.mp4"]) != content {
+		t.Errorf("stored %q", f.objects["big.mp4"])
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the local file was not removed: %v", err)
+	}
+	var parts []string
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, "PUT partNumber=") {
+			parts = append(parts, r)
+		}
+	}
+	if len(parts) != 3 || len(f.uploads) != 0 {
+		t.Errorf("uploaded parts %v, left uploads %v", parts, f.uploads)
+	}
+
+	r, err := s.Open(ctx, "big.mp4", 8, 4)
+	if got := readAll(t, r, err); got != "8901" {
+		t.Errorf("Open across parts = %q", got)
+	}
+}
+
+func TestS3MultipartAbort(t *testing.T) {
+	f, s := newFakeS3(t)
+	s.PartSize = 10
+	path := filepath.Join(t.TempDir(), "upload")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 25)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.failPart = 2
+	if err := s.SaveFile(context.Background(), "big.mp4", path); err == nil {
+		t.Fatal("the upload did not fail")
+	}
+	if len(f.uploads) != 0 || len(f.objects) != 0 {
+		t.Errorf("the upload was not aborted: %v, %v", f.uploads, f.objects)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("the local file of a failed upload was removed: %v", err)
+	}
+}
+
+func TestS3Local(t *testing.T) {
+	_, s := newFakeS3(t)
+	ctx := context.Background()
+	if err := s.Save(ctx, "a.wav", strings.NewReader("RIFF")); err != nil {
+		t.Fatal(err)
+	}
+	path, release, err := s.Local(ctx, "a.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "RIFF" || filepath.Ext(path) != ".wav" {
+		t.Errorf("local copy %v: %q, %v", path, data, err)
+	}
+	release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the local copy was not removed: %v", err)
+	}
+}
+
+func TestS3BadSignature(t *testing.T) {
+	_, s := newFakeS3(t)
+	s.SecretKey = "wrong"
+	err := s.Save(context.Background(), "a.wav", strings.NewReader("RIFF"))
+	var e *s3Error
+	if !errors.As(err, &e) || e.Status != http.StatusForbidden || e.Code != "SignatureDoesNotMatch" {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestS3InvalidName(t *testing.T) {
+	f, s := newFakeS3(t)
+	for _, name := range []string{"../a.wav", "/a.wav", "a/../../b", ""} {
+		if err := s.Save(context.Background(), name, strings.NewReader("x")); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Save(%q): %v", name, err)
+		}
+	}
+	if len(f.objects) > 0 {
+		t.Errorf("stored %v", f.objects)
+	}
+}
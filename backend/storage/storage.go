@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Info describes a stored file.
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores the media files, and the files made from them, by name. Names
+// are relative paths with forward slashes. Files that do not exist are reported
+// with errors that match fs.ErrNotExist.
+type Storage interface {
+	// Save stores the content of r as the file name, replacing it if it exists.
+	Save(ctx context.Context, name string, r io.Reader) error
+	// SaveFile stores a local file as the file name. The local file is moved, so
+	// it does not exist anymore once SaveFile succeeds.
+	SaveFile(ctx context.Context, name, path string) error
+	// Open reads length bytes of a file from offset, or all of them from offset
+	// if length is negative.
+	Open(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, name string) (Info, error)
+	// Delete deletes a file. Deleting a file that does not exist is not an error.
+	Delete(ctx context.Context, name string) error
+	// Local returns the path of a local copy of a file, for the programs that can
+	// only read files such as ffmpeg. The copy must be released once it is not
+	// needed anymore.
+	Local(ctx context.Context, name string) (path string, release func(), err error)
+}
+
+// New returns the storage configured by the STORAGE environment variable:
+// `local` (the default) stores the files in UPLOAD_DIR, and `s3` in an
+// S3-compatible bucket configured by the S3_* variables.
+func New() (Storage, error) {
+	switch os.Getenv("STORAGE") {
+	case "", "local":
+		return NewDisk(os.Getenv("UPLOAD_DIR")), nil
+	case "s3":
+		endpoint, err := url.Parse(os.Getenv("S3_ENDPOINT"))
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid S3_ENDPOINT %q", os.Getenv("S3_ENDPOINT"))
+		}
+		s := &S3{
+			Endpoint:  endpoint,
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+		}
+		if s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+			return nil, fmt.Errorf("S3_BUCKET, S3_ACCESS_KEY and S3_SECRET_KEY are required")
+		}
+		if s.Region == "" {
+			s.Region = "us-east-1"
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("unknown storage %v", os.Getenv("STORAGE"))
+}
+
+// cleanName checks that a name stays inside the storage.
+func cleanName(name string) (string, error) {
+	clean := path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))[1:]
+	if clean == "" || clean != name {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return clean, nil
+}
+
+// tempFile creates an empty temporary file with the extension of name, so that
+// programs that guess the format from the extension still can.
+func tempFile(dir, name string) (*os.File, error) {
+	return os.CreateTemp(dir, "whishper-*"+filepath.Ext(name))
+}
+
+// moveFile renames a file, or copies it when it is moved to another device.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// limitReadCloser limits the bytes read from a ReadCloser, and closes it.
+type limitReadCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"codeberg.org/pluja/whishper/models"
+)
+
+const (
+	SubtitleFormatSrt  = "srt"
+	SubtitleFormatVtt  = "vtt"
+	SubtitleFormatJson = "json"
+)
+
+var (
+	cueTimingRegex = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[,.]\d{1,3})`)
+	voiceTagRegex  = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]+)>`)
+	tagRegex       = regexp.MustCompile(`</?[^>]+>`)
+	unitRegex      = regexp.MustCompile(`^\d+$`)
+	secondsRegex   = regexp.MustCompile(`^\d+\.\d+$`)
+)
+
+// DetectSubtitleFormat guesses the format of a subtitle file from its name and content.
+func DetectSubtitleFormat(filename string, data []byte) string {
+	switch strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), ".")) {
+	case SubtitleFormatSrt:
+		return SubtitleFormatSrt
+	case SubtitleFormatVtt:
+		return SubtitleFormatVtt
+	case SubtitleFormatJson:
+		return SubtitleFormatJson
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("WEBVTT")):
+		return SubtitleFormatVtt
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return SubtitleFormatJson
+	}
+	return SubtitleFormatSrt
+}
+
+// ParseSubtitles parses a SRT, WebVTT or Whisper JSON file into a WhisperResult.
+func ParseSubtitles(format string, data []byte) (*models.WhisperResult, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var res *models.WhisperResult
+	var err error
+	switch format {
+	case SubtitleFormatSrt, SubtitleFormatVtt:
+		res, err = parseCues(data)
+	case SubtitleFormatJson:
+		res, err = parseWhisperJson(data)
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Segments) == 0 {
+		return nil, errors.New("no segments found")
+	}
+	res.Text = models.TextFromSegments(res.Segments)
+	for _, seg := range res.Segments {
+		if seg.End > res.Duration {
+			res.Duration = seg.End
+		}
+	}
+	return res, nil
+}
+
+// parseCues parses both SRT and WebVTT files: both are made of blocks separated by
+// blank lines, with an optional identifier line, a timing line and the cue text.
+// Blocks without a timing line (WebVTT header, NOTE, STYLE, REGION) are skipped.
+func parseCues(data []byte) (*models.WhisperResult, error) {
+	res := &models.WhisperResult{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var seg *models.Segment
+	var lines []string
+	flush := func() {
+		if seg != nil {
+			text := strings.Join(lines, " ")
+			if m := voiceTagRegex.FindStringSubmatch(text); m != nil {
+				seg.Speaker = strings.TrimSpace(m[1])
+			}
+			seg.Text = strings.TrimSpace(tagRegex.ReplaceAllString(text, ""))
+			if seg.Text != "" {
+				seg.ID = models.NewSegmentID()
+				seg.Words = []models.Word{}
+				res.Segments = append(res.Segments, *seg)
+			}
+		}
+		seg = nil
+		lines = nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if seg == nil {
+			m := cueTimingRegex.FindStringSubmatch(line)
+			if m == nil {
+				// Cue identifier or a block without timing
+				continue
+			}
+			start, err := ParseTimestamp(m[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := ParseTimestamp(m[2])
+			if err != nil {
+				return nil, err
+			}
+			// Subtitles are assumed to be reviewed by a human, hence the full score.
+			seg = &models.Segment{Start: start, End: end, Score: 1}
+			continue
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	flush()
+	return res, scanner.Err()
+}
+
+// ParseTimestamp parses seconds, [hh:]mm:ss,mmm or [hh:]mm:ss.mmm into seconds.
+// Only digits are accepted, so timestamps are never negative, and the minutes
+// and seconds that follow a larger unit must be below 60.
+func ParseTimestamp(ts string) (float64, error) {
+	ts = strings.Replace(ts, ",", ".", 1)
+	parts := strings.Split(ts, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+	var seconds float64
+	for i, p := range parts {
+		last := i == len(parts)-1
+		if !(last && secondsRegex.MatchString(p)) && !unitRegex.MatchString(p) {
+			return 0, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || (i > 0 && v >= 60) {
+			return 0, fmt.Errorf("invalid timestamp %q", ts)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// whisperJson accepts the results of whishper, openai-whisper, faster-whisper and WhisperX.
+type whisperJson struct {
+	Language string  `json:"language"`
+	Duration float64 `json:"duration"`
+	Segments []struct {
+		ID         json.RawMessage `json:"id"`
+		Start      float64         `json:"start"`
+		End        float64         `json:"end"`
+		Text       string          `json:"text"`
+		Score      *float64        `json:"score"`
+		AvgLogprob *float64        `json:"avg_logprob"`
+		Speaker    string          `json:"speaker"`
+		Words      []struct {
+			Word        string   `json:"word"`
+			Start       float64  `json:"start"`
+			End         float64  `json:"end"`
+			Score       *float64 `json:"score"`
+			Probability *float64 `json:"probability"`
+		} `json:"words"`
+	} `json:"segments"`
+}
+
+func parseWhisperJson(data []byte) (*models.WhisperResult, error) {
+	var wj whisperJson
+	if err := json.Unmarshal(data, &wj); err != nil {
+		return nil, err
+	}
+	res := &models.WhisperResult{
+		Language: wj.Language,
+		Duration: wj.Duration,
+	}
+	for _, s := range wj.Segments {
+		seg := models.Segment{
+			ID:      models.NewSegmentID(),
+			Start:   s.Start,
+			End:     s.End,
+			Text:    s.Text,
+			Speaker: s.Speaker,
+			Score:   1,
+			Words:   []models.Word{},
+		}
+		if s.Score != nil {
+			seg.Score = *s.Score
+		} else if s.AvgLogprob != nil {
+			seg.Score = math.Exp(*s.AvgLogprob)
+		}
+		var id string
+		if json.Unmarshal(s.ID, &id) == nil && id != "" {
+			seg.ID = id
+		}
+		for _, w := range s.Words {
+			word := models.Word{Word: w.Word, Start: w.Start, End: w.End}
+			if w.Score != nil {
+				word.Score = *w.Score
+			} else if w.Probability != nil {
+				word.Score = *w.Probability
+			}
+			seg.Words = append(seg.Words, word)
+		}
+		res.Segments = append(res.Segments, seg)
+	}
+	return res, nil
+}
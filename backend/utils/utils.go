@@ -3,15 +3,19 @@ package utils
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/rs/zerolog/log"
@@ -31,7 +35,9 @@ func SanitizeFilename(filename string) string {
 	return filename
 }
 
-func DownloadMedia(t *models.Transcription) (string, error) {
+// DownloadMedia downloads the media of the source URL of a transcription to dir,
+// and returns the name of the file.
+func DownloadMedia(t *models.Transcription, dir string) (string, error) {
 	if t.SourceUrl == "" {
 		log.Debug().Msg("Source URL is empty")
 		return "", fmt.Errorf("source URL is empty")
@@ -59,19 +65,65 @@ func DownloadMedia(t *models.Transcription) (string, error) {
 	filename = SanitizeFilename(filename)
 
 	defer downloadResult.Close()
-	f, err := os.Create(filepath.Join(os.Getenv("UPLOAD_DIR"), filename))
+	f, err := os.Create(filepath.Join(dir, filename))
 	if err != nil {
 		log.Debug().Err(err).Msg("Error creating file")
 		return "", err
 	}
 	defer f.Close()
-	io.Copy(f, downloadResult)
+	if _, err := io.Copy(f, downloadResult); err != nil {
+		log.Debug().Err(err).Msg("Error writing file")
+		return "", err
+	}
 
 	return filename, nil
 }
 
-func SendTranscriptionRequest(t *models.Transcription, body *bytes.Buffer, writer *multipart.Writer) (*models.WhisperResult, error) {
-	url := fmt.Sprintf("http://%v/transcribe?model_size=%v&task=%v&language=%v&device=%v", os.Getenv("ASR_ENDPOINT"), t.ModelSize, t.Task, t.Language, t.Device)
+// decodingQuery returns the query parameters of the ASR service for the decoding options.
+func decodingQuery(o models.DecodingOptions) neturl.Values {
+	temperatures := make([]string, len(o.Temperatures))
+	for i, t := range o.Temperatures {
+		temperatures[i] = strconv.FormatFloat(t, 'f', -1, 64)
+	}
+	q := neturl.Values{}
+	q.Set("beam_size", strconv.Itoa(o.BeamSize))
+	q.Set("temperature", strings.Join(temperatures, ","))
+	q.Set("vad_filter", strconv.FormatBool(o.VadFilter))
+	if o.VadFilter {
+		q.Set("vad_threshold", strconv.FormatFloat(o.VadThreshold, 'f', -1, 64))
+		q.Set("vad_min_silence_ms", strconv.Itoa(o.VadMinSilenceMs))
+		q.Set("vad_speech_pad_ms", strconv.Itoa(o.VadSpeechPadMs))
+	}
+	q.Set("word_timestamps", strconv.FormatBool(*o.WordTimestamps))
+	q.Set("condition_on_previous_text", strconv.FormatBool(*o.ConditionOnPreviousText))
+	q.Set("compute_type", o.ComputeType)
+	return q
+}
+
+// DiarizationSupported tells if the ASR service labels the speakers, which is
+// declared with ASR_DIARIZATION. The bundled transcription-api does not.
+func DiarizationSupported() bool {
+	return os.Getenv("ASR_DIARIZATION") == "true"
+}
+
+// SendTranscriptionRequest sends the media to the ASR service. The task is either
+// models.TaskTranscribe or models.TaskTranslate, as the other tasks of a
+// transcription are built on top of these two.
+func SendTranscriptionRequest(t *models.Transcription, task string, body *bytes.Buffer, writer *multipart.Writer) (*models.WhisperResult, error) {
+	url := fmt.Sprintf("http://%v/transcribe?model_size=%v&task=%v&language=%v&device=%v", os.Getenv("ASR_ENDPOINT"), t.ModelSize, task, t.Language, t.Device)
+	if t.Diarize {
+		url += "&diarize=true"
+		if t.NumSpeakers > 0 {
+			url += fmt.Sprintf("&num_speakers=%v", t.NumSpeakers)
+		}
+	}
+	if t.InitialPrompt != "" {
+		url += "&initial_prompt=" + neturl.QueryEscape(t.InitialPrompt)
+	}
+	if len(t.Hotwords) > 0 {
+		url += "&hotwords=" + neturl.QueryEscape(strings.Join(t.Hotwords, ","))
+	}
+	url += "&" + decodingQuery(t.Options.WithDefaults(t.Device)).Encode()
 	// Send transcription request to transcription service
 	req, err := http.NewRequest("POST", url, body)
 	if err != nil {
@@ -109,3 +161,12 @@ func SendTranscriptionRequest(t *models.Transcription, body *bytes.Buffer, write
 	return asrResponse, nil
 
 }
+
+// Hash returns the SHA-256 of the content of a reader, in hex.
+func Hash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,149 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/models"
+	"codeberg.org/pluja/whishper/utils"
+)
+
+// Folder is a watched folder, with the settings of the transcriptions of the
+// media files that appear in it.
+type Folder struct {
+	Path          string   `json:"path"`
+	Language      string   `json:"language"`
+	ModelSize     string   `json:"modelSize"`
+	Device        string   `json:"device"`
+	Task          string   `json:"task"`
+	Diarize       bool     `json:"diarize"`
+	NumSpeakers   int      `json:"numSpeakers"`
+	InitialPrompt string   `json:"initialPrompt"`
+	Hotwords      []string `json:"hotwords"`
+	// Vocabulary is the id of a vocabulary whose words are added to the hotwords.
+	Vocabulary string                    `json:"vocabulary"`
+	Options    models.DecodingOptions    `json:"options"`
+	Preprocess *models.PreprocessOptions `json:"preprocess"`
+	// Reuse copies the result of a done transcription of the same media with the
+	// same settings, instead of transcribing it again.
+	Reuse bool `json:"reuse"`
+	// Translations are made to these languages once the transcription is done.
+	Translations []string `json:"translations"`
+	// Exports are written next to the source file in these formats, once the
+	// translations are done.
+	Exports []string `json:"exports"`
+	// MoveTo is the folder the source file is moved to once it is processed,
+	// together with its exports. The file stays in place if it is empty.
+	MoveTo string `json:"moveTo"`
+}
+
+// LoadFolders reads the watched folders from the JSON file given by the
+// WATCH_CONFIG environment variable. There are none if it is not set.
+func LoadFolders() ([]Folder, error) {
+	path := os.Getenv("WATCH_CONFIG")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var folders []Folder
+	if err := json.Unmarshal(data, &folders); err != nil {
+		return nil, fmt.Errorf("invalid watched folders in %v: %w", path, err)
+	}
+	for i := range folders {
+		if err := folders[i].normalize(); err != nil {
+			return nil, fmt.Errorf("watched folder %v: %w", folders[i].Path, err)
+		}
+	}
+	// A file moved to a watched folder would be taken again, and moved again
+	watched := make(map[string]string, len(folders))
+	for _, f := range folders {
+		watched[resolvePath(f.Path)] = f.Path
+	}
+	for _, f := range folders {
+		if f.MoveTo == "" {
+			continue
+		}
+		if other, ok := watched[resolvePath(f.MoveTo)]; ok {
+			return nil, fmt.Errorf("watched folder %v: moveTo is the watched folder %v", f.Path, other)
+		}
+	}
+	return folders, nil
+}
+
+// resolvePath returns the absolute path of a folder, with the symbolic links
+// resolved, so that two paths of the same folder are equal.
+func resolvePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	return path
+}
+
+// normalize checks the settings of the folder, and fills in the defaults.
+func (f *Folder) normalize() error {
+	if f.Path == "" {
+		return fmt.Errorf("the path is required")
+	}
+	f.Path = filepath.Clean(f.Path)
+	if stat, err := os.Stat(f.Path); err != nil || !stat.IsDir() {
+		return fmt.Errorf("the path is not a directory")
+	}
+	if f.MoveTo != "" {
+		f.MoveTo = filepath.Clean(f.MoveTo)
+		if err := os.MkdirAll(f.MoveTo, 0755); err != nil {
+			return err
+		}
+	}
+	if f.Language == "" {
+		f.Language = "auto"
+	}
+	if f.ModelSize == "" {
+		f.ModelSize = "small"
+	}
+	if f.Device != "cuda" {
+		f.Device = "cpu"
+	}
+	switch f.Task {
+	case "":
+		f.Task = models.TaskTranscribe
+	case models.TaskTranscribe, models.TaskTranslate:
+	default:
+		return fmt.Errorf("task %v not supported", f.Task)
+	}
+	if f.Diarize && !utils.DiarizationSupported() {
+		return fmt.Errorf("the ASR service does not support speaker diarization")
+	}
+	if err := f.Options.Validate(f.Device); err != nil {
+		return fmt.Errorf("invalid decoding options: %w", err)
+	}
+	f.Options = f.Options.WithDefaults(f.Device)
+	if f.Preprocess != nil {
+		if err := f.Preprocess.Validate(nil); err != nil {
+			return fmt.Errorf("invalid pre-processing options: %w", err)
+		}
+	}
+	for _, format := range f.Exports {
+		if !contains(export.Formats, format) {
+			return fmt.Errorf("unsupported export format %v", format)
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package watcher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadFoldersMoveTo(t *testing.T) {
+	dir := t.TempDir()
+	in, other := filepath.Join(dir, "in"), filepath.Join(dir, "other")
+	for _, path := range []string{in, other} {
+		if err := os.Mkdir(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(other, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		folders []Folder
+		err     string
+	}{
+		{"done folder", []Folder{{Path: in, MoveTo: filepath.Join(dir, "done")}}, ""},
+		{"subfolder", []Folder{{Path: in, MoveTo: filepath.Join(in, "done")}}, ""},
+		{"itself", []Folder{{Path: in, MoveTo: in + "/"}}, "moveTo is the watched folder"},
+		{"relative", []Folder{{Path: in, MoveTo: filepath.Join(in, "..", "in")}}, "moveTo is the watched folder"},
+		{"other folder", []Folder{{Path: in, MoveTo: other}, {Path: other}}, "moveTo is the watched folder"},
+		{"link", []Folder{{Path: in, MoveTo: link}, {Path: other}}, "moveTo is the watched folder"},
+	}
+	for _, tt := range tests {
+		data, _ := json.Marshal(tt.folders)
+		config := filepath.Join(dir, "watch.json")
+		if err := os.WriteFile(config, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("WATCH_CONFIG", config)
+		_, err := LoadFolders()
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%v: got %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,405 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"codeberg.org/pluja/whishper/api"
+	"codeberg.org/pluja/whishper/database"
+	"codeberg.org/pluja/whishper/export"
+	"codeberg.org/pluja/whishper/media"
+	"codeberg.org/pluja/whishper/models"
+)
+
+// Author of the revisions made by the watcher.
+const author = "watcher"
+
+// Suffixes of files that are still being written by another program.
+var partialSuffixes = []string{".part", ".partial", ".tmp", ".crdownload", ".download"}
+
+// fileState is the size and modification time of a file seen in a folder. A file
+// is complete once its state does not change between two polls.
+type fileState struct {
+	size int64
+	// modTime is in milliseconds, as precise as the times of the database.
+	modTime int64
+}
+
+func newFileState(size int64, modTime time.Time) fileState {
+	return fileState{size: size, modTime: modTime.UnixMilli()}
+}
+
+// seenFile is a file that was already ingested, or rejected. A later file with
+// the same path, such as one dropped again once the first was moved, is new.
+type seenFile struct {
+	path  string
+	state fileState
+}
+
+type watcher struct {
+	s       *api.Server
+	folders []Folder
+	seen    map[seenFile]bool
+	// pending are the files that are still being written.
+	pending map[string]fileState
+}
+
+// Start polls the watched folders for new media files, and transcribes them with
+// the settings of their folder. It returns at once if there are no folders.
+func Start(s *api.Server) {
+	folders, err := LoadFolders()
+	if err != nil {
+		log.Error().Err(err).Msg("Error loading watched folders")
+		return
+	}
+	if len(folders) == 0 {
+		return
+	}
+
+	interval := 10 * time.Second
+	if v := os.Getenv("WATCH_INTERVAL"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			log.Warn().Msgf("Invalid WATCH_INTERVAL %v, using %v", v, interval)
+		} else {
+			interval = time.Duration(seconds) * time.Second
+		}
+	}
+
+	w := &watcher{
+		s:       s,
+		folders: folders,
+		seen:    map[seenFile]bool{},
+		pending: map[string]fileState{},
+	}
+	for _, t := range s.Db.GetWatchedTranscriptions() {
+		state := newFileState(t.Watch.Size, t.Watch.ModTime)
+		if t.Watch.ModTime.IsZero() {
+			// Taken before the state was recorded: the file in place, if any, is
+			// assumed to be the one that was taken
+			info, err := os.Stat(t.Watch.Path)
+			if err != nil {
+				continue
+			}
+			state = newFileState(info.Size(), info.ModTime())
+		}
+		w.seen[seenFile{t.Watch.Path, state}] = true
+	}
+	for _, r := range s.Db.GetWatchRejections() {
+		w.seen[seenFile{r.Path, newFileState(r.Size, r.ModTime)}] = true
+	}
+
+	log.Info().Msgf("Watching %d folders", len(folders))
+	for {
+		for i := range w.folders {
+			w.scan(&w.folders[i])
+		}
+		w.finish()
+		time.Sleep(interval)
+	}
+}
+
+// scan ingests the files of a folder that are complete and were not seen yet.
+func (w *watcher) scan(f *Folder) {
+	entries, err := os.ReadDir(f.Path)
+	if err != nil {
+		log.Error().Err(err).Msgf("Error reading watched folder %v", f.Path)
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(f.Path, entry.Name())
+		if entry.IsDir() || ignored(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		state := newFileState(info.Size(), info.ModTime())
+		if w.seen[seenFile{path, state}] {
+			continue
+		}
+		if last, ok := w.pending[path]; !ok || last != state || state.size == 0 {
+			// Wait for the next poll, to be sure the file is fully written
+			w.pending[path] = state
+			continue
+		}
+		delete(w.pending, path)
+		w.seen[seenFile{path, state}] = true
+		if err := w.ingest(f, path, info); err != nil {
+			log.Error().Err(err).Msgf("Error ingesting %v", path)
+		}
+	}
+}
+
+// ignored tells if a file of a watched folder is not media to transcribe: hidden
+// files, partial downloads, and the exports written by the watcher.
+func ignored(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return true
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, suffix := range partialSuffixes {
+		if ext == suffix {
+			return true
+		}
+	}
+	for _, format := range export.Formats {
+		if ext == "."+format {
+			return true
+		}
+	}
+	return false
+}
+
+// ingest stores a copy of a file, and creates its transcription. Files that are
+// not valid media are recorded, so that they are skipped after a restart.
+func (w *watcher) ingest(f *Folder, path string, stat fs.FileInfo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	info, err := media.Probe(ctx, path)
+	if media.IsInvalid(err) {
+		log.Warn().Err(err).Msgf("Skipping %v", path)
+		_, err = w.s.Db.NewWatchRejection(&models.WatchRejection{
+			Folder:    f.Path,
+			Path:      path,
+			Size:      stat.Size(),
+			ModTime:   stat.ModTime(),
+			Reason:    err.Error(),
+			CreatedAt: time.Now(),
+		})
+		return err
+	}
+	if err != nil {
+		log.Warn().Err(err).Msgf("Could not probe %v", path)
+	}
+
+	fileName := api.UploadFileName(filepath.Base(path))
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := w.s.Storage.Save(context.Background(), fileName, src); err != nil {
+		return err
+	}
+
+	t := models.Transcription{
+		Status:        models.TranscriptionStatusPending,
+		Language:      f.Language,
+		ModelSize:     f.ModelSize,
+		Task:          f.Task,
+		Device:        f.Device,
+		FileName:      fileName,
+		Diarize:       f.Diarize,
+		NumSpeakers:   f.NumSpeakers,
+		InitialPrompt: f.InitialPrompt,
+		Hotwords:      f.Hotwords,
+		Options:       f.Options,
+		Reuse:         f.Reuse,
+		Media:         info,
+		Watch:         &models.WatchSource{Folder: f.Path, Path: path, Size: stat.Size(), ModTime: stat.ModTime()},
+	}
+	if t.Task == models.TaskTranslate {
+		t.TranslationOutput = models.TranslationOutputTranslation
+	}
+	if f.Vocabulary != "" {
+		if v := w.s.Db.GetVocabulary(f.Vocabulary); v != nil {
+			t.Hotwords = models.MergeHotwords(t.Hotwords, v.Words)
+		} else {
+			log.Warn().Msgf("Vocabulary %v of watched folder %v not found", f.Vocabulary, f.Path)
+		}
+	}
+	if f.Preprocess != nil {
+		p := *f.Preprocess
+		if err := p.Validate(info); err != nil {
+			log.Warn().Err(err).Msgf("Not pre-processing %v", path)
+		} else {
+			t.Preprocess = &p
+		}
+	}
+
+	if _, err := w.s.CreateTranscription(&t); err != nil {
+		w.s.Storage.Delete(context.Background(), fileName)
+		return err
+	}
+	log.Info().Msgf("Transcribing %v from watched folder %v", path, f.Path)
+	return nil
+}
+
+// finish runs the translations and exports of the transcriptions that are done,
+// and moves their source files.
+func (w *watcher) finish() {
+	for _, t := range w.s.Db.GetWatchedTranscriptions() {
+		if t.Watch.Processed {
+			continue
+		}
+		if t.Status != models.TranscriptionStatusDone && t.Status != models.TranscriptionStatusError {
+			continue
+		}
+		f := w.folder(t.Watch.Folder)
+		if f == nil {
+			continue
+		}
+		t = w.s.Db.GetTranscription(t.ID.Hex())
+		if t == nil {
+			continue
+		}
+		if err := w.process(f, t); err != nil {
+			log.Error().Err(err).Msgf("Error processing %v", t.Watch.Path)
+		}
+	}
+}
+
+func (w *watcher) folder(path string) *Folder {
+	for i := range w.folders {
+		if w.folders[i].Path == path {
+			return &w.folders[i]
+		}
+	}
+	return nil
+}
+
+// process translates a finished transcription, moves its source and writes its
+// exports. Failed transcriptions are only moved.
+func (w *watcher) process(f *Folder, t *models.Transcription) error {
+	done := t.Status == models.TranscriptionStatusDone
+	if done {
+		for _, language := range f.Translations {
+			if hasTranslation(t, language) {
+				continue
+			}
+			if err := w.s.TranslateTranscription(t, language, author); err != nil {
+				log.Error().Err(err).Msgf("Error translating %v to %v", t.Watch.Path, language)
+			}
+		}
+	}
+
+	source := t.Watch.Path
+	if f.MoveTo != "" {
+		moved, err := moveFile(source, filepath.Join(f.MoveTo, filepath.Base(source)))
+		if err != nil {
+			log.Error().Err(err).Msgf("Error moving %v to %v", source, f.MoveTo)
+		} else {
+			source = moved
+		}
+	}
+
+	if done {
+		base := strings.TrimSuffix(source, filepath.Ext(source))
+		for _, format := range f.Exports {
+			if err := writeExport(format, t, &t.Result, base+"."+format); err != nil {
+				log.Error().Err(err).Msgf("Error exporting %v as %v", t.Watch.Path, format)
+			}
+			for i := range t.Translations {
+				tr := &t.Translations[i]
+				if err := writeExport(format, t, &tr.Result, fmt.Sprintf("%v.%v.%v", base, tr.TargetLanguage, format)); err != nil {
+					log.Error().Err(err).Msgf("Error exporting %v as %v", t.Watch.Path, format)
+				}
+			}
+		}
+	}
+
+	err := database.UpdateWithRetry(w.s.Db, t, func(t *models.Transcription) {
+		t.Watch.Processed = true
+	})
+	if err != nil {
+		return err
+	}
+	w.s.BroadcastTranscription(t)
+	log.Info().Msgf("Processed %v from watched folder %v", t.Watch.Path, f.Path)
+	return nil
+}
+
+func hasTranslation(t *models.Transcription, language string) bool {
+	for _, tr := range t.Translations {
+		if tr.TargetLanguage == language {
+			return true
+		}
+	}
+	return false
+}
+
+// writeExport writes a result in the given format to a file, replacing it
+// atomically.
+func writeExport(format string, t *models.Transcription, res *models.WhisperResult, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = export.Write(format, t, res, export.DefaultOptions(), f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// moveFile moves a file without replacing an existing one: if dst is taken, a
+// number is added to its name, as in `name (1).ext`. It returns where the file
+// was moved to.
+func moveFile(src, dst string) (string, error) {
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	for i := 1; ; i++ {
+		err := move(src, dst)
+		if !errors.Is(err, fs.ErrExist) || i > 1000 {
+			return dst, err
+		}
+		dst = fmt.Sprintf("%v (%d)%v", base, i, ext)
+	}
+}
+
+// move links a file to dst and removes it, or copies it when it is moved to
+// another device. Unlike a rename, both fail if dst exists.
+func move(src, dst string) error {
+	err := os.Link(src, dst)
+	if errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	if err != nil {
+		// Another device, or a file system without hard links
+		if err := copyFile(src, dst); err != nil {
+			if !errors.Is(err, fs.ErrExist) {
+				os.Remove(dst)
+			}
+			return err
+		}
+	}
+	if err := os.Remove(src); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,155 @@
+package waveform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"codeberg.org/pluja/whishper/media"
+)
+
+// SampleRate is the rate the audio is decoded at to compute the peaks.
+const SampleRate = 8000
+
+// Levels are the zoom levels, in samples per pixel. Each one is 4 times coarser
+// than the previous one: from 250 to about 4 pixels per second.
+var Levels = []int{32, 128, 512, 2048}
+
+// Waveform holds the minimum and maximum sample of every pixel of a zoom level,
+// as 8-bit values. Its JSON is the format of audiowaveform, version 2.
+type Waveform struct {
+	Version         int    `json:"version"`
+	Channels        int    `json:"channels"`
+	SampleRate      int    `json:"sample_rate"`
+	SamplesPerPixel int    `json:"samples_per_pixel"`
+	Bits            int    `json:"bits"`
+	Length          int    `json:"length"`
+	Data            []int8 `json:"data"`
+}
+
+// Path returns the name of the peaks of a media file at a zoom level, which are
+// stored next to it.
+func Path(src string, level int) string {
+	return fmt.Sprintf("%v.waveform.%d.dat", src, level)
+}
+
+// Files returns the names of the peaks of a media file at every zoom level.
+func Files(src string) []string {
+	var files []string
+	for _, level := range Levels {
+		files = append(files, Path(src, level))
+	}
+	return files
+}
+
+// Generate computes the peaks of a media file at every zoom level, and returns
+// them in the binary format of audiowaveform, by level.
+func Generate(ctx context.Context, src string) (map[int][]byte, error) {
+	// The finest level is computed from the samples, and every other level from
+	// the previous one.
+	var peaks []int16
+	err := media.PCM(ctx, src, SampleRate, func(r io.Reader) error {
+		var err error
+		peaks, err = samplePeaks(bufio.NewReader(r), Levels[0])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[int][]byte)
+	for i, level := range Levels {
+		if i > 0 {
+			peaks = mergePeaks(peaks, level/Levels[i-1])
+		}
+		files[level] = encode(level, peaks)
+	}
+	return files, nil
+}
+
+// samplePeaks reads 16-bit samples and returns the minimum and maximum of every
+// block of the given size, interleaved.
+func samplePeaks(r io.Reader, size int) ([]int16, error) {
+	var peaks []int16
+	buf := make([]byte, 2*size)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n >= 2 {
+			lo, hi := int16(32767), int16(-32768)
+			for i := 0; i+1 < n; i += 2 {
+				s := int16(binary.LittleEndian.Uint16(buf[i:]))
+				if s < lo {
+					lo = s
+				}
+				if s > hi {
+					hi = s
+				}
+			}
+			peaks = append(peaks, lo, hi)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return peaks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// mergePeaks merges every factor pixels of interleaved peaks into one.
+func mergePeaks(peaks []int16, factor int) []int16 {
+	merged := make([]int16, 0, len(peaks)/factor+2)
+	for i := 0; i < len(peaks); i += 2 * factor {
+		lo, hi := peaks[i], peaks[i+1]
+		for j := i + 2; j < i+2*factor && j < len(peaks); j += 2 {
+			if peaks[j] < lo {
+				lo = peaks[j]
+			}
+			if peaks[j+1] > hi {
+				hi = peaks[j+1]
+			}
+		}
+		merged = append(merged, lo, hi)
+	}
+	return merged
+}
+
+// encode returns peaks in the binary format of audiowaveform, version 2, with
+// 8-bit values.
+func encode(level int, peaks []int16) []byte {
+	var buf bytes.Buffer
+	header := []int32{2, 1, SampleRate, int32(level), int32(len(peaks) / 2), 1}
+	binary.Write(&buf, binary.LittleEndian, header)
+	for _, p := range peaks {
+		buf.WriteByte(byte(int8(p >> 8)))
+	}
+	return buf.Bytes()
+}
+
+// Decode loads the peaks of a zoom level computed by Generate.
+func Decode(data []byte) (*Waveform, error) {
+	var header [6]int32
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("invalid waveform file: %w", err)
+	}
+	body := data[24:]
+	if header[0] != 2 || header[1]&1 == 0 || len(body) < 2*int(header[4]) {
+		return nil, errors.New("invalid waveform file")
+	}
+	w := &Waveform{
+		Version:         2,
+		Channels:        int(header[5]),
+		SampleRate:      int(header[2]),
+		SamplesPerPixel: int(header[3]),
+		Bits:            8,
+		Length:          int(header[4]),
+		Data:            make([]int8, 2*int(header[4])),
+	}
+	for i := range w.Data {
+		w.Data[i] = int8(body[i])
+	}
+	return w, nil
+}
@@ -1,18 +1,21 @@
 import numpy as np
 from .backend import Backend, Transcription, Segment
-import os, math
+import os, math, inspect
 from tqdm import tqdm  # type: ignore
 import uuid
 from faster_whisper import WhisperModel, download_model, decode_audio
+from models import DecodingOptions
 
 class FasterWhisperBackend(Backend):
     device: str = "cpu"  # cpu, cuda
     quantization: str = "int8"  # int8, float16
     model: WhisperModel | None = None
 
-    def __init__(self, model_size, device: str = "cpu"):
+    def __init__(self, model_size, device: str = "cpu", quantization: str = None):
         self.model_size = model_size
         self.device = device
+        if quantization:
+            self.quantization = quantization
         self.__post_init__()
 
     def model_path(self) -> str:
@@ -46,7 +49,9 @@ class FasterWhisperBackend(Backend):
             download_model(self.model_size, output_dir=local_model_path, local_files_only=False, cache_dir=local_model_cache)
 
     def transcribe(
-        self, input: np.ndarray, silent: bool = False, language: str = None
+        self, input: np.ndarray, silent: bool = False, language: str = None, task: str = "transcribe",
+        initial_prompt: str = None, hotwords: list[str] = None,
+        options: DecodingOptions = None
     ) -> Transcription:
         """
         Return word level transcription data.
@@ -54,18 +59,40 @@ class FasterWhisperBackend(Backend):
         """
         result: list[Segment] = []
         assert self.model is not None
+        if options is None:
+            options = DecodingOptions()
+        extra = {}
+        if options.vad_filter:
+            extra["vad_parameters"] = {
+                "threshold": options.vad_threshold,
+                "min_silence_duration_ms": options.vad_min_silence_ms,
+                "speech_pad_ms": options.vad_speech_pad_ms,
+            }
+        if hotwords:
+            if "hotwords" in inspect.signature(self.model.transcribe).parameters:
+                extra["hotwords"] = ", ".join(hotwords)
+            else:
+                # Older faster-whisper versions have no hotwords, but the prompt
+                # has the same effect of biasing the decoder towards the terms.
+                initial_prompt = " ".join(filter(None, [initial_prompt, ", ".join(hotwords) + "."]))
         segments, info = self.model.transcribe(
             input,
-            beam_size=5,
-            word_timestamps=True,
+            beam_size=options.beam_size,
+            temperature=options.temperature,
+            vad_filter=options.vad_filter,
+            word_timestamps=options.word_timestamps,
+            condition_on_previous_text=options.condition_on_previous_text,
             language=language,
+            task=task,
+            initial_prompt=initial_prompt,
+            **extra,
         )
         # ps = playback seconds
         with tqdm(
             total=info.duration, unit_scale=True, unit="ps", disable=silent
         ) as pbar:
             for segment in segments:
-                if segment.words is None:
+                if segment.words is None and options.word_timestamps:
                     continue
                 id = uuid.uuid4().hex
                 segment_extract: Segment = {
@@ -81,7 +108,7 @@ class FasterWhisperBackend(Backend):
                             "word": w.word,
                             "score": round(w.probability, 2),
                         }
-                        for w in segment.words
+                        for w in segment.words or []
                     ],
                 }
                 result.append(segment_extract)
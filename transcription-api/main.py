@@ -1,6 +1,6 @@
 from dotenv import load_dotenv
-from fastapi import FastAPI, UploadFile, File
-from models import ModelSize, Languages, DeviceType
+from fastapi import FastAPI, UploadFile, File, HTTPException
+from models import ModelSize, Languages, DeviceType, TaskType, DecodingOptions
 from transcribe import transcribe_file, transcribe_from_filename
 import uvicorn
 import os
@@ -15,18 +15,53 @@ async def transcribe_endpoint(file: UploadFile = File(None),
                               filename: str = None,
                               model_size: ModelSize = ModelSize.small, 
                               language: Languages = Languages.auto,
-                              device: str = "cpu"):
+                              task: TaskType = TaskType.transcribe,
+                              device: str = "cpu",
+                              initial_prompt: str = None,
+                              hotwords: str = None,
+                              beam_size: int = 5,
+                              temperature: str = "0,0.2,0.4,0.6,0.8,1",
+                              vad_filter: bool = False,
+                              vad_threshold: float = 0.5,
+                              vad_min_silence_ms: int = 2000,
+                              vad_speech_pad_ms: int = 400,
+                              word_timestamps: bool = True,
+                              condition_on_previous_text: bool = True,
+                              compute_type: str = None,
+                              diarize: bool = False,
+                              num_speakers: int = None):
     
+    # Speakers are not labelled, so asking for them fails rather than returning
+    # a result without them
+    if diarize:
+        raise HTTPException(status_code=400, detail="Speaker diarization is not supported")
+
     if device != "cpu" and device != "cuda":
         return {"detail": "Device must be either cpu or cuda"}
     
+    # Hotwords are sent as a comma separated list
+    hotword_list = [w.strip() for w in hotwords.split(",") if w.strip()] if hotwords else None
+
+    # Temperatures are sent as a comma separated list
+    options = DecodingOptions(
+        beam_size=beam_size,
+        temperature=[float(t) for t in temperature.split(",") if t.strip()],
+        vad_filter=vad_filter,
+        vad_threshold=vad_threshold,
+        vad_min_silence_ms=vad_min_silence_ms,
+        vad_speech_pad_ms=vad_speech_pad_ms,
+        word_timestamps=word_timestamps,
+        condition_on_previous_text=condition_on_previous_text,
+        compute_type=compute_type,
+    )
+
     print(f"Transcribing with model {model_size.value} on device {device}...")
     if file is not None:
         # if a file is uploaded, use it
-        return await transcribe_file(file, model_size.value, language.value, device)
+        return await transcribe_file(file, model_size.value, language.value, device, task.value, initial_prompt, hotword_list, options)
     elif filename is not None:
         # if a filename is provided, use it
-        return await transcribe_from_filename(filename, model_size.value, language.value, device)
+        return await transcribe_from_filename(filename, model_size.value, language.value, device, task.value, initial_prompt, hotword_list, options)
     else:
         return {"detail": "No file uploaded and no filename provided"}
 
@@ -5,6 +5,21 @@ class DeviceType(str, Enum):
     cpu = "cpu"
     cuda = "cuda"
 
+class DecodingOptions(BaseModel):
+    beam_size: int = 5
+    temperature: list[float] = [0.0, 0.2, 0.4, 0.6, 0.8, 1.0]
+    vad_filter: bool = False
+    vad_threshold: float = 0.5
+    vad_min_silence_ms: int = 2000
+    vad_speech_pad_ms: int = 400
+    word_timestamps: bool = True
+    condition_on_previous_text: bool = True
+    compute_type: str | None = None
+
+class TaskType(str, Enum):
+    transcribe = "transcribe"
+    translate = "translate"
+
 class ModelSize(str, Enum):
     tiny_en = "tiny.en"
     tiny = "tiny"
@@ -1,7 +1,7 @@
 from backends.fasterwhisper import FasterWhisperBackend
 from backends.backend import Transcription
 from faster_whisper import decode_audio
-from models import DeviceType
+from models import DeviceType, DecodingOptions
 from typing import Optional
 import numpy as np
 import io
@@ -13,18 +13,26 @@ def convert_audio(file) -> np.ndarray:
 async def transcribe_from_filename(filename: str,
                                     model_size: int,
                                     language: Optional[str] = None,
-                                    device: DeviceType = DeviceType.cpu) -> Transcription:
+                                    device: DeviceType = DeviceType.cpu,
+                                    task: str = "transcribe",
+                                    initial_prompt: Optional[str] = None,
+                                    hotwords: Optional[list[str]] = None,
+                                    options: Optional[DecodingOptions] = None) -> Transcription:
     
     filepath = os.path.join(os.environ["UPLOAD_DIR"], filename)
     if not os.path.exists(filepath):
         raise RuntimeError(f"file not found in {filepath}")
     audio = convert_audio(filepath)
-    return await transcribe_audio(audio, model_size, language, device)
+    return await transcribe_audio(audio, model_size, language, device, task, initial_prompt, hotwords, options)
 
 async def transcribe_file(file: io.BytesIO, 
                           model_size: int, 
                           language: Optional[str] = None, 
-                          device: DeviceType = DeviceType.cpu) -> Transcription:
+                          device: DeviceType = DeviceType.cpu,
+                          task: str = "transcribe",
+                          initial_prompt: Optional[str] = None,
+                          hotwords: Optional[list[str]] = None,
+                          options: Optional[DecodingOptions] = None) -> Transcription:
     contents = await file.read()  # async read
     if len(contents) < 150 * 1024 * 1024:  # file is smaller than 150MB
             audio = convert_audio(io.BytesIO(contents))
@@ -38,19 +46,26 @@ async def transcribe_file(file: io.BytesIO,
         # Corrected to use the function in this file
         audio = convert_audio(file.filename)
         os.remove(file.filename)
-    return await transcribe_audio(audio, model_size, language, device)
+    return await transcribe_audio(audio, model_size, language, device, task, initial_prompt, hotwords, options)
 
 async def transcribe_audio(audio: np.ndarray, 
                            model_size: int, 
                            language: Optional[str] = None, 
-                           device: DeviceType = DeviceType.cpu) -> Transcription:
+                           device: DeviceType = DeviceType.cpu,
+                           task: str = "transcribe",
+                           initial_prompt: Optional[str] = None,
+                           hotwords: Optional[list[str]] = None,
+                           options: Optional[DecodingOptions] = None) -> Transcription:
     
     if language == "auto":
         language = None
+    if options is None:
+        options = DecodingOptions()
 
     # Load the model
-    model = FasterWhisperBackend(model_size=model_size, device=device)
+    model = FasterWhisperBackend(model_size=model_size, device=device, quantization=options.compute_type)
     model.get_model()
     model.load()
     # Transcribe the file
-    return model.transcribe(audio, silent=True, language=language)
+    return model.transcribe(audio, silent=True, language=language, task=task,
+                            initial_prompt=initial_prompt, hotwords=hotwords, options=options)